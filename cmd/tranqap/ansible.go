@@ -0,0 +1,461 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ansibleAll       = "all"
+	ansibleUngrouped = "ungrouped"
+)
+
+// ansibleRangeRe matches a numeric host range in an inventory, e.g. [01:20]
+var ansibleRangeRe = regexp.MustCompile(`\[([0-9]+):([0-9]+)\]`)
+
+// ansibleAlphaRangeRe matches an alphabetic host range in an inventory, e.g. [a:f]
+var ansibleAlphaRangeRe = regexp.MustCompile(`\[([a-zA-Z]):([a-zA-Z])\]`)
+
+// ansibleBadRangeRe matches the ranges, which are left after the supported ones
+// are expanded, e.g. ranges with a step like [1:10:2]
+var ansibleBadRangeRe = regexp.MustCompile(`\[[^\]]*:[^\]]*\]`)
+
+// ansiblePortRe matches a host with a port, e.g. web1:2222
+var ansiblePortRe = regexp.MustCompile(`^(.+):([0-9]+)$`)
+
+// ansibleInventory contains the hosts and the groups from an Ansible inventory.
+// hosts are in the order they appear in the inventory.
+type ansibleInventory struct {
+	hosts    []string
+	hostVars map[string]map[string]string
+	groups   map[string]*ansibleGroup
+}
+
+type ansibleGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+// ansibleHost is a host with all variables inherited from its groups.
+// groups contains all groups of the host, including the parents of its groups.
+type ansibleHost struct {
+	name   string
+	vars   map[string]string
+	groups []string
+}
+
+// ansibleYAMLGroup is a group in YAML inventory
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]interface{}
+	Vars     map[string]interface{}
+	Children map[string]ansibleYAMLGroup
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostVars: make(map[string]map[string]string),
+		groups:   make(map[string]*ansibleGroup),
+	}
+}
+
+func (inv *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: make(map[string]string)}
+		inv.groups[name] = g
+	}
+
+	return g
+}
+
+// addHost adds a host to a group. The host can be followed by a port, e.g.
+// web1:2222, which is set as ansible_port, unless it is already in vars. Ranges
+// in the host name are expanded.
+func (inv *ansibleInventory) addHost(group string, pattern string, vars map[string]string) error {
+	// IPv6 addresses without a port have got more colons outside the ranges
+	if m := ansiblePortRe.FindStringSubmatch(pattern); m != nil &&
+		!strings.Contains(ansibleBadRangeRe.ReplaceAllString(m[1], ""), ":") {
+		pattern = m[1]
+		if _, ok := vars["ansible_port"]; !ok {
+			withPort := map[string]string{"ansible_port": m[2]}
+			for k, v := range vars {
+				withPort[k] = v
+			}
+			vars = withPort
+		}
+	}
+
+	patterns, err := expandAnsibleAlphaRanges(pattern)
+	if err != nil {
+		return err
+	}
+
+	g := inv.group(group)
+	for _, p := range patterns {
+		p = ansibleRangeRe.ReplaceAllString(p, "[$1-$2]")
+		if r := ansibleBadRangeRe.FindString(p); len(r) > 0 {
+			return fmt.Errorf("unsupported range %s in %s", r, pattern)
+		}
+
+		hosts, err := expandHostPattern(p)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range hosts {
+			if _, exists := inv.hostVars[h.host]; !exists {
+				inv.hosts = append(inv.hosts, h.host)
+				inv.hostVars[h.host] = make(map[string]string)
+			}
+			for k, v := range vars {
+				inv.hostVars[h.host][k] = v
+			}
+			g.hosts = append(g.hosts, h.host)
+		}
+	}
+
+	return nil
+}
+
+// expandAnsibleAlphaRanges expands the alphabetic ranges of a host pattern, e.g.
+// db-[a:c] expands to db-a, db-b and db-c. The numeric ranges are not changed.
+func expandAnsibleAlphaRanges(pattern string) ([]string, error) {
+	loc := ansibleAlphaRangeRe.FindStringSubmatchIndex(pattern)
+	if loc == nil {
+		return []string{pattern}, nil
+	}
+
+	first, last := pattern[loc[2]], pattern[loc[4]]
+	if first > last || (first <= 'Z') != (last <= 'Z') {
+		return nil, fmt.Errorf("bad range %s in %s", pattern[loc[0]:loc[1]], pattern)
+	}
+
+	rest, err := expandAnsibleAlphaRanges(pattern[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+
+	if int(last-first+1)*len(rest) > maxExpandedHosts {
+		return nil, fmt.Errorf("%s expands to more than %d hosts", pattern, maxExpandedHosts)
+	}
+
+	ret := make([]string, 0, int(last-first+1)*len(rest))
+	for c := first; c <= last; c++ {
+		for _, r := range rest {
+			ret = append(ret, pattern[:loc[0]]+string(c)+r)
+		}
+	}
+
+	return ret, nil
+}
+
+// parseAnsibleINI parses inventory in INI format
+func parseAnsibleINI(data []byte) (*ansibleInventory, error) {
+	inv := newAnsibleInventory()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	section := ansibleUngrouped
+	kind := "hosts"
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			kind = "hosts"
+			if parts := strings.SplitN(section, ":", 2); len(parts) == 2 {
+				section, kind = parts[0], parts[1]
+			}
+			if kind != "hosts" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: unknown section type %s", lineNo, kind)
+			}
+			inv.group(section)
+			continue
+		}
+
+		fields, err := splitAnsibleLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+
+		switch kind {
+		case "hosts":
+			vars, err := parseAnsibleVars(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			if err := inv.addHost(section, fields[0], vars); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+		case "vars":
+			// One variable per line. The value can contain spaces, so the line is
+			// split only on the first =.
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("line %d: bad variable %s", lineNo, line)
+			}
+			value, err := splitAnsibleLine(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			inv.group(section).vars[strings.TrimSpace(kv[0])] = strings.Join(value, " ")
+		case "children":
+			inv.group(fields[0])
+			g := inv.group(section)
+			g.children = append(g.children, fields[0])
+		}
+	}
+
+	return inv, scanner.Err()
+}
+
+// splitAnsibleLine splits a line on whitespace. Quoted values are kept together
+// and the quotes are removed.
+func splitAnsibleLine(line string) ([]string, error) {
+	ret := make([]string, 0)
+	var cur strings.Builder
+	var quote rune
+	inField := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				ret = append(ret, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		case r == '#' && !inField:
+			// Comment till the end of the line
+			return ret, nil
+		default:
+			cur.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		ret = append(ret, cur.String())
+	}
+
+	return ret, nil
+}
+
+// parseAnsibleVars parses a list of key=value pairs
+func parseAnsibleVars(fields []string) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad variable %s", f)
+		}
+		ret[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return ret, nil
+}
+
+// parseAnsibleYAML parses inventory in YAML format
+func parseAnsibleYAML(data []byte) (*ansibleInventory, error) {
+	var groups map[string]ansibleYAMLGroup
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+
+	inv := newAnsibleInventory()
+	for _, name := range sortedKeys(groups) {
+		if err := inv.addYAMLGroup(name, groups[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return inv, nil
+}
+
+func (inv *ansibleInventory) addYAMLGroup(name string, yg ansibleYAMLGroup) error {
+	g := inv.group(name)
+
+	for k, v := range yg.Vars {
+		g.vars[k] = fmt.Sprint(v)
+	}
+
+	hosts := make([]string, 0, len(yg.Hosts))
+	for h := range yg.Hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	for _, h := range hosts {
+		vars := make(map[string]string)
+		for k, v := range yg.Hosts[h] {
+			vars[k] = fmt.Sprint(v)
+		}
+		if err := inv.addHost(name, h, vars); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range sortedKeys(yg.Children) {
+		g.children = append(g.children, child)
+		if err := inv.addYAMLGroup(child, yg.Children[child]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]ansibleYAMLGroup) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// parents returns the groups, which contain the group directly
+func (inv *ansibleInventory) parents(group string) []string {
+	ret := make([]string, 0)
+	for name, g := range inv.groups {
+		for _, c := range g.children {
+			if c == group {
+				ret = append(ret, name)
+			}
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// hostGroups returns all groups of a host ordered by depth - the groups which
+// contain the host directly are first, then their parents and so on. all is last.
+func (inv *ansibleInventory) hostGroups(host string) []string {
+	ret := make([]string, 0)
+	seen := map[string]bool{ansibleAll: true}
+
+	level := make([]string, 0)
+	names := make([]string, 0, len(inv.groups))
+	for name := range inv.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, h := range inv.groups[name].hosts {
+			if h == host && !seen[name] {
+				seen[name] = true
+				level = append(level, name)
+				break
+			}
+		}
+	}
+
+	for len(level) > 0 {
+		ret = append(ret, level...)
+		next := make([]string, 0)
+		for _, g := range level {
+			for _, p := range inv.parents(g) {
+				if !seen[p] {
+					seen[p] = true
+					next = append(next, p)
+				}
+			}
+		}
+		level = next
+	}
+
+	return append(ret, ansibleAll)
+}
+
+// inGroup returns true if the host is in the group or in any of its children
+func (inv *ansibleInventory) inGroup(host string, group string) bool {
+	for _, g := range inv.hostGroups(host) {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvedHosts returns the hosts of the inventory with their variables. If group
+// is not empty, only the hosts from the group are returned. Host variables take
+// precedence over group variables and the variables of child groups take
+// precedence over the variables of their parents.
+func (inv *ansibleInventory) resolvedHosts(group string) ([]ansibleHost, error) {
+	if len(group) > 0 && group != ansibleAll {
+		if _, ok := inv.groups[group]; !ok {
+			return nil, fmt.Errorf("group %s is not found in the inventory", group)
+		}
+	}
+
+	ret := make([]ansibleHost, 0)
+	for _, h := range inv.hosts {
+		if len(group) > 0 && !inv.inGroup(h, group) {
+			continue
+		}
+
+		host := ansibleHost{h, make(map[string]string), make([]string, 0)}
+		for k, v := range inv.hostVars[h] {
+			host.vars[k] = v
+		}
+
+		for _, g := range inv.hostGroups(h) {
+			if grp, ok := inv.groups[g]; ok {
+				for k, v := range grp.vars {
+					if _, set := host.vars[k]; !set {
+						host.vars[k] = v
+					}
+				}
+			}
+			if g != ansibleAll && g != ansibleUngrouped {
+				host.groups = append(host.groups, g)
+			}
+		}
+
+		ret = append(ret, host)
+	}
+
+	return ret, nil
+}
+
+// ansibleVar returns the first of the variables, which is set
+func (h ansibleHost) ansibleVar(names ...string) (string, bool) {
+	for _, n := range names {
+		if v, ok := h.vars[n]; ok {
+			return v, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var iniInventory = `# Inventory
+mail.example.com
+
+[web]
+web1 ansible_host=10.0.0.1 ansible_port=2222
+web[02:03].example.com
+
+[db]
+db1 ansible_host=10.0.1.1 ansible_user="db admin"
+
+[web:vars]
+ansible_user = deploy
+ansible_ssh_private_key_file=~/.ssh/deploy
+
+[prod:children]
+web
+db
+
+[prod:vars]
+ansible_user=prod
+`
+
+var yamlInventory = `all:
+  hosts:
+    mail.example.com:
+  children:
+    prod:
+      vars:
+        ansible_user: prod
+      children:
+        web:
+          vars:
+            ansible_user: deploy
+            ansible_ssh_private_key_file: ~/.ssh/deploy
+          hosts:
+            web1:
+              ansible_host: 10.0.0.1
+              ansible_port: 2222
+        db:
+          hosts:
+            db1:
+              ansible_host: 10.0.1.1
+              ansible_user: db admin
+`
+
+func checkInventory(t *testing.T, inv *ansibleInventory) {
+	hosts, err := inv.resolvedHosts("prod")
+	if err != nil {
+		t.Fatalf("Error resolving hosts: %s", err)
+	}
+
+	targets := make(map[string]target)
+	for _, h := range hosts {
+		tgt, err := ansibleTarget(h)
+		if err != nil {
+			t.Fatalf("Error converting %s: %s", h.name, err)
+		}
+		targets[h.name] = tgt
+	}
+
+	if _, ok := targets["mail.example.com"]; ok {
+		t.Errorf("mail.example.com is not in prod group")
+	}
+
+	web1, ok := targets["web1"]
+	if !ok {
+		t.Fatalf("web1 not found")
+	}
+	if *web1.Host != "10.0.0.1" || *web1.Port != 2222 || *web1.User != "deploy" || *web1.Key != "~/.ssh/deploy" {
+		t.Errorf("Bad web1: %s %d %s %s", *web1.Host, *web1.Port, *web1.User, *web1.Key)
+	}
+	if !reflect.DeepEqual(web1.Tags, []string{"web", "prod"}) {
+		t.Errorf("Bad tags for web1: %v", web1.Tags)
+	}
+
+	db1, ok := targets["db1"]
+	if !ok {
+		t.Fatalf("db1 not found")
+	}
+	if *db1.Host != "10.0.1.1" || db1.Port != nil || *db1.User != "db admin" || db1.Key != nil {
+		t.Errorf("Bad db1: %s %v %s %v", *db1.Host, db1.Port, *db1.User, db1.Key)
+	}
+}
+
+func TestAnsibleINI(t *testing.T) {
+	inv, err := parseAnsibleINI([]byte(iniInventory))
+	if err != nil {
+		t.Fatalf("Error parsing inventory: %s", err)
+	}
+
+	checkInventory(t, inv)
+
+	hosts, err := inv.resolvedHosts("")
+	if err != nil {
+		t.Fatalf("Error resolving hosts: %s", err)
+	}
+	names := make([]string, 0)
+	for _, h := range hosts {
+		names = append(names, h.name)
+	}
+	expected := []string{"mail.example.com", "web1", "web02.example.com", "web03.example.com", "db1"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Bad hosts: %v", names)
+	}
+
+	if _, err := inv.resolvedHosts("nonexistent"); err == nil {
+		t.Errorf("Expected error for unknown group")
+	}
+}
+
+func TestAnsibleINIGroupVars(t *testing.T) {
+	inv, err := parseAnsibleINI([]byte("[web:vars]\nansible_ssh_common_args=\"-o Foo=bar\"\nansible_user = deploy user\n"))
+	if err != nil {
+		t.Fatalf("Error parsing inventory: %s", err)
+	}
+
+	vars := inv.group("web").vars
+	if vars["ansible_ssh_common_args"] != "-o Foo=bar" || vars["ansible_user"] != "deploy user" {
+		t.Errorf("Bad group vars: %v", vars)
+	}
+
+	if _, err := parseAnsibleINI([]byte("[web:vars]\nansible_user\n")); err == nil {
+		t.Errorf("Expected error for variable without value")
+	}
+}
+
+func TestAnsibleINIHostPatterns(t *testing.T) {
+	inv, err := parseAnsibleINI([]byte("[web]\nweb1:2222\nweb2:2200 ansible_port=22\ndb-[a:c]:2022\nfe80::1\n"))
+	if err != nil {
+		t.Fatalf("Error parsing inventory: %s", err)
+	}
+
+	expected := []string{"web1", "web2", "db-a", "db-b", "db-c", "fe80::1"}
+	if !reflect.DeepEqual(inv.hosts, expected) {
+		t.Errorf("Expected hosts %v, got %v", expected, inv.hosts)
+	}
+
+	ports := map[string]string{"web1": "2222", "web2": "22", "db-a": "2022", "db-c": "2022", "fe80::1": ""}
+	for h, port := range ports {
+		if inv.hostVars[h]["ansible_port"] != port {
+			t.Errorf("Expected port %q for %s, got %q", port, h, inv.hostVars[h]["ansible_port"])
+		}
+	}
+
+	for _, bad := range []string{"db-[c:a]", "db-[a:C]", "web[1:10:2]"} {
+		_, err := parseAnsibleINI([]byte("[web]\nweb1\n" + bad + "\n"))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+			t.Errorf("Expected error at line 3 for %s, got %v", bad, err)
+		}
+	}
+}
+
+func TestAnsibleYAML(t *testing.T) {
+	inv, err := parseAnsibleYAML([]byte(yamlInventory))
+	if err != nil {
+		t.Fatalf("Error parsing inventory: %s", err)
+	}
+
+	checkInventory(t, inv)
+}
+
+func TestMergeImportedTargets(t *testing.T) {
+	conf := `defaults:
+  destination: pcaps
+targets:
+# Manually added
+- name: web1
+  host: 192.168.0.1
+  file_pattern: web1
+  tags: [manual]
+`
+	inv, err := parseAnsibleINI([]byte(iniInventory))
+	if err != nil {
+		t.Fatalf("Error parsing inventory: %s", err)
+	}
+	hosts, _ := inv.resolvedHosts("web")
+
+	targets := make([]target, 0)
+	for _, h := range hosts {
+		tgt, _ := ansibleTarget(h)
+		targets = append(targets, tgt)
+	}
+
+	res, added, updated, err := mergeImportedTargets([]byte(conf), targets)
+	if err != nil {
+		t.Fatalf("Error merging targets: %s", err)
+	}
+	if added != 2 || updated != 1 {
+		t.Errorf("Expected 2 added and 1 updated targets. Got %d and %d", added, updated)
+	}
+
+	if !strings.Contains(string(res), "# Manually added\n- name: web1\n  host: 10.0.0.1\n  file_pattern: web1\n  tags: [web, prod, manual]\n") {
+		t.Errorf("web1 is not updated correctly:\n%s", res)
+	}
+
+	parsed, err := parseConfig(res, ".")
+	if err != nil {
+		t.Fatalf("Error parsing the result: %s", err)
+	}
+	if len(parsed.Targets) != 3 {
+		t.Errorf("Expected 3 targets. Got %d", len(parsed.Targets))
+	}
+	if *parsed.Targets[2].FilePattern != "web03.example.com" || *parsed.Targets[2].Destination != "pcaps" {
+		t.Errorf("Bad imported target: %s %s", *parsed.Targets[2].FilePattern, *parsed.Targets[2].Destination)
+	}
+}
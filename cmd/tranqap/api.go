@@ -0,0 +1,342 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// apiTarget is a target, as returned by the HTTP API
+type apiTarget struct {
+	Name    string   `json:"name"`
+	Host    string   `json:"host,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Running bool     `json:"running"`
+}
+
+// apiFile is a capture file, as returned by the HTTP API
+type apiFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// apiCaptureRequest is the body of start and stop requests. All fields are
+// optional. Empty Targets means all targets. Profile and Label are used only
+// by start.
+type apiCaptureRequest struct {
+	Targets []string `json:"targets"`
+	Profile string   `json:"profile"`
+	Label   string   `json:"label"`
+}
+
+// apiMarkRequest is the body of mark requests
+type apiMarkRequest struct {
+	Text string `json:"text"`
+}
+
+// apiServer is the HTTP API. The commands are executed with the same
+// commandRunner as the commands from the control socket.
+type apiServer struct {
+	runner *commandRunner
+	server *http.Server
+}
+
+func newAPIServer(runner *commandRunner) *apiServer {
+	s := &apiServer{runner: runner}
+	s.server = &http.Server{Handler: s.handler()}
+
+	return s
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", s.handleTargets)
+	mux.HandleFunc("/targets/", s.handleFiles)
+	mux.HandleFunc("/start", s.handleStart)
+	mux.HandleFunc("/stop", s.handleStop)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/mark", s.handleMark)
+	mux.Handle("/metrics", metricsHandler{configTargetNames(s.runner.lc)})
+
+	return checkHost(mux)
+}
+
+// start starts listening on addr. Requests are served in the background. The
+// API has got no authentication, so addr has to be a loopback address.
+func (s *apiServer) start(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("The HTTP API has got no authentication, so it can listen only on a loopback address, e.g. 127.0.0.1:8787")
+	}
+
+	return serveHTTP(s.server, addr, "HTTP API")
+}
+
+// isLoopbackHost returns true if host is localhost or a loopback IP address
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkHost rejects the requests, which are not sent to a loopback address.
+// This way a web page can't reach the API with DNS rebinding.
+func checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if !isLoopbackHost(host) {
+			writeAPIError(w, http.StatusForbidden, "Host %s is not allowed", r.Host)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveHTTP starts listening on addr and serves the requests in the background.
+// name is used in the log.
+func serveHTTP(server *http.Server, addr string, name string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := server.Serve(l); err != http.ErrServerClosed {
+			tqlog.Error("%s stopped: %s", name, err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *apiServer) close() {
+	s.server.Close()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		tqlog.Error("Error writing HTTP API response: %s", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, code int, format string, a ...interface{}) {
+	writeJSON(w, code, ctlResponse{Error: fmt.Sprintf(format, a...)})
+}
+
+// checkMethod returns false and writes an error if the request method is not method
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeAPIError(w, http.StatusMethodNotAllowed, "Method %s is not allowed. Use %s", r.Method, method)
+	return false
+}
+
+// decodeBody decodes the JSON body of a request into v. The body is optional,
+// but Content-Type has to be application/json, so that a web page can't send
+// the request without a CORS preflight. Returns false and writes an error if the
+// body can't be decoded.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "Bad request body: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// GET /targets
+func (s *apiServer) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	cfg := s.runner.lc.get()
+	ret := make([]apiTarget, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		at := apiTarget{Name: *t.Name, Tags: t.Tags, Running: capturers.Running(*t.Name)}
+		if t.Host != nil {
+			at.Host = *t.Host
+		}
+		ret = append(ret, at)
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// runCaptureCommand executes start or stop with the arguments from the request body
+func (s *apiServer) runCaptureCommand(w http.ResponseWriter, r *http.Request, command string) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req apiCaptureRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	args := append([]string{}, req.Targets...)
+	if len(req.Profile) > 0 {
+		args = append(args, "--profile", req.Profile)
+	}
+	if len(req.Label) > 0 {
+		args = append(args, "--label", req.Label)
+	}
+
+	resp := s.runner.run(command, args)
+	if !resp.OK {
+		writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST /start
+func (s *apiServer) handleStart(w http.ResponseWriter, r *http.Request) {
+	s.runCaptureCommand(w, r, "start")
+}
+
+// POST /stop
+func (s *apiServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	s.runCaptureCommand(w, r, "stop")
+}
+
+// POST /mark
+func (s *apiServer) handleMark(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req apiMarkRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	resp := s.runner.run("mark", []string{req.Text})
+	if !resp.OK {
+		writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /status
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, getCaptureStatus())
+}
+
+// GET /targets/<name>/files lists the capture files of a target.
+// GET /targets/<name>/files/<file> downloads a file.
+func (s *apiServer) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/targets/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "files" {
+		writeAPIError(w, http.StatusNotFound, "Not found: %s", r.URL.Path)
+		return
+	}
+
+	cfg := s.runner.lc.get()
+	targets, err := cfg.selectTargets(parts[:1])
+	if err != nil || len(targets) != 1 {
+		writeAPIError(w, http.StatusNotFound, "Target <%s> doesn't exist", parts[0])
+		return
+	}
+	t := targets[0]
+
+	files, err := captureFiles(t)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Error listing the files of target <%s>: %s", *t.Name, err)
+		return
+	}
+
+	if len(parts) == 2 {
+		writeJSON(w, http.StatusOK, files)
+		return
+	}
+
+	// Only the files of the target can be downloaded
+	for _, f := range files {
+		if f.Name == parts[2] {
+			http.ServeFile(w, r, filepath.Join(*t.Destination, f.Name))
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "File %s of target <%s> doesn't exist", parts[2], *t.Name)
+}
+
+// captureFiles returns the capture files of the target - the current file, the
+// rotated ones and the files of the captures with a label
+func captureFiles(t target) ([]apiFile, error) {
+	ret := make([]apiFile, 0)
+	if t.Destination == nil || t.FilePattern == nil {
+		return ret, nil
+	}
+
+	re, err := regexp.Compile(`^` + regexp.QuoteMeta(*t.FilePattern) + `(-[A-Za-z0-9._-]+)?(\.[0-9]+)?\.pcap$`)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(*t.Destination)
+	if os.IsNotExist(err) {
+		// Nothing is captured yet
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Mode().IsRegular() && re.MatchString(e.Name()) {
+			ret = append(ret, apiFile{e.Name(), e.Size(), e.ModTime()})
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pcaps := filepath.Join(dir, "pcaps")
+	if err := os.Mkdir(pcaps, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"t1.pcap", "t1.1.pcap", "t1-case1.pcap", "t10.pcap", "t1.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(pcaps, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := fmt.Sprintf(`targets:
+- name: t1
+  host: 10.0.0.1
+  destination: %s
+  file_pattern: t1
+  tags: [web]
+- name: t10
+  destination: %s
+  file_pattern: t10
+`, pcaps, pcaps)
+	fname := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(fname, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lc, err := newLoadedConfig(fname)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	initStorage()
+	runner := newCommandRunner(lc)
+	defer runner.close()
+	server := httptest.NewServer(newAPIServer(runner).handler())
+	defer server.Close()
+
+	get := func(path string, code int) []byte {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Error getting %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("Expected code %d for %s, got %d", code, path, resp.StatusCode)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		return body
+	}
+
+	var targets []apiTarget
+	if err := json.Unmarshal(get("/targets", http.StatusOK), &targets); err != nil {
+		t.Fatalf("Bad targets response: %s", err)
+	}
+	if len(targets) != 2 || targets[0].Name != "t1" || targets[0].Host != "10.0.0.1" || targets[0].Running {
+		t.Errorf("Bad targets: %v", targets)
+	}
+
+	var files []apiFile
+	if err := json.Unmarshal(get("/targets/t1/files", http.StatusOK), &files); err != nil {
+		t.Fatalf("Bad files response: %s", err)
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "t1-case1.pcap t1.1.pcap t1.pcap" {
+		t.Errorf("Bad files: %v", names)
+	}
+
+	if body := get("/targets/t1/files/t1.1.pcap", http.StatusOK); string(body) != "t1.1.pcap" {
+		t.Errorf("Bad file content: %s", body)
+	}
+	get("/targets/t1/files/t10.pcap", http.StatusNotFound)
+	get("/targets/t1/files/..%2Ft1.txt", http.StatusNotFound)
+	get("/targets/t2/files", http.StatusNotFound)
+
+	if body := get("/status", http.StatusOK); strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("Bad status: %s", body)
+	}
+
+	post := func(path string, body string, code int) ctlResponse {
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error posting %s: %s", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Errorf("Expected code %d for %s, got %d", code, path, resp.StatusCode)
+		}
+		var ret ctlResponse
+		json.NewDecoder(resp.Body).Decode(&ret)
+		return ret
+	}
+
+	if resp := post("/stop", "", http.StatusBadRequest); resp.Error != "There are no running captures." {
+		t.Errorf("Bad stop response: %v", resp)
+	}
+	if resp := post("/start", `{"targets": ["t1"], "label": "../x"}`, http.StatusBadRequest); !strings.HasPrefix(resp.Error, "Invalid label") {
+		t.Errorf("Bad start response: %v", resp)
+	}
+	post("/start", `{"targets": `, http.StatusBadRequest)
+	get("/start", http.StatusMethodNotAllowed)
+
+	resp, err := http.Post(server.URL+"/stop", "text/plain", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Error posting /stop: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected code %d for text/plain body, got %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/status", nil)
+	req.Host = "attacker.example.com:8787"
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error getting /status: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected code %d for a request to another host, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestAPIServerLoopback(t *testing.T) {
+	for _, addr := range []string{":8787", "0.0.0.0:8787", "192.168.0.1:8787"} {
+		if err := (&apiServer{}).start(addr); err == nil {
+			t.Errorf("Expected error for %s", addr)
+		}
+	}
+
+	for _, host := range []string{"localhost", "127.0.0.1", "127.1.2.3", "::1", "[::1]"} {
+		if !isLoopbackHost(host) {
+			t.Errorf("%s is a loopback host", host)
+		}
+	}
+}
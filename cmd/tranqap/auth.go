@@ -0,0 +1,205 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// certSuffix is appended to the private key path by OpenSSH when looking for
+// the certificate of the key
+const certSuffix = "-cert.pub"
+
+// getAuthMethods returns the SSH authentication methods for the target.
+// If there is a certificate for the private key, certificate authentication is
+// tried first and then authentication with the plain key. Keys and certificates
+// from ssh-agent are tried last.
+func getAuthMethods(t *target) ([]ssh.AuthMethod, error) {
+	ret := make([]ssh.AuthMethod, 0, 2)
+	signers := make([]ssh.Signer, 0, 2)
+
+	if t.Key != nil {
+		key, err := ioutil.ReadFile(*t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %v", err)
+		}
+
+		// Create the Signer for this private key.
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %v", err)
+		}
+
+		certPath := *t.Key + certSuffix
+		if t.Certificate != nil {
+			certPath = *t.Certificate
+		}
+
+		certSigner, err := loadCertSigner(certPath, signer)
+		if err != nil {
+			if t.Certificate != nil || !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else {
+			signers = append(signers, certSigner)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		ret = append(ret, ssh.PublicKeys(signers...))
+	}
+
+	if t.UseAgent != nil && *t.UseAgent == true {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if len(sock) == 0 {
+			return nil, fmt.Errorf("use_agent is set, but SSH_AUTH_SOCK is not")
+		}
+
+		// Signers from the agent include the loaded certificates too
+		ret = append(ret, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return sshAgent.signers(sock)
+		}))
+	}
+
+	return ret, nil
+}
+
+// agentConn is a connection to ssh-agent, shared by all targets. It is
+// established on the first authentication, which uses the agent, and is kept
+// open for the lifetime of the process.
+type agentConn struct {
+	mut    sync.Mutex
+	client agent.ExtendedAgent
+}
+
+var sshAgent agentConn
+
+// signers returns the keys and the certificates, loaded in the agent. If the
+// agent can't be reached, the connection is established again on the next call.
+func (a *agentConn) signers(sock string) ([]ssh.Signer, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	if a.client == nil {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to ssh-agent: %v", err)
+		}
+		a.client = agent.NewClient(conn)
+	}
+
+	signers, err := a.client.Signers()
+	if err != nil {
+		a.client = nil
+		return nil, fmt.Errorf("unable to get keys from ssh-agent: %v", err)
+	}
+
+	return signers, nil
+}
+
+// loadCertSigner reads an OpenSSH certificate (in authorized_keys format) and
+// combines it with the signer of the private key. The error from the read
+// is returned unwrapped, so that the caller can check it with os.IsNotExist().
+func loadCertSigner(certPath string, signer ssh.Signer) (ssh.Signer, error) {
+	certData, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate %s: %v", certPath, err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", certPath)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s doesn't match the private key: %v", certPath, err)
+	}
+
+	return certSigner, nil
+}
+
+// getHostKeyCallback returns the host key verification for the target:
+//   - with host_ca set, host certificates signed by the CA are accepted. Plain
+//     host keys are checked against known_hosts, if it is set too.
+//   - with known_hosts only, host keys are checked against the known_hosts file.
+//   - otherwise host keys are not verified.
+func getHostKeyCallback(t *target) (ssh.HostKeyCallback, error) {
+	var knownHostsCb ssh.HostKeyCallback
+
+	if t.KnownHosts != nil {
+		cb, err := knownhosts.New(expandHome(*t.KnownHosts))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load known_hosts: %v", err)
+		}
+		knownHostsCb = cb
+	}
+
+	if t.HostCA == nil {
+		if knownHostsCb != nil {
+			return knownHostsCb, nil
+		}
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	authorities, err := loadCAKeys(expandHome(*t.HostCA))
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, ca := range authorities {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: knownHostsCb,
+	}
+
+	return checker.CheckHostKey, nil
+}
+
+// loadCAKeys reads one or more public keys in authorized_keys format
+func loadCAKeys(fname string) ([]ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read host CA keys: %v", err)
+	}
+
+	ret := make([]ssh.PublicKey, 0, 1)
+	for len(bytes.TrimSpace(data)) > 0 {
+		pub, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse host CA keys from %s: %v", fname, err)
+		}
+		ret = append(ret, pub)
+		data = rest
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no host CA keys in %s", fname)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Error creating signer: %s", err)
+	}
+
+	return signer
+}
+
+func TestLoadCertSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap-tests")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestSigner(t)
+	userKey := newTestSigner(t)
+
+	cert := &ssh.Certificate{
+		Key:             userKey.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"capture"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("Error signing certificate: %s", err)
+	}
+
+	certPath := filepath.Join(dir, "key"+certSuffix)
+	if err := ioutil.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatalf("Error writing certificate: %s", err)
+	}
+
+	signer, err := loadCertSigner(certPath, userKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, ok := signer.PublicKey().(*ssh.Certificate); !ok {
+		t.Errorf("Signer doesn't use the certificate")
+	}
+
+	// Certificate for another key should be rejected
+	if _, err := loadCertSigner(certPath, newTestSigner(t)); err == nil {
+		t.Errorf("Expected error for certificate with wrong key")
+	}
+
+	// Missing certificate should be reported as such
+	if _, err := loadCertSigner(filepath.Join(dir, "missing"), userKey); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+
+	// CA keys file
+	caPath := filepath.Join(dir, "ca.pub")
+	caData := append(ssh.MarshalAuthorizedKey(ca.PublicKey()), ssh.MarshalAuthorizedKey(userKey.PublicKey())...)
+	if err := ioutil.WriteFile(caPath, caData, 0600); err != nil {
+		t.Fatalf("Error writing CA keys: %s", err)
+	}
+
+	keys, err := loadCAKeys(caPath)
+	if err != nil {
+		t.Fatalf("Unexpected error loading CA keys: %s", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 CA keys, got %d", len(keys))
+	}
+}
+
+func TestAgentConnReused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("Can't listen on unix socket: %s", err)
+	}
+	defer l.Close()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Error adding key: %s", err)
+	}
+
+	conns := make(chan struct{}, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- struct{}{}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+
+	var a agentConn
+	for i := 0; i < 3; i++ {
+		signers, err := a.signers(sock)
+		if err != nil || len(signers) != 1 {
+			t.Fatalf("Expected 1 signer, got %d (%v)", len(signers), err)
+		}
+	}
+
+	if len(conns) != 1 {
+		t.Errorf("Expected a single connection to the agent, got %d", len(conns))
+	}
+}
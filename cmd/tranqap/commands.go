@@ -5,6 +5,12 @@
 package main
 
 import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/abiosoft/ishell"
 	"github.com/tdimitrov/tranqap/internal/capture"
 	"github.com/tdimitrov/tranqap/internal/output"
@@ -19,115 +25,486 @@ const (
 
 var capturers *capture.Storage
 
+// commandFunc is a command, which can be run from the shell and from the
+// non-interactive modes. args are the arguments of the command. The returned
+// error is printed to the user.
+type commandFunc func(cio commandIO, cfg configParams, args []string) error
+
+// shellCmd adapts a commandFunc to ishell
+func shellCmd(fn commandFunc, lc *loadedConfig) func(*ishell.Context) {
+	return func(ctx *ishell.Context) {
+		if err := fn(ctx, lc.get(), ctx.Args); err != nil {
+			ctx.Println(err)
+		}
+	}
+}
+
+// runnerCmd returns a shell handler, which executes command with the runner,
+// so that it doesn't run at the same time as a command from the HTTP API
+func runnerCmd(runner *commandRunner, command string) func(*ishell.Context) {
+	return func(ctx *ishell.Context) {
+		if err := runner.runIO(ctx, command, ctx.Args); err != nil {
+			ctx.Println(err)
+		}
+	}
+}
+
+// errNoPasswordInput is returned when a password is needed in a mode, which
+// can't prompt the user for it
+var errNoPasswordInput = fmt.Errorf("Passwords can't be entered in non-interactive mode. Use sudo_password_command instead")
+
+// commandIO is used by the commands to print messages and to ask the user for
+// passwords. It is implemented by ishell.Context in the shell and by stdIO in
+// the non-interactive modes.
+type commandIO interface {
+	Printf(format string, val ...interface{})
+	Println(val ...interface{})
+	ReadPasswordErr() (string, error)
+}
+
+// sudoPasswords caches the sudo password for each target, so that the user
+// is prompted only once per tranqap session
+var sudoPasswords = make(map[string]string)
+
 func initStorage() {
 	capturers = capture.NewStorage()
 }
 
-func getSudoConfig(t target) capture.SudoConfig {
-	var ret capture.SudoConfig
-	if *t.UseSudo == true {
-		ret.Use = true
+func getPrivilegeConfig(cio commandIO, t target) (capture.PrivilegeConfig, error) {
+	ret := capture.PrivilegeConfig{Method: *t.Privilege}
+	if ret.Elevated() {
 		ret.Username = new(string)
 		*ret.Username = *t.User
+	}
+
+	if ret.Method == capture.PrivilegeSudo {
+		password, err := getSudoPassword(cio, t)
+		if err != nil {
+			return ret, err
+		}
+		ret.Password = password
+	}
+
+	return ret, nil
+}
+
+// getSudoPassword returns the sudo password for the target. It is obtained
+// by running sudo_password_command locally or by prompting the user. Returns
+// nil if the target doesn't need password for sudo.
+func getSudoPassword(cio commandIO, t target) (*string, error) {
+	if password, ok := sudoPasswords[*t.Name]; ok {
+		return &password, nil
+	}
+
+	var password string
+
+	if t.SudoPassCmd != nil {
+		out, err := exec.Command("sh", "-c", *t.SudoPassCmd).Output()
+		if err != nil {
+			return nil, fmt.Errorf("Error running sudo_password_command: %s", err)
+		}
+		password = strings.TrimRight(string(out), "\r\n")
+	} else if t.SudoAskPass != nil && *t.SudoAskPass == true {
+		cio.Printf("sudo password for %s@<%s>: ", *t.User, *t.Name)
+		p, err := cio.ReadPasswordErr()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading sudo password: %s", err)
+		}
+		password = p
 	} else {
-		ret.Use = false
-		ret.Username = nil
+		return nil, nil
 	}
 
-	return ret
+	sudoPasswords[*t.Name] = password
+	return &password, nil
 }
 
 func getFilterConfig(t target) capture.FilterConfig {
-	return capture.FilterConfig{Port: t.FilterPort}
+	return capture.FilterConfig{Port: t.FilterPort, Expression: t.CaptureFilter}
 }
 
-func cmdStart(ctx *ishell.Context, cfg configParams) {
-	// Check if there is a running job
-	if capturers.Empty() == false {
-		ctx.Println("There is already a running capture")
-		return
+func getCaptureConfig(t target) capture.CaptureConfig {
+	ret := capture.CaptureConfig{Interfaces: t.Interfaces}
+	if t.Snaplen != nil {
+		ret.Snaplen = *t.Snaplen
 	}
 
-	tqlog.Info("Called start command")
+	return ret
+}
 
-	for _, t := range cfg.Targets {
-		c, d, err := getClientConfig(&t)
-		if err != nil {
-			ctx.Printf("Error parsing client configuration for target <%s>: %s\n", *t.Name, err)
-			return
+// respawnViewers returns true if Wireshark and the viewers of the target should
+// be restarted when they crash
+func respawnViewers(t target) bool {
+	return t.RespawnViewer != nil && *t.RespawnViewer == true
+}
+
+// getWsharkConfig returns the options of the Wireshark windows for the target.
+// The name of the target is shown in the title bar.
+func getWsharkConfig(t target) output.WsharkConfig {
+	ret := output.WsharkConfig{Title: *t.Name, Prefs: t.WsharkOptions, Respawn: respawnViewers(t)}
+	if t.WsharkProfile != nil {
+		ret.Profile = *t.WsharkProfile
+	}
+	if t.WsharkFilter != nil {
+		ret.DisplayFilter = *t.WsharkFilter
+	}
+
+	return ret
+}
+
+// newWsharkOutputer returns an OutputerFactory, which starts Wireshark for the target
+func newWsharkOutputer(t target) output.OutputerFactory {
+	cfg := getWsharkConfig(t)
+	return func(p output.MOEventChan) output.Outputer {
+		return output.NewWsharkOutput(cfg, p)
+	}
+}
+
+// parseCommandOption extracts <option> <value> or <option>=<value> from the
+// arguments of a command, e.g. --profile sip. Returns the value (empty if the
+// option is not set) and the rest of the arguments.
+func parseCommandOption(args []string, option string) (string, []string, error) {
+	value := ""
+	rest := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == option:
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("Missing value after %s", option)
+			}
+			value = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], option+"="):
+			value = strings.TrimPrefix(args[i], option+"=")
+		default:
+			rest = append(rest, args[i])
 		}
+	}
+
+	return value, rest, nil
+}
+
+// labelRe matches the valid capture labels. The label becomes part of the
+// file names, so it can't contain path separators.
+var labelRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
 
-		// Create file output
+// applyLabel appends the label to the file pattern of the target, so that the
+// files of the capture are kept separately, e.g. per test case
+func applyLabel(t target, label string) (target, error) {
+	if !labelRe.MatchString(label) || strings.Trim(label, ".") == "" {
+		return t, fmt.Errorf("Invalid label %s. Only letters, digits, '.', '_' and '-' are allowed", label)
+	}
+
+	if t.FilePattern != nil {
+		pattern := *t.FilePattern + "-" + label
+		t.FilePattern = &pattern
+	}
+
+	return t, nil
+}
+
+func getWatchdogConfig(t target) capture.WatchdogConfig {
+	var ret capture.WatchdogConfig
+	if t.StallTimeout != nil {
+		ret.StallTimeout = time.Duration(*t.StallTimeout) * time.Second
+	}
+
+	return ret
+}
+
+// startCapture starts a capture for a single target. If profile and label are
+// not empty, they are applied to the target. Warnings are printed to cio.
+func startCapture(cio commandIO, cfg configParams, t target, profile string, label string) error {
+	var err error
+
+	// The profile and the label are applied only for this capture. The
+	// configuration is not changed.
+	if len(profile) > 0 {
+		if t, err = cfg.applyProfile(t, profile); err != nil {
+			return err
+		}
+	}
+	if len(label) > 0 {
+		if t, err = applyLabel(t, label); err != nil {
+			return err
+		}
+	}
+
+	sshClient, err := newTargetSSHClient(&t)
+	if err != nil {
+		return fmt.Errorf("Error parsing client configuration for target <%s>: %s", *t.Name, err)
+	}
+
+	outputs := getOutputs(t)
+
+	// Create file output
+	members := make([]output.Outputer, 0)
+	if hasString(outputs, outputFile) {
 		f := output.NewFileOutput(*t.Destination, *t.FilePattern, *t.RotationCnt)
 		if f == nil {
-			ctx.Printf("Can't create File output for target <%s>\n", *t.Name)
-			return
+			return fmt.Errorf("Can't create File output for target <%s>", *t.Name)
 		}
+		members = append(members, f)
+	}
 
-		// Create multioutput and attach the file output to it
-		m := output.NewMultiOutput(f)
-		if m == nil {
-			ctx.Printf("Can't create MultiOutput for target <%s>\n.", *t.Name)
-			return
-		}
+	// Create multioutput and attach the file output to it
+	m := output.NewMultiOutput(members...)
+	if m == nil {
+		return fmt.Errorf("Can't create MultiOutput for target <%s>", *t.Name)
+	}
 
-		// Create SSH client
-		sshClient := NewSSHClient(*d, *c)
+	privilege, err := getPrivilegeConfig(cio, t)
+	if err != nil {
+		m.Close()
+		return fmt.Errorf("Error getting privilege configuration for target <%s>: %s", *t.Name, err)
+	}
 
-		// Create capturer
-		capt := capture.NewTcpdump(*t.Name, m, capturers.GetChan(), sshClient, getSudoConfig(t), getFilterConfig(t))
-		if capt == nil {
-			ctx.Printf("Error creating Capturer for target <%s>\n", *t.Name)
-			return
+	// Create capturer. It is created before Wireshark and the viewers are
+	// started, so that nothing is left running if the target is not valid.
+	capt := capture.NewTcpdump(*t.Name, m, capturers.GetChan(), sshClient, privilege, getFilterConfig(t), getCaptureConfig(t), getWatchdogConfig(t))
+	if capt == nil {
+		m.Close()
+		return fmt.Errorf("Error creating Capturer for target <%s>", *t.Name)
+	}
+
+	if hasString(outputs, outputWireshark) {
+		if err := m.AddExtMember(newWsharkOutputer(t)); err != nil {
+			cio.Printf("Can't start Wireshark for target <%s>: %s\n", *t.Name, err)
 		}
+	}
 
-		if err := capt.Start(); err != nil {
-			ctx.Println(err)
-			return
+	for _, o := range outputs {
+		v, ok := cfg.Viewers[o]
+		if !ok {
+			continue
 		}
+		if err := m.AddExtMember(newViewerOutputer(o, v, t)); err != nil {
+			cio.Printf("Can't start viewer %s for target <%s>: %s\n", o, *t.Name, err)
+		}
+	}
+
+	if err := capt.Start(); err != nil {
+		return err
+	}
+
+	if err := capturers.Add(capt); err != nil {
+		return fmt.Errorf("Error adding capturer: %s", err)
+	}
+
+	return nil
+}
+
+func cmdStart(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called start command with args %v", args)
+
+	profile, rest, err := parseCommandOption(args, "--profile")
+	if err != nil {
+		return err
+	}
+	label, selectors, err := parseCommandOption(rest, "--label")
+	if err != nil {
+		return err
+	}
 
-		if err := capturers.Add(capt); err != nil {
-			ctx.Printf("Error adding capturer: %s", err.Error())
+	targets, err := cfg.selectTargets(selectors)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		// Check if there is a running job
+		if capturers.Running(*t.Name) {
+			cio.Printf("There is already a running capture for target <%s>\n", *t.Name)
+			continue
+		}
+
+		if err := startCapture(cio, cfg, t, profile, label); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-func cmdStop(ctx *ishell.Context) {
+func cmdStop(cio commandIO, cfg configParams, args []string) error {
 	// Check if there is a running job
 	if capturers.Empty() == true {
-		ctx.Println("There are no running captures.")
-		return
+		return fmt.Errorf("There are no running captures.")
+	}
+
+	tqlog.Info("Called stop command with args %v", args)
+
+	if len(args) == 0 {
+		capturers.StopAll()
+		return nil
+	}
+
+	names, err := cfg.selectTargetNames(args)
+	if err != nil {
+		return err
+	}
+
+	capturers.Stop(names)
+	return nil
+}
+
+func cmdWireshark(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called wireshark command with args %v", args)
+
+	if len(args) == 0 && capturers.Empty() == true {
+		return fmt.Errorf("There are no running captures.")
+	}
+
+	targets, err := cfg.selectTargets(args)
+	if err != nil {
+		return err
+	}
+
+	// Each target gets its own window title and options
+	for _, t := range targets {
+		if len(args) == 0 && !capturers.Running(*t.Name) {
+			continue
+		}
+		capturers.AddNewOutput(newWsharkOutputer(t), []string{*t.Name})
 	}
 
-	tqlog.Info("Called stop command")
+	return nil
+}
+
+// captureFileStatus is an output file of a running capture
+type captureFileStatus struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
 
-	capturers.StopAll()
+// captureStatus is the state and the statistics of a running capture
+type captureStatus struct {
+	Name             string              `json:"name"`
+	State            string              `json:"state"`
+	Uptime           float64             `json:"uptime_seconds"`
+	PID              int                 `json:"pid,omitempty"`
+	Bytes            uint64              `json:"bytes"`
+	Packets          uint64              `json:"packets"`
+	Throughput       uint64              `json:"throughput_bytes_per_second"`
+	Files            []captureFileStatus `json:"files"`
+	WiresharkWindows int                 `json:"wireshark_windows"`
+	Viewers          []string            `json:"viewers"`
 }
 
-func cmdWireshark(ctx *ishell.Context) {
-	tqlog.Info("Called wireshark command with args %v", ctx.Args)
+// newCaptureStatus converts the status of a capturer to captureStatus
+func newCaptureStatus(s capture.Status, now time.Time) captureStatus {
+	ret := captureStatus{
+		Name:       s.Name,
+		State:      s.State,
+		Bytes:      s.Bytes,
+		Packets:    s.Output.Packets,
+		Throughput: s.Throughput,
+		Files:      make([]captureFileStatus, 0),
+		Viewers:    make([]string, 0),
+	}
+
+	if !s.Started.IsZero() {
+		ret.Uptime = now.Sub(s.Started).Seconds()
+	}
+	if s.PID > 0 {
+		ret.PID = s.PID
+	}
+
+	for _, m := range s.Output.Members {
+		switch m.Kind {
+		case output.KindFile:
+			ret.Files = append(ret.Files, captureFileStatus{m.Path, m.Size})
+		case output.KindWireshark:
+			ret.WiresharkWindows++
+		case output.KindViewer:
+			ret.Viewers = append(ret.Viewers, m.Name)
+		}
+	}
+
+	return ret
+}
 
-	// Prepare a factory function, which creates Wireshark Outputer
-	factFn := func(p output.MOEventChan) output.Outputer {
-		return output.NewWsharkOutput(p)
+// getCaptureStatus returns the state of each running capture
+func getCaptureStatus() []captureStatus {
+	now := time.Now()
+	ret := make([]captureStatus, 0)
+	for _, s := range capturers.Status() {
+		ret = append(ret, newCaptureStatus(s, now))
 	}
 
-	capturers.AddNewOutput(factFn, ctx.Args)
+	return ret
+}
+
+// formatBytes returns a human readable size, e.g. 1.5 MiB
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// cmdStatus prints the state and the statistics of the running captures
+func cmdStatus(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called status command with args %v", args)
+
+	status := getCaptureStatus()
+	if len(status) == 0 {
+		cio.Println("There are no running captures.")
+		return nil
+	}
+
+	for _, s := range status {
+		cio.Printf("<%s> %s\n", s.Name, s.State)
+		cio.Printf("\tUptime: %s\n", (time.Duration(s.Uptime) * time.Second).String())
+		if s.PID > 0 {
+			cio.Printf("\tRemote PID: %d\n", s.PID)
+		}
+		cio.Printf("\tReceived: %s, %d packets\n", formatBytes(s.Bytes), s.Packets)
+		cio.Printf("\tThroughput: %s/s\n", formatBytes(s.Throughput))
+		for _, f := range s.Files {
+			cio.Printf("\tFile: %s (%s)\n", f.Path, formatBytes(uint64(f.Size)))
+		}
+		cio.Printf("\tWireshark windows: %d\n", s.WiresharkWindows)
+		if len(s.Viewers) > 0 {
+			cio.Printf("\tViewers: %s\n", strings.Join(s.Viewers, ", "))
+		}
+	}
+
+	return nil
 }
 
 func cmdTargets(ctx *ishell.Context, cfg configParams) {
-	tqlog.Info("Called targets command")
+	tqlog.Info("Called targets command with args %v", ctx.Args)
+
+	targets, err := cfg.selectTargets(ctx.Args)
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
 
-	for _, t := range cfg.Targets {
-		c, d, err := getClientConfig(&t)
+	for _, t := range targets {
+		sshClient, err := newTargetSSHClient(&t)
 		if err != nil {
 			ctx.Printf("Error parsing client configuration for target <%s>: %s\n", *t.Name, err)
 			return
 		}
 
+		privilege, err := getPrivilegeConfig(ctx, t)
+		if err != nil {
+			ctx.Printf("%s\n", err)
+		}
+
 		ctx.Printf("=== Running checks for target <%s> ===\n", *t.Name)
-		sshClient := NewSSHClient(*d, *c)
-		if output, err := checkPermissions(sshClient); err != nil {
+		if output, err := checkPermissions(sshClient, privilege); err != nil {
 			ctx.Printf("%s\n", err)
 		} else {
 			ctx.Printf("%s\n", output)
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+	"github.com/tdimitrov/tranqap/internal/output"
+)
+
+func TestNewCaptureStatus(t *testing.T) {
+	now := time.Now()
+	s := capture.Status{
+		Name:       "t1",
+		State:      capture.StateRunning,
+		Started:    now.Add(-90 * time.Second),
+		PID:        1234,
+		Bytes:      2048,
+		Throughput: 512,
+		Output: output.MultiOutputStats{
+			Bytes:   2048,
+			Packets: 10,
+			Members: []output.MemberInfo{
+				{Kind: output.KindFile, Path: "/tmp/t1.pcap", Size: 1024},
+				{Kind: output.KindWireshark},
+				{Kind: output.KindWireshark},
+				{Kind: output.KindViewer, Name: "termshark"},
+			},
+		},
+	}
+
+	cs := newCaptureStatus(s, now)
+	if cs.Name != "t1" || cs.State != capture.StateRunning || cs.PID != 1234 {
+		t.Errorf("Bad status %+v", cs)
+	}
+	if cs.Uptime != 90 {
+		t.Errorf("Expected uptime 90, got %f", cs.Uptime)
+	}
+	if cs.Bytes != 2048 || cs.Packets != 10 || cs.Throughput != 512 {
+		t.Errorf("Bad counters %+v", cs)
+	}
+	if len(cs.Files) != 1 || cs.Files[0].Path != "/tmp/t1.pcap" || cs.Files[0].Size != 1024 {
+		t.Errorf("Bad files %v", cs.Files)
+	}
+	if cs.WiresharkWindows != 2 {
+		t.Errorf("Expected 2 wireshark windows, got %d", cs.WiresharkWindows)
+	}
+	if len(cs.Viewers) != 1 || cs.Viewers[0] != "termshark" {
+		t.Errorf("Bad viewers %v", cs.Viewers)
+	}
+
+	s.PID = -1
+	s.Started = time.Time{}
+	if cs = newCaptureStatus(s, now); cs.PID != 0 || cs.Uptime != 0 {
+		t.Errorf("Expected no PID and uptime for a capture which is starting, got %+v", cs)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[uint64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1024:              "1.0 KiB",
+		1536:              "1.5 KiB",
+		5 * 1024 * 1024:   "5.0 MiB",
+		3 << 30:           "3.0 GiB",
+		1 << 50:           "1.0 PiB",
+		(1 << 60) + 1<<59: "1536.0 PiB",
+	}
+
+	for n, expected := range tests {
+		if s := formatBytes(n); s != expected {
+			t.Errorf("Expected %s for %d, got %s", expected, n, s)
+		}
+	}
+}
+
+func TestGetWsharkConfig(t *testing.T) {
+	conf := `targets:
+- name: router
+  host: 10.0.0.1
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: router
+  wireshark_profile: VoIP
+  wireshark_filter: sip || rtp
+  wireshark_options: ["rtp.heuristic_rtp:TRUE"]
+  respawn_viewers: true
+- name: web
+  host: 10.0.0.2
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: web
+  wireshark_options: [rtp.heuristic_rtp]`
+
+	cfg, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	expected := output.WsharkConfig{Title: "router", Profile: "VoIP", DisplayFilter: "sip || rtp", Prefs: []string{"rtp.heuristic_rtp:TRUE"}, Respawn: true}
+	if ws := getWsharkConfig(cfg.Targets[0]); !reflect.DeepEqual(ws, expected) {
+		t.Errorf("Bad wireshark config %+v", ws)
+	}
+	if errs := validateTarget(&cfg.Targets[0]); len(errs) != 0 {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+
+	if ws := getWsharkConfig(cfg.Targets[1]); ws.Title != "web" || ws.Profile != "" || ws.DisplayFilter != "" || ws.Respawn {
+		t.Errorf("Bad wireshark config %+v", ws)
+	}
+	errs := validateTarget(&cfg.Targets[1])
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "Invalid wireshark option rtp.heuristic_rtp for target <web>") {
+		t.Errorf("Expected error for invalid option, got %v", errs)
+	}
+}
+
+func TestStartCaptureError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	keyPath := filepath.Join(dir, "key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	marker := filepath.Join(dir, "started")
+	conf := fmt.Sprintf(`viewers:
+  marker:
+    command: touch
+    args: [%s]
+targets:
+- name: t1
+  host: 10.0.0.1
+  user: capture
+  key: %s
+  destination: %s
+  file_pattern: t1
+  privilege: sudo
+  sudo_ask_password: true
+  outputs: [marker]`, marker, keyPath, dir)
+
+	cfg, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	initStorage()
+	defer capturers.Close()
+
+	var out bufferIO
+	if err := startCapture(&out, cfg, cfg.Targets[0], "", ""); err == nil || !strings.Contains(err.Error(), "Error getting privilege configuration") {
+		t.Fatalf("Expected error for the sudo password, got %v", err)
+	}
+
+	// Give the viewer time to start, if it was started by mistake
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("The viewer is started, although the capture can't be started")
+	}
+}
@@ -8,29 +8,72 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/tdimitrov/tranqap/internal/tqlog"
+	"github.com/tdimitrov/tranqap/internal/capture"
 
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
 type configParams struct {
-	Targets []target
+	Version  int                     `yaml:"version,omitempty"`
+	Include  []string                `yaml:"include,omitempty"`
+	Defaults *target                 `yaml:"defaults,omitempty"`
+	Groups   map[string]target       `yaml:"groups,omitempty"`
+	Profiles map[string]target       `yaml:"profiles,omitempty"`
+	Viewers  map[string]viewerParams `yaml:"viewers,omitempty"`
+	Targets  []target
+
+	// sources contains the index of each target in the configuration, before
+	// the host patterns are expanded. sourceNames contains the names of the
+	// targets before the expansion. Used for error reporting.
+	sources     []int
+	sourceNames []string
+
+	// files contains all included files
+	files []string
 }
 
 type target struct {
-	Name        *string
-	Host        *string
-	Port        *int
-	User        *string
-	Key         *string
-	Destination *string
-	FilePattern *string `yaml:"file_pattern"`
-	RotationCnt *int    `yaml:"file_rotation_count"`
-	UseSudo     *bool   `yaml:"use_sudo"`
-	FilterPort  *int    `yaml:"filter_port"`
+	Name          *string
+	Host          *string
+	Port          *int
+	User          *string
+	Key           *string
+	Destination   *string
+	FilePattern   *string  `yaml:"file_pattern"`
+	RotationCnt   *int     `yaml:"file_rotation_count"`
+	UseSudo       *bool    `yaml:"use_sudo,omitempty"`
+	FilterPort    *int     `yaml:"filter_port"`
+	SSHAlias      *string  `yaml:"ssh_alias,omitempty"`
+	SSHConfig     *string  `yaml:"ssh_config,omitempty"`
+	ProxyJump     *string  `yaml:"proxy_jump,omitempty"`
+	KeepAlive     *int     `yaml:"keepalive_interval,omitempty"`
+	KeepAliveCnt  *int     `yaml:"keepalive_count_max,omitempty"`
+	StallTimeout  *int     `yaml:"stall_timeout,omitempty"`
+	Certificate   *string  `yaml:"certificate,omitempty"`
+	UseAgent      *bool    `yaml:"use_agent,omitempty"`
+	HostCA        *string  `yaml:"host_ca,omitempty"`
+	KnownHosts    *string  `yaml:"known_hosts,omitempty"`
+	SudoPassCmd   *string  `yaml:"sudo_password_command,omitempty"`
+	SudoAskPass   *bool    `yaml:"sudo_ask_password,omitempty"`
+	Privilege     *string  `yaml:"privilege,omitempty"`
+	Group         *string  `yaml:"group,omitempty"`
+	Tags          []string `yaml:"tags,omitempty"`
+	CaptureFilter *string  `yaml:"capture_filter,omitempty"`
+	Snaplen       *int     `yaml:"snaplen,omitempty"`
+	Interfaces    []string `yaml:"interfaces,omitempty"`
+	Outputs       []string `yaml:"outputs,omitempty"`
+	WsharkProfile *string  `yaml:"wireshark_profile,omitempty"`
+	WsharkFilter  *string  `yaml:"wireshark_filter,omitempty"`
+	WsharkOptions []string `yaml:"wireshark_options,omitempty"`
+	RespawnViewer *bool    `yaml:"respawn_viewers,omitempty"`
 }
 
 func checkForDuplicates(config configParams) error {
@@ -55,28 +98,52 @@ func readConfigFromFile(fname string) (configParams, error) {
 		return configParams{}, fmt.Errorf("%s. Run init subcommand to generate empty config or provide path to existing config with -c", err.Error())
 	}
 
-	conf, err := parseConfig(confFile)
+	conf, err := parseConfigFile(confFile, fname)
 	if err != nil {
 		return configParams{}, fmt.Errorf("Error parsing %s: %s", fname, err.Error())
 	}
+	conf.files = append([]string{fname}, conf.files...)
 
 	return conf, nil
 }
 
-func parseConfig(confFile []byte) (configParams, error) {
-	var conf configParams
-	var err error
+// parseConfig parses the configuration. Included files are relative to baseDir.
+func parseConfig(confFile []byte, baseDir string) (configParams, error) {
+	return parseConfigIncludedBy(confFile, baseDir, nil)
+}
+
+// parseConfigFile parses the configuration read from fname. Included files are
+// relative to the directory of fname and including fname itself is an error.
+func parseConfigFile(confFile []byte, fname string) (configParams, error) {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return configParams{}, err
+	}
+
+	return parseConfigIncludedBy(confFile, filepath.Dir(fname), []string{abs})
+}
 
-	err = yaml.Unmarshal(confFile, &conf)
+// parseConfigIncludedBy parses the configuration. parents are passed to
+// loadIncludes to detect include loops.
+func parseConfigIncludedBy(confFile []byte, baseDir string, parents []string) (configParams, error) {
+	conf, err := decodeConfig(confFile)
 	if err != nil {
 		return conf, err
 	}
 
+	if err := loadIncludes(&conf, baseDir, parents); err != nil {
+		return conf, err
+	}
+
 	// Basic validation
 	if len(conf.Targets) == 0 {
 		return conf, fmt.Errorf("No targets defined in config")
 	}
 
+	if err := resolveTargets(&conf); err != nil {
+		return conf, err
+	}
+
 	if err := checkForDuplicates(conf); err != nil {
 		return conf, err
 	}
@@ -93,76 +160,293 @@ func getClientConfig(t *target) (*ssh.ClientConfig, *string, error) {
 		return nil, nil, errors.New("Missing Name in configuration")
 	}
 
-	if t.User == nil {
-		return nil, nil, fmt.Errorf("Missing user for target <%s> in configuration", *t.Name)
+	if errs := validateTarget(t); len(errs) > 0 {
+		return nil, nil, errs[0]
 	}
 
-	if t.Key == nil {
-		return nil, nil, fmt.Errorf("Missing Key path for target <%s> in configuration", *t.Name)
+	dest := fmt.Sprintf("%s:%d", *t.Host, *t.Port)
+
+	clientConfig.User = *t.User
+
+	hostKeyCb, err := getHostKeyCallback(t)
+	if err != nil {
+		return nil, nil, err
 	}
+	clientConfig.HostKeyCallback = hostKeyCb
 
-	if t.Host == nil {
-		return nil, nil, fmt.Errorf("Missing Host for target <%s> in configuration", *t.Name)
+	auth, err := getAuthMethods(t)
+	if err != nil {
+		return nil, nil, err
 	}
+	clientConfig.Auth = append(clientConfig.Auth, auth...)
 
-	if t.Port == nil {
-		tqlog.Info("Port not set for target <%s>. Setting to 22.\n", *t.Name)
-		t.Port = new(int)
-		*t.Port = 22
+	return &clientConfig, &dest, nil
+}
+
+// validateTarget checks the parameters of a resolved target and returns all problems
+func validateTarget(t *target) []error {
+	errs := make([]error, 0)
+
+	if t.User == nil {
+		errs = append(errs, fmt.Errorf("Missing user for target <%s> in configuration", *t.Name))
+	}
+
+	if t.Key == nil && (t.UseAgent == nil || *t.UseAgent == false) {
+		errs = append(errs, fmt.Errorf("Missing Key path for target <%s> in configuration", *t.Name))
+	}
+
+	if t.Host == nil {
+		errs = append(errs, fmt.Errorf("Missing Host for target <%s> in configuration", *t.Name))
 	}
 
 	if t.Destination == nil {
-		return nil, nil, fmt.Errorf("Missing destination for target <%s> in configuration", *t.Name)
+		errs = append(errs, fmt.Errorf("Missing destination for target <%s> in configuration", *t.Name))
 	}
 
 	if t.FilePattern == nil {
-		return nil, nil, fmt.Errorf("Missing File Pattern for target <%s>", *t.Name)
+		errs = append(errs, fmt.Errorf("Missing File Pattern for target <%s>", *t.Name))
 	}
 
-	if t.RotationCnt == nil {
-		tqlog.Info("File Rotation Count not set for target <%s>. Setting to 10.\n", *t.Name)
-		t.RotationCnt = new(int)
-		*t.RotationCnt = 10
+	if *t.RotationCnt < 0 {
+		errs = append(errs, fmt.Errorf("Invalid rotation count for target <%s> (%d)", *t.Name, *t.RotationCnt))
 	}
 
-	if *t.RotationCnt < 0 {
-		return nil, nil, fmt.Errorf("Invalid rotation count for target <%s> (%d)", *t.Name, *t.RotationCnt)
+	if err := capture.ValidatePrivilege(*t.Privilege); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid privilege for target <%s>: %s", *t.Name, err))
 	}
 
-	if t.UseSudo == nil {
-		t.UseSudo = new(bool)
-		*t.UseSudo = false
+	// su reads the password from the terminal and there is no terminal in the SSH session
+	if *t.Privilege == capture.PrivilegeSu && t.User != nil && *t.User != "root" {
+		errs = append(errs, fmt.Errorf("privilege su can be used only with user root for target <%s>, because su can't read a password", *t.Name))
+	}
+
+	if *t.Privilege != capture.PrivilegeSudo && (t.SudoPassCmd != nil || (t.SudoAskPass != nil && *t.SudoAskPass == true)) {
+		errs = append(errs, fmt.Errorf("sudo password is configured for target <%s>, but privilege is not sudo", *t.Name))
 	}
 
 	if t.FilterPort != nil {
 		if *t.FilterPort < 1 || *t.FilterPort > 65535 {
-			return nil, nil, fmt.Errorf("Invalid port number for Filter port parameter: %d. Expected value between 1 and 65535", *t.FilterPort)
+			errs = append(errs, fmt.Errorf("Invalid port number for Filter port parameter: %d. Expected value between 1 and 65535", *t.FilterPort))
 		}
 	}
 
-	dest := fmt.Sprintf("%s:%d", *t.Host, *t.Port)
+	if t.KeepAlive != nil && *t.KeepAlive < 0 {
+		errs = append(errs, fmt.Errorf("Invalid keepalive interval for target <%s> (%d)", *t.Name, *t.KeepAlive))
+	}
 
-	clientConfig.User = *t.User
-	clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	if *t.KeepAliveCnt < 1 {
+		errs = append(errs, fmt.Errorf("Invalid keepalive count for target <%s> (%d)", *t.Name, *t.KeepAliveCnt))
+	}
+
+	if t.StallTimeout != nil && *t.StallTimeout < 0 {
+		errs = append(errs, fmt.Errorf("Invalid stall timeout for target <%s> (%d)", *t.Name, *t.StallTimeout))
+	}
+
+	if *t.Port < 1 || *t.Port > 65535 {
+		errs = append(errs, fmt.Errorf("Invalid port for target <%s>: %d. Expected value between 1 and 65535", *t.Name, *t.Port))
+	}
+
+	if err := getCaptureConfig(*t).ValidateCapture(); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid capture options for target <%s>: %s", *t.Name, err))
+	}
 
-	if t.Key != nil {
-		key, err := ioutil.ReadFile(*t.Key)
-		if err != nil {
-			msg := fmt.Sprintf("unable to read private key: %v", err)
-			return nil, nil, errors.New(msg)
+	for _, o := range t.WsharkOptions {
+		if !strings.Contains(o, ":") || strings.HasPrefix(o, ":") {
+			errs = append(errs, fmt.Errorf("Invalid wireshark option %s for target <%s>. Expected key:value", o, *t.Name))
 		}
+	}
 
-		// Create the Signer for this private key.
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			msg := fmt.Sprintf("unable to parse private key: %v", err)
-			return nil, nil, errors.New(msg)
+	return errs
+}
+
+// resolveSSHConfig loads the OpenSSH client configuration for the target and
+// fills in the parameters which are missing in tranqap's config.
+// A missing default config file (~/.ssh/config) is not an error.
+func (t *target) resolveSSHConfig() error {
+	sc, err := t.loadSSHConfig()
+	if err != nil || sc == nil {
+		return err
+	}
+
+	return t.applySSHConfig(sc)
+}
+
+// loadSSHConfig reads the OpenSSH client configuration for the target.
+// Returns nil without an error if the default config file doesn't exist.
+func (t *target) loadSSHConfig() (*sshConfig, error) {
+	fname := sshConfigPath
+	if t.SSHConfig != nil {
+		fname = *t.SSHConfig
+	}
+	if len(fname) == 0 {
+		return nil, nil
+	}
+
+	sc, err := readSSHConfig(expandHome(fname))
+	if err != nil {
+		if os.IsNotExist(err) && t.SSHConfig == nil {
+			return nil, nil
 		}
+		return nil, err
+	}
+
+	return sc, nil
+}
 
-		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
+// applySSHConfig fills the missing connection parameters of the target from
+// the Host entries in sc, matching the target's alias. The alias is ssh_alias if
+// set, otherwise host and finally the name of the target. Values set in tranqap's
+// config always take precedence. If neither host nor HostName is set, the alias
+// is used as a host name, as ssh does.
+func (t *target) applySSHConfig(sc *sshConfig) error {
+	alias := t.Name
+	if t.SSHAlias != nil {
+		alias = t.SSHAlias
+	} else if t.Host != nil {
+		alias = t.Host
 	}
 
-	return &clientConfig, &dest, nil
+	// When host is used as an alias it should be replaced by HostName (as ssh does)
+	if hostName := sc.getString(*alias, "HostName"); hostName != nil {
+		if t.Host == nil || (t.SSHAlias == nil && *t.Host == *alias) {
+			t.Host = new(string)
+			*t.Host = expandSSHTokens(*hostName, *alias, "")
+		}
+	} else if t.Host == nil {
+		t.Host = new(string)
+		*t.Host = *alias
+	}
+
+	if t.User == nil {
+		t.User = sc.getString(*alias, "User")
+	}
+
+	if t.Port == nil {
+		if port := sc.getString(*alias, "Port"); port != nil {
+			p, err := strconv.Atoi(*port)
+			if err != nil {
+				return fmt.Errorf("bad Port for %s: %s", *alias, *port)
+			}
+			t.Port = &p
+		}
+	}
+
+	if t.Key == nil {
+		if key := sc.getString(*alias, "IdentityFile"); key != nil {
+			host, remoteUser := *alias, ""
+			if t.Host != nil {
+				host = *t.Host
+			}
+			if t.User != nil {
+				remoteUser = *t.User
+			}
+			t.Key = new(string)
+			*t.Key = expandSSHTokens(*key, host, remoteUser)
+		}
+	}
+
+	if t.Certificate == nil {
+		if cert := sc.getString(*alias, "CertificateFile"); cert != nil {
+			host, remoteUser := *alias, ""
+			if t.Host != nil {
+				host = *t.Host
+			}
+			if t.User != nil {
+				remoteUser = *t.User
+			}
+			t.Certificate = new(string)
+			*t.Certificate = expandSSHTokens(*cert, host, remoteUser)
+		}
+	}
+
+	if t.ProxyJump == nil {
+		if jump := sc.getString(*alias, "ProxyJump"); jump != nil && strings.ToLower(*jump) != "none" {
+			t.ProxyJump = jump
+		}
+	}
+
+	return nil
+}
+
+// getJumpHosts parses the ProxyJump value of the target. It is a comma separated
+// list of [user@]host[:port] hops. Each hop is resolved via the OpenSSH client
+// configuration too. Hops use the authentication methods of the target.
+func getJumpHosts(t *target, config ssh.ClientConfig) ([]sshJump, error) {
+	if t.ProxyJump == nil {
+		return nil, nil
+	}
+
+	sc, err := t.loadSSHConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]sshJump, 0)
+	for _, hop := range strings.Split(*t.ProxyJump, ",") {
+		hop = strings.TrimSpace(hop)
+		if len(hop) == 0 {
+			continue
+		}
+
+		var hopUser, hopPort *string
+		if i := strings.LastIndex(hop, "@"); i != -1 {
+			u := hop[:i]
+			hopUser = &u
+			hop = hop[i+1:]
+		}
+		if host, port, err := net.SplitHostPort(hop); err == nil {
+			hop = host
+			hopPort = &port
+		}
+
+		host := hop
+		if sc != nil {
+			if hostName := sc.getString(hop, "HostName"); hostName != nil {
+				host = expandSSHTokens(*hostName, hop, "")
+			}
+			if hopUser == nil {
+				hopUser = sc.getString(hop, "User")
+			}
+			if hopPort == nil {
+				hopPort = sc.getString(hop, "Port")
+			}
+		}
+
+		hopConfig := config
+		if hopUser != nil {
+			hopConfig.User = *hopUser
+		}
+
+		port := "22"
+		if hopPort != nil {
+			port = *hopPort
+		}
+
+		ret = append(ret, sshJump{net.JoinHostPort(host, port), hopConfig})
+	}
+
+	return ret, nil
+}
+
+// newTargetSSHClient creates SSHClient for the target, including the jump
+// hosts from ProxyJump
+func newTargetSSHClient(t *target) (*SSHClient, error) {
+	c, d, err := getClientConfig(t)
+	if err != nil {
+		return nil, err
+	}
+
+	jumps, err := getJumpHosts(t, *c)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing proxy_jump for target <%s>: %s", *t.Name, err)
+	}
+
+	ret := NewSSHClient(*d, *c, jumps...)
+	if t.KeepAlive != nil {
+		ret.SetKeepAlive(time.Duration(*t.KeepAlive)*time.Second, *t.KeepAliveCnt)
+	}
+
+	return ret, nil
 }
 
 func generateSampleConfig(path string) error {
@@ -178,13 +462,23 @@ func generateSampleConfig(path string) error {
 	dest := "Path to destination dir for the PCAP files."
 	pattern := "Filename pattern for each pcap file. Index and file extension will be added to this string."
 	rotCnt := 5
-	useSudo := true
+	privilege := capture.PrivilegeSudo
 	filterPort := 22
 
 	t := make([]target, 1, 1)
-	t[0] = target{&name, &host, &port, &login, &key, &dest, &pattern, &rotCnt, &useSudo, &filterPort}
-	conf := make(map[string][]target)
-	conf["targets"] = t
+	t[0] = target{
+		Name:        &name,
+		Host:        &host,
+		Port:        &port,
+		User:        &login,
+		Key:         &key,
+		Destination: &dest,
+		FilePattern: &pattern,
+		RotationCnt: &rotCnt,
+		Privilege:   &privilege,
+		FilterPort:  &filterPort,
+	}
+	conf := configParams{Version: configVersion, Targets: t}
 
 	// And finally create the new file
 	confYAML, err := yaml.Marshal(conf)
@@ -209,3 +503,103 @@ func (cp *configParams) getTargetsList() []string {
 
 	return targets
 }
+
+// tagPrefix marks a selector as a tag, e.g. @frontend
+const tagPrefix = "@"
+
+// hasTag returns true if the target is tagged with tag
+func (t *target) hasTag(tag string) bool {
+	for _, tt := range t.Tags {
+		if tt == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getTagsList returns all tags, used in the configuration, in order of appearance
+func (cp *configParams) getTagsList() []string {
+	tags := make([]string, 0)
+	seen := make(map[string]struct{})
+
+	for _, t := range cp.Targets {
+		for _, tag := range t.Tags {
+			if _, ok := seen[tag]; !ok {
+				seen[tag] = struct{}{}
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// getSelectorsList returns the names of all targets and all tags with tagPrefix.
+// Used for command completion.
+func (cp *configParams) getSelectorsList() []string {
+	ret := cp.getTargetsList()
+	for _, tag := range cp.getTagsList() {
+		ret = append(ret, tagPrefix+tag)
+	}
+
+	return ret
+}
+
+// selectTargets returns the targets matching the selectors. Each selector is either
+// a target name or a tag with tagPrefix. Without selectors all targets are returned.
+// Each target is returned once, in the order of the configuration.
+func (cp *configParams) selectTargets(selectors []string) ([]target, error) {
+	if len(selectors) == 0 {
+		return cp.Targets, nil
+	}
+
+	selected := make(map[string]struct{})
+	for _, sel := range selectors {
+		found := false
+
+		for _, t := range cp.Targets {
+			if strings.HasPrefix(sel, tagPrefix) {
+				if !t.hasTag(strings.TrimPrefix(sel, tagPrefix)) {
+					continue
+				}
+			} else if *t.Name != sel {
+				continue
+			}
+
+			selected[*t.Name] = struct{}{}
+			found = true
+		}
+
+		if !found {
+			if strings.HasPrefix(sel, tagPrefix) {
+				return nil, fmt.Errorf("No targets tagged with %s", strings.TrimPrefix(sel, tagPrefix))
+			}
+			return nil, fmt.Errorf("Target <%s> doesn't exist", sel)
+		}
+	}
+
+	ret := make([]target, 0, len(selected))
+	for _, t := range cp.Targets {
+		if _, ok := selected[*t.Name]; ok {
+			ret = append(ret, t)
+		}
+	}
+
+	return ret, nil
+}
+
+// selectTargetNames is the same as selectTargets, but returns only the names of the targets
+func (cp *configParams) selectTargetNames(selectors []string) ([]string, error) {
+	targets, err := cp.selectTargets(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ret = append(ret, *t.Name)
+	}
+
+	return ret, nil
+}
@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain disables the default OpenSSH client config (~/.ssh/config), so that
+// the tests don't depend on the configuration of the user running them
+func TestMain(m *testing.M) {
+	sshConfigPath = ""
+	os.Exit(m.Run())
+}
 
 var goodConfig = `targets:
 - name: local
@@ -15,7 +29,7 @@ var goodConfig = `targets:
   filter_port: 22`
 
 func TestParseConfig(t *testing.T) {
-	res, err := parseConfig([]byte(goodConfig))
+	res, err := parseConfig([]byte(goodConfig), ".")
 	if err != nil {
 		t.Errorf("Error parsing goodConfig: %s", err.Error())
 	}
@@ -64,3 +78,209 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("Bad filter_port: %d", *tgt.FilterPort)
 	}
 }
+
+var defaultsConfig = `defaults:
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_rotation_count: 3
+  privilege: sudo
+groups:
+  backend:
+    port: 2222
+    user: backend
+targets:
+- name: front
+  host: 10.0.0.1
+  file_pattern: front
+- name: back
+  host: 10.0.0.2
+  group: backend
+  file_pattern: back
+  file_rotation_count: 7
+- name: legacy
+  host: 10.0.0.3
+  file_pattern: legacy
+  privilege: none`
+
+func TestParseConfigDefaults(t *testing.T) {
+	res, err := parseConfig([]byte(defaultsConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing defaultsConfig: %s", err.Error())
+	}
+
+	front, back, legacy := res.Targets[0], res.Targets[1], res.Targets[2]
+
+	// Inherited from defaults and built-in defaults
+	if *front.User != "capture" || *front.Key != "secret.key" || *front.Destination != "pcaps" {
+		t.Errorf("Values not inherited from defaults: %s %s %s", *front.User, *front.Key, *front.Destination)
+	}
+	if *front.Port != 22 || *front.RotationCnt != 3 || *front.Privilege != "sudo" {
+		t.Errorf("Bad defaults for front: %d %d %s", *front.Port, *front.RotationCnt, *front.Privilege)
+	}
+
+	// Group takes precedence over defaults, target over group
+	if *back.Port != 2222 || *back.User != "backend" || *back.RotationCnt != 7 {
+		t.Errorf("Bad values for back: %d %s %d", *back.Port, *back.User, *back.RotationCnt)
+	}
+
+	if *legacy.Privilege != "none" {
+		t.Errorf("Bad privilege for legacy: %s", *legacy.Privilege)
+	}
+
+	// Targets should not share values
+	*front.User = "changed"
+	if *legacy.User != "capture" {
+		t.Errorf("Targets share inherited values")
+	}
+}
+
+func TestParseConfigBadGroup(t *testing.T) {
+	conf := `targets:
+- name: front
+  group: missing`
+
+	if _, err := parseConfig([]byte(conf), "."); err == nil {
+		t.Errorf("Expected error for unknown group")
+	}
+
+	conf = `defaults:
+  name: front
+targets:
+- name: front`
+
+	if _, err := parseConfig([]byte(conf), "."); err == nil {
+		t.Errorf("Expected error for name in defaults")
+	}
+}
+
+var tagsConfig = `defaults:
+  tags: [all]
+targets:
+- name: web1
+  tags: [frontend]
+- name: web2
+  tags: [frontend, all]
+- name: db
+`
+
+func TestSelectTargets(t *testing.T) {
+	res, err := parseConfig([]byte(tagsConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing tagsConfig: %s", err.Error())
+	}
+
+	names, err := res.selectTargetNames([]string{"@frontend"})
+	if err != nil || len(names) != 2 || names[0] != "web1" || names[1] != "web2" {
+		t.Errorf("Bad selection for @frontend: %v %v", names, err)
+	}
+
+	// Tags are inherited from defaults, unless set in the target
+	names, err = res.selectTargetNames([]string{"db", "@all"})
+	if err != nil || len(names) != 2 || names[0] != "web2" || names[1] != "db" {
+		t.Errorf("Bad selection for db @all: %v %v", names, err)
+	}
+
+	names, err = res.selectTargetNames(nil)
+	if err != nil || len(names) != 3 {
+		t.Errorf("Expected all targets without selectors. Got %v %v", names, err)
+	}
+
+	if _, err := res.selectTargetNames([]string{"@missing"}); err == nil {
+		t.Errorf("Expected error for unknown tag")
+	}
+	if _, err := res.selectTargetNames([]string{"missing"}); err == nil {
+		t.Errorf("Expected error for unknown target")
+	}
+
+	selectors := res.getSelectorsList()
+	expected := []string{"web1", "web2", "db", "@frontend", "@all"}
+	if len(selectors) != len(expected) {
+		t.Fatalf("Bad selectors list: %v", selectors)
+	}
+	for i := range expected {
+		if selectors[i] != expected[i] {
+			t.Errorf("Bad selectors list: %v", selectors)
+		}
+	}
+}
+
+func TestParseConfigSSHPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "ssh_config")
+	if err := ioutil.WriteFile(fname, []byte("Host lab\n HostName 10.0.0.9\n User labuser\n Port 2200\n"), 0600); err != nil {
+		t.Fatalf("Error writing ssh config: %s", err)
+	}
+
+	defer func(path string) { sshConfigPath = path }(sshConfigPath)
+	sshConfigPath = fname
+
+	conf := `defaults:
+  user: capture
+  port: 2222
+  key: secret.key
+  destination: pcaps
+targets:
+- name: lab
+  file_pattern: lab
+- name: explicit
+  ssh_alias: lab
+  user: root
+  file_pattern: explicit
+- name: other
+  host: 10.0.0.1
+  file_pattern: other`
+
+	res, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	lab, explicit, other := res.Targets[0], res.Targets[1], res.Targets[2]
+
+	// OpenSSH client config takes precedence over defaults
+	if *lab.Host != "10.0.0.9" || *lab.User != "labuser" || *lab.Port != 2200 || *lab.Key != "secret.key" {
+		t.Errorf("Bad values for lab: %s %s %d %s", *lab.Host, *lab.User, *lab.Port, *lab.Key)
+	}
+
+	// Values set in the target take precedence over OpenSSH client config
+	if *explicit.User != "root" || *explicit.Port != 2200 {
+		t.Errorf("Bad values for explicit: %s %d", *explicit.User, *explicit.Port)
+	}
+
+	// Defaults are used for hosts without an entry
+	if *other.User != "capture" || *other.Port != 2222 {
+		t.Errorf("Bad values for other: %s %d", *other.User, *other.Port)
+	}
+}
+
+func TestValidateTargetSu(t *testing.T) {
+	for _, user := range []string{"capture", "root"} {
+		conf := fmt.Sprintf(`targets:
+- name: t1
+  host: 10.0.0.1
+  user: %s
+  key: key
+  destination: pcaps
+  file_pattern: t1
+  privilege: su`, user)
+
+		cfg, err := parseConfig([]byte(conf), ".")
+		if err != nil {
+			t.Fatalf("Error parsing config: %s", err)
+		}
+
+		errs := validateTarget(&cfg.Targets[0])
+		if user == "root" && len(errs) != 0 {
+			t.Errorf("Unexpected errors for user root: %v", errs)
+		}
+		if user != "root" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), "privilege su can be used only with user root")) {
+			t.Errorf("Expected error for privilege su with user %s, got %v", user, errs)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// ctlRequestTimeout is the time the daemon waits for a client to send its request
+const ctlRequestTimeout = 10 * time.Second
+
+// ctlRequest is a command, sent by ctl subcommand to the daemon. Command is the
+// name of a shell command and Args are its arguments.
+type ctlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// ctlResponse is the result of a command. Output contains the messages printed
+// by the command. OK is false and Error is set if the command failed.
+type ctlResponse struct {
+	OK     bool   `json:"ok"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// daemonCommands are the commands, which can be run in the daemon
+var daemonCommands = map[string]commandFunc{
+	"start":     cmdStart,
+	"stop":      cmdStop,
+	"status":    cmdStatus,
+	"mark":      cmdMark,
+	"wireshark": cmdWireshark,
+	"view":      cmdView,
+}
+
+// bufferIO is commandIO, which collects the output of a command
+type bufferIO struct {
+	bytes.Buffer
+}
+
+func (b *bufferIO) Printf(format string, val ...interface{}) {
+	fmt.Fprintf(&b.Buffer, format, val...)
+}
+
+func (b *bufferIO) Println(val ...interface{}) {
+	fmt.Fprintln(&b.Buffer, val...)
+}
+
+func (b *bufferIO) ReadPasswordErr() (string, error) {
+	return "", errNoPasswordInput
+}
+
+// defaultSocketPath returns the path of the control socket, used when --socket
+// is not set
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "tranqap.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("tranqap-%d.sock", os.Getuid()))
+}
+
+// commandRunner runs the commands, received from the shell, the control socket
+// and the HTTP API. The commands are executed one at a time.
+type commandRunner struct {
+	mut    sync.Mutex
+	lc     *loadedConfig
+	closed bool
+}
+
+func newCommandRunner(lc *loadedConfig) *commandRunner {
+	return &commandRunner{lc: lc}
+}
+
+// run executes a command and returns its result
+func (r *commandRunner) run(command string, args []string) ctlResponse {
+	var out bufferIO
+
+	if err := r.runIO(&out, command, args); err != nil {
+		return ctlResponse{false, out.String(), err.Error()}
+	}
+
+	return ctlResponse{true, out.String(), ""}
+}
+
+// runIO executes a command. Its messages are printed to cio.
+func (r *commandRunner) runIO(cio commandIO, command string, args []string) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	tqlog.Info("Control command %s with args %v", command, args)
+
+	if r.closed {
+		return fmt.Errorf("tranqap is shutting down")
+	}
+
+	if command == "reload" {
+		cmdReload(cio, r.lc)
+		return nil
+	}
+
+	fn, ok := daemonCommands[command]
+	if !ok {
+		return fmt.Errorf("Unknown command %s. Supported commands: %v", command, ctlCommandNames())
+	}
+
+	return fn(cio, r.lc.get(), args)
+}
+
+// close waits for the command in progress, if any, and stops all captures.
+// No commands are executed after close.
+func (r *commandRunner) close() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.closed = true
+	capturers.Close()
+}
+
+// ctlServer executes the commands received over the control socket
+type ctlServer struct {
+	runner   *commandRunner
+	listener net.Listener
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+// listenCtl creates the control socket. A socket left by a daemon which has
+// crashed is removed. Only the owner can connect to the socket.
+func listenCtl(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Another daemon is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("Error removing stale socket %s: %s", path, err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func newCtlServer(runner *commandRunner, listener net.Listener) *ctlServer {
+	return &ctlServer{runner: runner, listener: listener, shutdown: make(chan struct{})}
+}
+
+// serve accepts connections until the listener is closed
+func (s *ctlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			tqlog.Info("Control socket closed: %s", err)
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// handle reads a single request from the connection and sends the response
+func (s *ctlServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req ctlRequest
+	conn.SetReadDeadline(time.Now().Add(ctlRequestTimeout))
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		tqlog.Error("Bad request on control socket: %s", err)
+		json.NewEncoder(conn).Encode(ctlResponse{Error: fmt.Sprintf("Bad request: %s", err)})
+		return
+	}
+
+	if err := json.NewEncoder(conn).Encode(s.execute(req)); err != nil {
+		tqlog.Error("Error sending response on control socket: %s", err)
+	}
+}
+
+// execute runs a command and returns its result
+func (s *ctlServer) execute(req ctlRequest) ctlResponse {
+	if req.Command == "shutdown" {
+		s.once.Do(func() { close(s.shutdown) })
+		return ctlResponse{true, "Stopping the daemon\n", ""}
+	}
+
+	return s.runner.run(req.Command, req.Args)
+}
+
+// ctlCommandNames returns the sorted names of the commands, supported by the daemon
+func ctlCommandNames() []string {
+	ret := []string{"reload", "shutdown"}
+	for name := range daemonCommands {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// cmdDaemon implements daemon subcommand. The captures are controlled over a
+// Unix domain socket with ctl subcommand. Returns the exit code of the program.
+func cmdDaemon(configFile string, logFile string, watch bool, apiAddr string, metricsAddr string, args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	socket := fs.String("socket", defaultSocketPath(), "path of the control socket")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: tranqap daemon [--socket path]\n")
+		return 1
+	}
+
+	config, err := newLoadedConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	printCb := func(f string, a ...interface{}) { fmt.Fprintf(os.Stderr, f, a...) }
+	if err := tqlog.Init(logFile, printCb); err != nil {
+		fmt.Fprintf(os.Stderr, "Error initialising logger: %s\nLog file won't be generated\n", err)
+	}
+	defer tqlog.Close()
+
+	listener, err := listenCtl(*socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating control socket: %s\n", err)
+		return 1
+	}
+
+	initStorage()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	stopWatch := make(chan struct{})
+	if watch == true {
+		go config.watch(2*time.Second, stopWatch)
+	}
+
+	runner := newCommandRunner(config)
+	server := newCtlServer(runner, listener)
+	go server.serve()
+
+	var api *apiServer
+	if len(apiAddr) > 0 {
+		api = newAPIServer(runner)
+		if err := api.start(apiAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting HTTP API: %s\n", err)
+			listener.Close()
+			capturers.Close()
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "HTTP API is listening on %s\n", apiAddr)
+	}
+
+	var metrics *metricsServer
+	if len(metricsAddr) > 0 {
+		metrics = newMetricsServer(configTargetNames(config))
+		if err := metrics.start(metricsAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting metrics listener: %s\n", err)
+			listener.Close()
+			if api != nil {
+				api.close()
+			}
+			capturers.Close()
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Metrics are served on http://%s/metrics\n", metricsAddr)
+	}
+
+	fmt.Fprintf(os.Stderr, "tranqap daemon is listening on %s\n", *socket)
+
+	select {
+	case s := <-sigs:
+		fmt.Fprintf(os.Stderr, "Got %s. Stopping the captures\n", s)
+	case <-server.shutdown:
+		fmt.Fprintf(os.Stderr, "Shutdown requested. Stopping the captures\n")
+	}
+
+	listener.Close()
+	if api != nil {
+		api.close()
+	}
+	if metrics != nil {
+		metrics.close()
+	}
+	close(stopWatch)
+
+	runner.close()
+
+	return 0
+}
+
+// sendCtlRequest sends a request to the daemon and returns its response
+func sendCtlRequest(socket string, req ctlRequest) (ctlResponse, error) {
+	var resp ctlResponse
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return resp, fmt.Errorf("Can't connect to the daemon: %s", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, fmt.Errorf("Error sending the command: %s", err)
+	}
+
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("Error reading the response: %s", err)
+	}
+
+	return resp, nil
+}
+
+// cmdCtl implements ctl subcommand - sends a command to the daemon and prints
+// its output. Returns the exit code of the program.
+func cmdCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	socket := fs.String("socket", defaultSocketPath(), "path of the control socket")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: tranqap ctl [--socket path] <command> [args]. Commands: %v\n", ctlCommandNames())
+		return 1
+	}
+
+	resp, err := sendCtlRequest(*socket, ctlRequest{fs.Arg(0), fs.Args()[1:]})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Print(resp.Output)
+	if !resp.OK {
+		fmt.Fprintln(os.Stderr, resp.Error)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCtlServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(fname, []byte("targets:\n- name: t1\n  host: 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lc, err := newLoadedConfig(fname)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+
+	socket := filepath.Join(dir, "ctl.sock")
+	listener, err := listenCtl(socket)
+	if err != nil {
+		t.Fatalf("Error creating socket: %s", err)
+	}
+
+	if _, err := listenCtl(socket); err == nil {
+		t.Errorf("Expected error for a socket with a running daemon")
+	}
+
+	initStorage()
+	server := newCtlServer(newCommandRunner(lc), listener)
+	go server.serve()
+
+	tests := []struct {
+		req    ctlRequest
+		ok     bool
+		output string
+		err    string
+	}{
+		{ctlRequest{"status", nil}, true, "There are no running captures.\n", ""},
+		{ctlRequest{"stop", []string{"t1"}}, false, "", "There are no running captures."},
+		{ctlRequest{"start", []string{"t2"}}, false, "", "Target <t2> doesn't exist"},
+		{ctlRequest{"bad", nil}, false, "", "Unknown command bad. Supported commands: [mark reload shutdown start status stop view wireshark]"},
+		{ctlRequest{"reload", nil}, true, "No changes in targets.\n", ""},
+	}
+
+	for _, test := range tests {
+		resp, err := sendCtlRequest(socket, test.req)
+		if err != nil {
+			t.Fatalf("Error sending %s: %s", test.req.Command, err)
+		}
+		expected := ctlResponse{test.ok, test.output, test.err}
+		if resp != expected {
+			t.Errorf("Expected %v for %s, got %v", expected, test.req.Command, resp)
+		}
+	}
+
+	if _, err := sendCtlRequest(socket, ctlRequest{"shutdown", nil}); err != nil {
+		t.Fatalf("Error sending shutdown: %s", err)
+	}
+	<-server.shutdown
+
+	listener.Close()
+	capturers.Close()
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Socket is not removed after the listener is closed")
+	}
+}
+
+func TestCommandRunnerIO(t *testing.T) {
+	cfg, err := parseConfig([]byte("targets:\n- name: t1\n  host: 10.0.0.1\n"), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	initStorage()
+	runner := newCommandRunner(&loadedConfig{conf: cfg})
+
+	var out bufferIO
+	if err := runner.runIO(&out, "status", nil); err != nil || out.String() != "There are no running captures.\n" {
+		t.Errorf("Unexpected result of status: %v %q", err, out.String())
+	}
+
+	runner.close()
+	if err := runner.runIO(&out, "status", nil); err == nil {
+		t.Errorf("Expected error after the runner is closed")
+	}
+}
@@ -0,0 +1,236 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+)
+
+// builtinDefaults returns the values of the optional target parameters, used
+// when they are not set anywhere in the configuration
+func builtinDefaults() target {
+	port := 22
+	rotationCnt := 10
+	keepAliveCnt := 3
+	useSudo := false
+
+	return target{
+		Port:         &port,
+		RotationCnt:  &rotationCnt,
+		KeepAliveCnt: &keepAliveCnt,
+		UseSudo:      &useSudo,
+	}
+}
+
+// resolveTargets expands the environment variables, applies the inherited values
+// to each target and expands the host patterns (see expandTargets). The
+// precedence of the values is:
+// 1. Values set in the target itself
+// 2. Values from OpenSSH client config
+// 3. Values from the target's group
+// 4. Values from the defaults section
+// 5. Built-in defaults
+// OpenSSH client config takes precedence over the inherited values, because
+// its Host entries are specific to the host, as ssh users expect.
+func resolveTargets(conf *configParams) error {
+	if errs := resolveAllTargets(conf); len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// targetError is a problem in a single target. source is the index of the
+// target in the configuration, before the host patterns are expanded.
+type targetError struct {
+	source int
+	err    error
+}
+
+func (e targetError) Error() string {
+	return e.err.Error()
+}
+
+// resolveAllTargets is resolveTargets, which doesn't stop on the first problem.
+// The problems in a single target are returned as targetError. Targets, which
+// can't be resolved (e.g. with a missing name or a bad host range), are removed.
+func resolveAllTargets(conf *configParams) []error {
+	errs := make([]error, 0)
+
+	if conf.Defaults != nil {
+		if conf.Defaults.Name != nil || conf.Defaults.Group != nil {
+			errs = append(errs, fmt.Errorf("name and group can't be set in defaults"))
+		}
+		if err := expandTargetEnv(conf.Defaults); err != nil {
+			errs = append(errs, fmt.Errorf("Error in defaults: %s", err))
+		}
+	}
+
+	for name, g := range conf.Groups {
+		if g.Name != nil || g.Group != nil {
+			errs = append(errs, fmt.Errorf("name and group can't be set in group %s", name))
+		}
+		if err := expandTargetEnv(&g); err != nil {
+			errs = append(errs, fmt.Errorf("Error in group %s: %s", name, err))
+		}
+		conf.Groups[name] = g
+	}
+
+	if err := validateViewers(conf); err != nil {
+		errs = append(errs, err)
+	}
+
+	for name, p := range conf.Profiles {
+		if err := validateProfile(name, p); err != nil {
+			errs = append(errs, err)
+		}
+		if err := conf.validateOutputs(p.Outputs, "profile "+name); err != nil {
+			errs = append(errs, err)
+		}
+		if err := expandTargetEnv(&p); err != nil {
+			errs = append(errs, fmt.Errorf("Error in profile %s: %s", name, err))
+		}
+		conf.Profiles[name] = p
+	}
+
+	// own contains the values, set in each target itself. valid contains the
+	// targets, which can be expanded, and their indexes.
+	own := make([]target, len(conf.Targets))
+	valid := make([]target, 0, len(conf.Targets))
+	validIdx := make([]int, 0, len(conf.Targets))
+	for i := range conf.Targets {
+		t := &conf.Targets[i]
+
+		if t.Name == nil {
+			errs = append(errs, targetError{i, fmt.Errorf("Missing Name for target #%d in configuration", i+1)})
+			continue
+		}
+
+		if err := expandTargetEnv(t); err != nil {
+			errs = append(errs, targetError{i, fmt.Errorf("Error in target <%s>: %s", *t.Name, err)})
+			continue
+		}
+		own[i] = *t
+
+		if t.Group != nil {
+			if g, ok := conf.Groups[*t.Group]; ok {
+				mergeTarget(t, g)
+			} else {
+				errs = append(errs, targetError{i, fmt.Errorf("target <%s> refers to unknown group %s", *t.Name, *t.Group)})
+			}
+		}
+
+		if conf.Defaults != nil {
+			mergeTarget(t, *conf.Defaults)
+		}
+
+		if err := conf.validateOutputs(t.Outputs, fmt.Sprintf("target <%s>", *t.Name)); err != nil {
+			errs = append(errs, targetError{i, err})
+		}
+
+		valid = append(valid, *t)
+		validIdx = append(validIdx, i)
+	}
+
+	// Host patterns are expanded before the OpenSSH client config is applied, because
+	// each generated host can be an alias there
+	expanded, sources, expandErrs := expandTargets(valid)
+	for _, err := range expandErrs {
+		err.source = validIdx[err.source]
+		errs = append(errs, err)
+	}
+	for i := range sources {
+		sources[i] = validIdx[sources[i]]
+	}
+
+	conf.sourceNames = make([]string, 0, len(conf.Targets))
+	for _, t := range conf.Targets {
+		name := ""
+		if t.Name != nil {
+			name = *t.Name
+		}
+		conf.sourceNames = append(conf.sourceNames, name)
+	}
+	conf.Targets = expanded
+	conf.sources = sources
+
+	for i := range conf.Targets {
+		t := &conf.Targets[i]
+
+		// The inherited connection parameters are applied only if they are
+		// not set in the OpenSSH client config
+		resolved := *t
+		resolved.dropInherited(own[conf.sources[i]])
+		if err := resolved.resolveSSHConfig(); err != nil {
+			errs = append(errs, targetError{conf.sources[i], fmt.Errorf("Error reading SSH config for target <%s>: %s", *t.Name, err)})
+		} else {
+			mergeTarget(&resolved, *t)
+			*t = resolved
+		}
+
+		mergeTarget(t, builtinDefaults())
+
+		// use_sudo is kept for backward compatibility. privilege takes precedence.
+		if t.Privilege == nil {
+			t.Privilege = new(string)
+			*t.Privilege = capture.PrivilegeNone
+			if *t.UseSudo == true {
+				*t.Privilege = capture.PrivilegeSudo
+			}
+		}
+	}
+
+	return errs
+}
+
+// dropInherited clears the connection parameters, which can be set by the OpenSSH
+// client config, unless they are set in own - the target before the inherited
+// values were applied
+func (t *target) dropInherited(own target) {
+	if own.User == nil {
+		t.User = nil
+	}
+	if own.Port == nil {
+		t.Port = nil
+	}
+	if own.Key == nil {
+		t.Key = nil
+	}
+	if own.Certificate == nil {
+		t.Certificate = nil
+	}
+	if own.ProxyJump == nil {
+		t.ProxyJump = nil
+	}
+}
+
+// mergeTarget sets each unset parameter of dst to a copy of the value from src
+func mergeTarget(dst *target, src target) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src)
+
+	for i := 0; i < dv.NumField(); i++ {
+		df := dv.Field(i)
+		sf := sv.Field(i)
+
+		if !df.IsNil() || sf.IsNil() {
+			continue
+		}
+
+		switch sf.Kind() {
+		case reflect.Ptr:
+			v := reflect.New(sf.Elem().Type())
+			v.Elem().Set(sf.Elem())
+			df.Set(v)
+		case reflect.Slice:
+			df.Set(reflect.AppendSlice(reflect.MakeSlice(sf.Type(), 0, sf.Len()), sf))
+		default:
+			df.Set(sf)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+)
+
+// envVarRe matches ${VAR} and ${VAR:-default}
+var envVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} with the value of the environment variable VAR.
+// ${VAR:-default} is replaced with default if VAR is not set or is empty.
+// It is an error to use ${VAR} without default, if VAR is not set.
+func expandEnv(s string) (string, error) {
+	var err error
+
+	ret := envVarRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envVarRe.FindStringSubmatch(m)
+		value, set := os.LookupEnv(sub[1])
+
+		if len(sub[2]) > 0 {
+			if len(value) == 0 {
+				return sub[3]
+			}
+			return value
+		}
+
+		if !set && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", sub[1])
+		}
+		return value
+	})
+
+	return ret, err
+}
+
+// noEnvExpansion contains the parameters which are not expanded. The shell
+// command in sudo_password_command is run with sh -c, which expands the
+// variables itself.
+var noEnvExpansion = map[string]bool{
+	"sudo_password_command": true,
+}
+
+// expandTargetEnv expands the environment variables in all string parameters of t
+func expandTargetEnv(t *target) error {
+	v := reflect.ValueOf(t).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.IsNil() || noEnvExpansion[yamlFieldName(v.Type().Field(i))] == true {
+			continue
+		}
+
+		switch f.Interface().(type) {
+		case *string:
+			s, err := expandEnv(f.Elem().String())
+			if err != nil {
+				return fmt.Errorf("%s: %s", yamlFieldName(v.Type().Field(i)), err)
+			}
+			f.Set(reflect.ValueOf(&s))
+		case []string:
+			list := make([]string, 0, f.Len())
+			for j := 0; j < f.Len(); j++ {
+				s, err := expandEnv(f.Index(j).String())
+				if err != nil {
+					return fmt.Errorf("%s: %s", yamlFieldName(v.Type().Field(i)), err)
+				}
+				list = append(list, s)
+			}
+			f.Set(reflect.ValueOf(list))
+		}
+	}
+
+	return nil
+}
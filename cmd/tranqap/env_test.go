@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("TRANQAP_TEST_HOST", "10.0.0.1")
+	os.Setenv("TRANQAP_TEST_EMPTY", "")
+	os.Unsetenv("TRANQAP_TEST_UNSET")
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"${TRANQAP_TEST_HOST}", "10.0.0.1"},
+		{"host-${TRANQAP_TEST_HOST}:22", "host-10.0.0.1:22"},
+		{"${TRANQAP_TEST_UNSET:-localhost}", "localhost"},
+		{"${TRANQAP_TEST_EMPTY:-localhost}", "localhost"},
+		{"${TRANQAP_TEST_HOST:-localhost}", "10.0.0.1"},
+		{"$TRANQAP_TEST_HOST", "$TRANQAP_TEST_HOST"},
+	}
+
+	for _, test := range tests {
+		res, err := expandEnv(test.in)
+		if err != nil {
+			t.Errorf("Error expanding %s: %s", test.in, err)
+		}
+		if res != test.out {
+			t.Errorf("Expected %s to expand to %s. Got %s", test.in, test.out, res)
+		}
+	}
+
+	if _, err := expandEnv("${TRANQAP_TEST_UNSET}"); err == nil {
+		t.Errorf("Expected error for unset variable")
+	}
+}
+
+func TestParseConfigEnv(t *testing.T) {
+	os.Setenv("TRANQAP_TEST_USER", "developer")
+	os.Unsetenv("TRANQAP_TEST_UNSET")
+
+	conf := `targets:
+- name: t1
+  host: ${TRANQAP_TEST_UNSET:-127.0.0.1}
+  user: ${TRANQAP_TEST_USER}
+  tags: ["${TRANQAP_TEST_USER}"]`
+
+	res, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	tgt := res.Targets[0]
+	if *tgt.Host != "127.0.0.1" || *tgt.User != "developer" || tgt.Tags[0] != "developer" {
+		t.Errorf("Bad expansion: %s %s %v", *tgt.Host, *tgt.User, tgt.Tags)
+	}
+}
+
+func TestParseConfigEnvSudoPassCmd(t *testing.T) {
+	os.Setenv("TRANQAP_TEST_USER", "developer")
+
+	conf := `targets:
+- name: t1
+  host: 127.0.0.1
+  sudo_password_command: pass show ${TRANQAP_TEST_USER}`
+
+	res, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	if cmd := *res.Targets[0].SudoPassCmd; cmd != "pass show ${TRANQAP_TEST_USER}" {
+		t.Errorf("sudo_password_command shouldn't be expanded: %s", cmd)
+	}
+}
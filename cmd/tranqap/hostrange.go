@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedHosts limits the number of hosts, generated from a single pattern.
+// It protects from typos like worker[1-10000].
+const maxExpandedHosts = 1024
+
+// hostRangeRe matches a range in a host pattern, e.g. [01-20] or [1,3,5-7]
+var hostRangeRe = regexp.MustCompile(`\[([0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*)\]`)
+
+// expandedHost is a single host, generated from a host pattern.
+// tokens are the values substituted for each range in the pattern.
+type expandedHost struct {
+	host   string
+	tokens []string
+}
+
+// expandHostPattern expands all ranges in pattern. E.g. worker[01-03].lab
+// expands to worker01.lab, worker02.lab and worker03.lab. If the start of a range
+// has got leading zeroes, all values are padded to the same width. Patterns without
+// ranges are returned as is.
+func expandHostPattern(pattern string) ([]expandedHost, error) {
+	loc := hostRangeRe.FindStringSubmatchIndex(pattern)
+	if loc == nil {
+		return []expandedHost{{pattern, nil}}, nil
+	}
+
+	values, err := parseHostRange(pattern[loc[2]:loc[3]])
+	if err != nil {
+		return nil, fmt.Errorf("bad range in %s: %s", pattern, err)
+	}
+
+	// Expand the rest of the pattern recursively
+	rest, err := expandHostPattern(pattern[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values)*len(rest) > maxExpandedHosts {
+		return nil, fmt.Errorf("%s expands to more than %d hosts", pattern, maxExpandedHosts)
+	}
+
+	prefix := pattern[:loc[0]]
+	ret := make([]expandedHost, 0, len(values)*len(rest))
+	for _, v := range values {
+		for _, r := range rest {
+			tokens := append([]string{v}, r.tokens...)
+			ret = append(ret, expandedHost{prefix + v + r.host, tokens})
+		}
+	}
+
+	return ret, nil
+}
+
+// parseHostRange parses the content of a range, e.g. 01-20 or 1,3,5-7
+func parseHostRange(r string) ([]string, error) {
+	ret := make([]string, 0)
+
+	for _, part := range strings.Split(r, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) == 1 {
+			ret = append(ret, part)
+			continue
+		}
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if first > last {
+			return nil, fmt.Errorf("start of %s is greater than its end", part)
+		}
+		if last-first >= maxExpandedHosts {
+			return nil, fmt.Errorf("%s has got more than %d values", part, maxExpandedHosts)
+		}
+
+		width := 0
+		if len(bounds[0]) > 1 && strings.HasPrefix(bounds[0], "0") {
+			width = len(bounds[0])
+		}
+
+		for n := first; n <= last; n++ {
+			ret = append(ret, fmt.Sprintf("%0*d", width, n))
+		}
+	}
+
+	return ret, nil
+}
+
+// expandTargets generates one target per host for each target with a host pattern.
+// The name of each generated target is the original name and the substituted values,
+// joined with '-' (e.g. workers-01). The destination is a subdirectory, named after
+// the host. The second return value contains the index of the original target for
+// each returned target. Targets with invalid host patterns are skipped and
+// reported in the third return value.
+func expandTargets(targets []target) ([]target, []int, []targetError) {
+	ret := make([]target, 0, len(targets))
+	sources := make([]int, 0, len(targets))
+	var errs []targetError
+
+	for i, t := range targets {
+		if t.Host == nil {
+			ret = append(ret, t)
+			sources = append(sources, i)
+			continue
+		}
+
+		hosts, err := expandHostPattern(*t.Host)
+		if err != nil {
+			errs = append(errs, targetError{i, fmt.Errorf("Invalid host for target <%s>: %s", *t.Name, err)})
+			continue
+		}
+
+		if len(hosts) == 1 && hosts[0].tokens == nil {
+			ret = append(ret, t)
+			sources = append(sources, i)
+			continue
+		}
+
+		for _, h := range hosts {
+			var newTarget target
+			mergeTarget(&newTarget, t)
+
+			*newTarget.Name = *t.Name + "-" + strings.Join(h.tokens, "-")
+			*newTarget.Host = h.host
+			if newTarget.Destination != nil {
+				*newTarget.Destination = filepath.Join(*t.Destination, h.host)
+			}
+
+			ret = append(ret, newTarget)
+			sources = append(sources, i)
+		}
+	}
+
+	return ret, sources, errs
+}
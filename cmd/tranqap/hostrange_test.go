@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestExpandHostPattern(t *testing.T) {
+	hosts, err := expandHostPattern("worker[08-10].lab")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []string{"worker08.lab", "worker09.lab", "worker10.lab"}
+	if len(hosts) != len(expected) {
+		t.Fatalf("Expected %d hosts, got %d", len(expected), len(hosts))
+	}
+	for i := range expected {
+		if hosts[i].host != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], hosts[i].host)
+		}
+	}
+
+	hosts, err = expandHostPattern("10.0.[1-2].[1,5-6]")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(hosts) != 6 || hosts[0].host != "10.0.1.1" || hosts[5].host != "10.0.2.6" {
+		t.Errorf("Bad expansion of multiple ranges: %v", hosts)
+	}
+	if len(hosts[3].tokens) != 2 || hosts[3].tokens[0] != "2" || hosts[3].tokens[1] != "1" {
+		t.Errorf("Bad tokens: %v", hosts[3].tokens)
+	}
+
+	hosts, err = expandHostPattern("plain.host")
+	if err != nil || len(hosts) != 1 || hosts[0].host != "plain.host" || hosts[0].tokens != nil {
+		t.Errorf("Plain host should not be expanded: %v %v", hosts, err)
+	}
+
+	if _, err := expandHostPattern("worker[5-1]"); err == nil {
+		t.Errorf("Expected error for reversed range")
+	}
+
+	if _, err := expandHostPattern("worker[1-100000]"); err == nil {
+		t.Errorf("Expected error for too large range")
+	}
+}
+
+var rangeConfig = `defaults:
+  destination: pcaps
+targets:
+- name: workers
+  host: worker[01-03].lab
+- name: workers-02
+  host: 10.0.0.1`
+
+func TestParseConfigHostRange(t *testing.T) {
+	conf := `defaults:
+  destination: pcaps
+targets:
+- name: workers
+  host: worker[01-03].lab`
+
+	res, err := parseConfig([]byte(conf), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	if len(res.Targets) != 3 {
+		t.Fatalf("Expected 3 targets, got %d", len(res.Targets))
+	}
+
+	tgt := res.Targets[1]
+	if *tgt.Name != "workers-02" || *tgt.Host != "worker02.lab" || *tgt.Destination != "pcaps/worker02.lab" {
+		t.Errorf("Bad expanded target: %s %s %s", *tgt.Name, *tgt.Host, *tgt.Destination)
+	}
+
+	// Duplicates are checked after the expansion
+	if _, err := parseConfig([]byte(rangeConfig), "."); err == nil {
+		t.Errorf("Expected error for duplicated generated name")
+	}
+}
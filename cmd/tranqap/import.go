@@ -0,0 +1,229 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// importDestination is the destination of the imported targets, if the config
+// file doesn't set one in its defaults section
+const importDestination = "pcaps"
+
+// importedTargetFields are the parameters set from the inventory. The rest are
+// not touched when an existing target is updated.
+var importedTargetFields = []string{"host", "port", "user", "key", "tags"}
+
+// ansibleTarget converts an inventory host to a target
+func ansibleTarget(h ansibleHost) (target, error) {
+	var t target
+
+	name := h.name
+	t.Name = &name
+
+	host := h.name
+	if v, ok := h.ansibleVar("ansible_host", "ansible_ssh_host"); ok {
+		host = v
+	}
+	t.Host = &host
+
+	if v, ok := h.ansibleVar("ansible_port", "ansible_ssh_port"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return t, fmt.Errorf("Bad port for host %s: %s", h.name, v)
+		}
+		t.Port = &port
+	}
+
+	if v, ok := h.ansibleVar("ansible_user", "ansible_ssh_user"); ok {
+		t.User = &v
+	}
+
+	if v, ok := h.ansibleVar("ansible_ssh_private_key_file", "ansible_private_key_file"); ok {
+		t.Key = &v
+	}
+
+	if len(h.groups) > 0 {
+		t.Tags = h.groups
+	}
+
+	return t, nil
+}
+
+// readAnsibleInventory parses an inventory. Files with .yml and .yaml extension
+// are parsed as YAML, the rest as INI.
+func readAnsibleInventory(fname string) (*ansibleInventory, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yml", ".yaml":
+		return parseAnsibleYAML(data)
+	default:
+		return parseAnsibleINI(data)
+	}
+}
+
+// mergeImportedTargets adds the targets to the configuration. Targets which
+// already exist are updated - the tags from the inventory are added to the
+// existing ones and the rest of importedTargetFields are overwritten. Returns
+// the new content of the config file and the number of added and updated targets.
+func mergeImportedTargets(data []byte, targets []target) ([]byte, int, int, error) {
+	var raw configParams
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, 0, 0, err
+	}
+
+	hasDestination := raw.Defaults != nil && raw.Defaults.Destination != nil
+	added, updated := 0, 0
+
+	for _, t := range targets {
+		idx, err := findRawTarget(raw, *t.Name)
+		if err != nil {
+			// New target
+			pattern := *t.Name
+			t.FilePattern = &pattern
+			if !hasDestination {
+				dest := importDestination
+				t.Destination = &dest
+			}
+
+			keys := make([]yamlKeyValue, 0)
+			for _, key := range targetPromptFields {
+				value, set, err := targetYAMLValue(&t, key)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				if set {
+					keys = append(keys, yamlKeyValue{key, value})
+				}
+			}
+
+			if data, err = yamlAddItem(data, "targets", keys); err != nil {
+				return nil, 0, 0, err
+			}
+			added++
+			continue
+		}
+
+		// Keep the tags, set manually in the config
+		for _, tag := range raw.Targets[idx].Tags {
+			if !t.hasTag(tag) {
+				t.Tags = append(t.Tags, tag)
+			}
+		}
+
+		for _, key := range importedTargetFields {
+			value, set, err := targetYAMLValue(&t, key)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if !set {
+				continue
+			}
+			if data, err = yamlSetKey(data, "targets", idx, key, value); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		updated++
+	}
+
+	return data, added, updated, nil
+}
+
+// cmdImport implements import subcommand. Returns the exit code of the program.
+func cmdImport(configFile string, args []string) int {
+	if len(args) == 0 || args[0] != "ansible" {
+		fmt.Fprintf(os.Stderr, "Usage: tranqap import ansible <inventory> [--group name]\n")
+		return 1
+	}
+	args = args[1:]
+
+	fs := flag.NewFlagSet("import ansible", flag.ContinueOnError)
+	group := fs.String("group", "", "import only the hosts from this inventory group")
+
+	// The inventory can be before or after the flags
+	inventory := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		inventory = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if len(inventory) == 0 && fs.NArg() == 1 {
+		inventory = fs.Arg(0)
+	} else if len(inventory) == 0 || fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: tranqap import ansible <inventory> [--group name]\n")
+		return 1
+	}
+
+	inv, err := readAnsibleInventory(inventory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading inventory %s: %s\n", inventory, err)
+		return 1
+	}
+
+	hosts, err := inv.resolvedHosts(*group)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing %s: %s\n", inventory, err)
+		return 1
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintf(os.Stderr, "No hosts found in %s\n", inventory)
+		return 1
+	}
+
+	targets := make([]target, 0, len(hosts))
+	for _, h := range hosts {
+		t, err := ansibleTarget(h)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing %s: %s\n", inventory, err)
+			return 1
+		}
+		targets = append(targets, t)
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		data = []byte(fmt.Sprintf("# Imported from %s\nversion: %d\n", inventory, configVersion))
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", configFile, err)
+		return 1
+	}
+
+	data, added, updated, err := mergeImportedTargets(data, targets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error merging targets to %s: %s\n", configFile, err)
+		return 1
+	}
+
+	if _, err := parseConfigFile(data, configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "The imported targets result in a bad configuration, %s is not changed: %s\n", configFile, err)
+		return 1
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(configFile); err == nil {
+		mode = fi.Mode()
+	}
+	if err := ioutil.WriteFile(configFile, data, mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", configFile, err)
+		return 1
+	}
+
+	fmt.Printf("Imported %d targets to %s (%d added, %d updated)\n", added+updated, configFile, added, updated)
+	return 0
+}
@@ -0,0 +1,155 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// loadIncludes merges the files listed in conf.Include into conf. Relative paths
+// are relative to baseDir. The included files are merged in the order they are
+// listed, so each file overrides the ones before it and conf itself overrides all
+// of them. parents contains the files which include conf and is used to detect
+// include loops.
+func loadIncludes(conf *configParams, baseDir string, parents []string) error {
+	var included configParams
+
+	for _, inc := range conf.Include {
+		path, err := expandEnv(inc)
+		if err != nil {
+			return fmt.Errorf("Bad include %s: %s", inc, err)
+		}
+		path = expandHome(path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if p == abs {
+				return fmt.Errorf("%s includes itself", path)
+			}
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Error reading included file: %s", err)
+		}
+
+		incConf, err := decodeConfig(data)
+		if err != nil {
+			return fmt.Errorf("Error parsing %s: %s", path, err)
+		}
+
+		if err := loadIncludes(&incConf, filepath.Dir(path), append(parents, abs)); err != nil {
+			return err
+		}
+		incConf.files = append([]string{path}, incConf.files...)
+
+		included = mergeConfigs(incConf, included)
+	}
+
+	*conf = mergeConfigs(*conf, included)
+	return nil
+}
+
+// mergeConfigs merges two configurations. Values from high take precedence.
+// Defaults, groups, profiles and targets with the same name are merged parameter by
+// parameter. The targets from low are first in the result.
+func mergeConfigs(high configParams, low configParams) configParams {
+	ret := configParams{
+		Version: high.Version,
+		Include: high.Include,
+		files:   append(append([]string{}, low.files...), high.files...),
+	}
+
+	if high.Defaults != nil || low.Defaults != nil {
+		ret.Defaults = new(target)
+		if high.Defaults != nil {
+			mergeTarget(ret.Defaults, *high.Defaults)
+		}
+		if low.Defaults != nil {
+			mergeTarget(ret.Defaults, *low.Defaults)
+		}
+	}
+
+	ret.Groups = mergeTargetMaps(high.Groups, low.Groups)
+	ret.Profiles = mergeTargetMaps(high.Profiles, low.Profiles)
+	ret.Viewers = mergeViewers(high.Viewers, low.Viewers)
+
+	ret.Targets = append(ret.Targets, low.Targets...)
+
+	lowIdx := make(map[string]int)
+	for i, t := range low.Targets {
+		if t.Name == nil {
+			continue
+		}
+		if _, exists := lowIdx[*t.Name]; !exists {
+			lowIdx[*t.Name] = i
+		}
+	}
+
+	for _, t := range high.Targets {
+		idx, exists := -1, false
+		if t.Name != nil {
+			idx, exists = lowIdx[*t.Name]
+		}
+
+		if !exists {
+			ret.Targets = append(ret.Targets, t)
+			continue
+		}
+
+		// Each target is overridden only once. Duplicates are reported later.
+		delete(lowIdx, *t.Name)
+		mergeTarget(&t, low.Targets[idx])
+		ret.Targets[idx] = t
+	}
+
+	return ret
+}
+
+// mergeTargetMaps merges named groups or profiles. Values from high take precedence.
+func mergeTargetMaps(high map[string]target, low map[string]target) map[string]target {
+	if len(high) == 0 && len(low) == 0 {
+		return nil
+	}
+
+	ret := make(map[string]target)
+	for name, t := range low {
+		ret[name] = t
+	}
+	for name, t := range high {
+		if lowTarget, exists := ret[name]; exists {
+			mergeTarget(&t, lowTarget)
+		}
+		ret[name] = t
+	}
+
+	return ret
+}
+
+// mergeViewers merges named viewers. A viewer from high replaces the one with
+// the same name from low.
+func mergeViewers(high map[string]viewerParams, low map[string]viewerParams) map[string]viewerParams {
+	if len(high) == 0 && len(low) == 0 {
+		return nil
+	}
+
+	ret := make(map[string]viewerParams)
+	for name, v := range low {
+		ret[name] = v
+	}
+	for name, v := range high {
+		ret[name] = v
+	}
+
+	return ret
+}
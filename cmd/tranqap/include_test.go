@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var sharedTargets = `defaults:
+  user: capture
+  destination: pcaps
+targets:
+- name: web
+  host: 10.0.0.1
+  file_pattern: web
+- name: db
+  host: 10.0.0.2
+  file_pattern: db
+`
+
+var personalOverrides = `defaults:
+  user: developer
+targets:
+- name: db
+  host: 127.0.0.1
+  port: 2222
+`
+
+var mainConfig = `include:
+- shared.yaml
+- personal.yaml
+targets:
+- name: local
+  host: 127.0.0.1
+  file_pattern: local
+`
+
+func writeTestFile(t *testing.T, dir string, name string, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing %s: %s", name, err)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "shared.yaml", sharedTargets)
+	writeTestFile(t, dir, "personal.yaml", personalOverrides)
+	writeTestFile(t, dir, "config.yaml", mainConfig)
+
+	res, err := readConfigFromFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Error reading config: %s", err)
+	}
+
+	if len(res.Targets) != 3 {
+		t.Fatalf("Expected 3 targets. Got %d", len(res.Targets))
+	}
+
+	expected := []struct {
+		name string
+		host string
+		port int
+	}{
+		{"web", "10.0.0.1", 22},
+		{"db", "127.0.0.1", 2222},
+		{"local", "127.0.0.1", 22},
+	}
+
+	for i, e := range expected {
+		tgt := res.Targets[i]
+		if *tgt.Name != e.name || *tgt.Host != e.host || *tgt.Port != e.port {
+			t.Errorf("Bad target #%d: %s %s %d", i, *tgt.Name, *tgt.Host, *tgt.Port)
+		}
+		if *tgt.User != "developer" {
+			t.Errorf("Bad user for %s: %s", *tgt.Name, *tgt.User)
+		}
+		if *tgt.FilePattern != e.name {
+			t.Errorf("Bad file pattern for %s: %s", *tgt.Name, *tgt.FilePattern)
+		}
+	}
+
+	if len(res.files) != 3 {
+		t.Errorf("Expected 3 config files. Got %v", res.files)
+	}
+}
+
+func TestIncludeLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "a.yaml", "include: [b.yaml]\ntargets:\n- name: a\n")
+	writeTestFile(t, dir, "b.yaml", "include: [a.yaml]\n")
+
+	if _, err := readConfigFromFile(filepath.Join(dir, "a.yaml")); err == nil {
+		t.Errorf("Expected error for include loop")
+	}
+}
+
+func TestIncludeSelf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "a.yaml", "include: [a.yaml]\ntargets:\n- name: a\n")
+
+	_, err = readConfigFromFile(filepath.Join(dir, "a.yaml"))
+	if err == nil || strings.Contains(err.Error(), "includes itself") == false {
+		t.Errorf("Expected error for self include, got %v", err)
+	}
+
+	problems := validateConfig([]byte("include: [a.yaml]\ntargets:\n- name: a\n"), filepath.Join(dir, "a.yaml"))
+	if len(problems) != 1 || strings.Contains(problems[0].msg, "includes itself") == false {
+		t.Errorf("Expected one self include problem, got %v", problems)
+	}
+}
+
+func TestValidateIncludedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFile(t, dir, "shared.yaml", "targets:\n- name: a\n  hots: b\n")
+	fname := filepath.Join(dir, "main.yaml")
+	shared := filepath.Join(dir, "shared.yaml")
+
+	problems := validateConfig([]byte("include: [shared.yaml]\n"), fname)
+
+	found := false
+	for _, p := range problems {
+		if p.file == shared {
+			found = true
+			if s := p.format(fname); strings.HasPrefix(s, shared+":3: ") == false || strings.Count(s, shared) != 1 {
+				t.Errorf("Bad formatting of included file problem: %s", s)
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected problem in %s, got %v", shared, problems)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/tqlog"
 
@@ -24,10 +25,25 @@ func main() {
 		fmt.Fprintf(os.Stderr, "init - creates sample configuration file. Works with -c.\n")
 		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml init\" - ", os.Args[0])
 		fmt.Fprintf(os.Stderr, "creates sample config named config.yaml in current working directory.\n")
+		fmt.Fprintf(os.Stderr, "validate - checks the configuration file and reports all problems. Works with -c.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml validate\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "migrate - upgrades the configuration file to the current version. A backup is saved next to it. Works with -c.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml migrate\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "run [--targets a,b] [--duration 60s] [--profile name] - captures without the shell until the duration elapses or SIGINT/SIGTERM is received. Works with -c.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml run --targets web1,@db --duration 5m\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "daemon [--socket path] - runs without the shell. The captures are controlled with ctl. Works with -c.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml daemon\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "ctl [--socket path] <command> [args] - sends a command (start, stop, status, mark, wireshark, view, reload or shutdown) to the daemon.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s ctl start @web\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "import ansible <inventory> [--group name] - adds the hosts from Ansible inventory to the config file. Works with -c.\n")
+		fmt.Fprintf(os.Stderr, "\tE.g. \"%s -c config.yaml import ansible hosts.ini --group web\"\n", os.Args[0])
 	}
 
 	var configFile = flag.String("c", "config.yaml", "config file to use")
 	var logFile = flag.String("l", "", "path to log file")
+	var watchConfig = flag.Bool("w", false, "reload the config file automatically when it is modified")
+	var apiAddr = flag.String("api", "", "enable the HTTP API on this address, e.g. 127.0.0.1:8787")
+	var metricsAddr = flag.String("metrics", "", "serve Prometheus metrics on /metrics on this address, e.g. 127.0.0.1:9787")
 
 	flag.Parse()
 
@@ -45,6 +61,35 @@ func main() {
 			return
 		}
 
+		if len(flag.Args()) == 1 && flag.Arg(0) == "validate" {
+			tqlog.Info("Called validate command")
+			os.Exit(cmdValidate(*configFile))
+		}
+
+		if len(flag.Args()) == 1 && flag.Arg(0) == "migrate" {
+			tqlog.Info("Called migrate command")
+			os.Exit(cmdMigrate(*configFile))
+		}
+
+		if flag.Arg(0) == "run" {
+			tqlog.Info("Called run command with args %v", flag.Args()[1:])
+			os.Exit(cmdRun(*configFile, *logFile, *metricsAddr, flag.Args()[1:]))
+		}
+
+		if flag.Arg(0) == "daemon" {
+			tqlog.Info("Called daemon command with args %v", flag.Args()[1:])
+			os.Exit(cmdDaemon(*configFile, *logFile, *watchConfig, *apiAddr, *metricsAddr, flag.Args()[1:]))
+		}
+
+		if flag.Arg(0) == "ctl" {
+			os.Exit(cmdCtl(flag.Args()[1:]))
+		}
+
+		if flag.Arg(0) == "import" {
+			tqlog.Info("Called import command with args %v", flag.Args()[1:])
+			os.Exit(cmdImport(*configFile, flag.Args()[1:]))
+		}
+
 		//bad cmd
 		fmt.Fprintf(os.Stderr, "Bad subcommand: %v\n", flag.Args())
 		flag.Usage()
@@ -52,12 +97,33 @@ func main() {
 	}
 
 	// Get configuration
-	config, err := readConfigFromFile(*configFile)
+	config, err := newLoadedConfig(*configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
 		return
 	}
-	targetsList := config.getTargetsList()
+	if version := config.get().Version; version < configVersion {
+		fmt.Fprintf(os.Stderr, "Warning: %s uses config version %d. Run \"%s -c %s migrate\" to upgrade it to version %d\n",
+			*configFile, version, os.Args[0], *configFile, configVersion)
+	}
+	selectorsCompleter := func([]string) []string {
+		cfg := config.get()
+		return cfg.getSelectorsList()
+	}
+	viewCompleter := func(args []string) []string {
+		cfg := config.get()
+		if len(args) == 0 {
+			return cfg.getViewersList()
+		}
+		return cfg.getSelectorsList()
+	}
+	startCompleter := func(args []string) []string {
+		cfg := config.get()
+		if len(args) > 0 && args[len(args)-1] == "--profile" {
+			return cfg.getProfilesList()
+		}
+		return append(cfg.getSelectorsList(), "--profile", "--label")
+	}
 
 	// Create shell
 	shell := ishell.New()
@@ -74,35 +140,126 @@ func main() {
 
 	tqlog.Info("Program started.")
 
+	// The shell commands, which change the captures, are executed by the same
+	// runner as the commands from the HTTP API
+	runner := newCommandRunner(config)
+
 	shell.Interrupt(func(c *ishell.Context, count int, input string) {
 		c.Stop()
 	})
 
 	shell.AddCmd(&ishell.Cmd{
-		Name: "start",
-		Help: "start file capturing",
-		Func: func(ctx *ishell.Context) { cmdStart(ctx, config) },
+		Name:      "start",
+		Help:      "start file capturing",
+		Func:      runnerCmd(runner, "start"),
+		Completer: startCompleter,
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name:      "stop",
+		Help:      "stop file capturing",
+		Func:      runnerCmd(runner, "stop"),
+		Completer: selectorsCompleter,
 	})
 	shell.AddCmd(&ishell.Cmd{
-		Name: "stop",
-		Help: "stop file capturing",
-		Func: cmdStop,
+		Name:      "wireshark",
+		Help:      "fork wireshark for each capture",
+		Func:      runnerCmd(runner, "wireshark"),
+		Completer: selectorsCompleter,
 	})
 	shell.AddCmd(&ishell.Cmd{
-		Name: "wireshark",
-		Help: "fork wireshark for each capture",
-		Func: cmdWireshark,
-		Completer: func([]string) []string {
-			return targetsList
-		},
+		Name:      "view",
+		Help:      "start a configured viewer for each capture, e.g. view termshark",
+		Func:      runnerCmd(runner, "view"),
+		Completer: viewCompleter,
 	})
 	shell.AddCmd(&ishell.Cmd{
-		Name: "targets",
-		Help: "show information about loaded targets",
-		Func: func(ctx *ishell.Context) { cmdTargets(ctx, config) },
+		Name: "status",
+		Help: "show the running captures",
+		Func: runnerCmd(runner, "status"),
 	})
+	shell.AddCmd(&ishell.Cmd{
+		Name:      "watch",
+		Help:      "print a summary of each captured packet until Ctrl-C is pressed",
+		Func:      shellCmd(cmdWatch, config),
+		Completer: selectorsCompleter,
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "mark",
+		Help: "insert a timestamped marker in the running captures, e.g. mark \"clicked submit\"",
+		Func: runnerCmd(runner, "mark"),
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name:      "targets",
+		Help:      "show information about loaded targets",
+		Func:      func(ctx *ishell.Context) { cmdTargets(ctx, config.get()) },
+		Completer: selectorsCompleter,
+	})
+	shell.AddCmd(&ishell.Cmd{
+		Name: "reload",
+		Help: "reload the config file",
+		Func: runnerCmd(runner, "reload"),
+	})
+
+	rawTargetsCompleter := func([]string) []string {
+		return rawTargetNames(config.fname)
+	}
+	targetCmd := &ishell.Cmd{
+		Name: "target",
+		Help: "add, edit or remove a target in the config file",
+		Func: func(ctx *ishell.Context) { ctx.Println("Usage: target add|edit|remove") },
+	}
+	targetCmd.AddCmd(&ishell.Cmd{
+		Name: "add",
+		Help: "add new target",
+		Func: func(ctx *ishell.Context) { cmdTargetAdd(ctx, config) },
+	})
+	targetCmd.AddCmd(&ishell.Cmd{
+		Name:      "edit",
+		Help:      "edit a target",
+		Func:      func(ctx *ishell.Context) { cmdTargetEdit(ctx, config) },
+		Completer: rawTargetsCompleter,
+	})
+	targetCmd.AddCmd(&ishell.Cmd{
+		Name:      "remove",
+		Help:      "remove a target",
+		Func:      func(ctx *ishell.Context) { cmdTargetRemove(ctx, config) },
+		Completer: rawTargetsCompleter,
+	})
+	shell.AddCmd(targetCmd)
+
+	stopWatch := make(chan struct{})
+	if *watchConfig == true {
+		go config.watch(2*time.Second, stopWatch)
+	}
+
+	var api *apiServer
+	if len(*apiAddr) > 0 {
+		api = newAPIServer(runner)
+		if err := api.start(*apiAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting HTTP API: %s\n", err)
+			return
+		}
+		fmt.Printf("HTTP API is listening on %s\n", *apiAddr)
+	}
+
+	var metrics *metricsServer
+	if len(*metricsAddr) > 0 {
+		metrics = newMetricsServer(configTargetNames(config))
+		if err := metrics.start(*metricsAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting metrics listener: %s\n", err)
+			return
+		}
+		fmt.Printf("Metrics are served on http://%s/metrics\n", *metricsAddr)
+	}
 
 	shell.Run()
-	capturers.Close()
+	if api != nil {
+		api.close()
+	}
+	if metrics != nil {
+		metrics.close()
+	}
+	close(stopWatch)
+	runner.close()
 	tqlog.Close()
 }
@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/output"
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// marksLogSuffix replaces .pcap in the name of the capture file to get the
+// name of its marks log
+const marksLogSuffix = ".marks.log"
+
+// marksLogPath returns the path of the marks log of a capture file
+func marksLogPath(captureFile string) string {
+	return strings.TrimSuffix(captureFile, ".pcap") + marksLogSuffix
+}
+
+// appendMark appends a line with the timestamp and the text of a mark to the
+// marks log
+func appendMark(fname string, t time.Time, text string) error {
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%s %s\n", t.Format(time.RFC3339Nano), text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// cmdMark inserts a marker packet with the text in each running capture and
+// appends the mark to the marks log next to each capture file
+func cmdMark(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called mark command with args %v", args)
+
+	text := strings.TrimSpace(strings.Join(args, " "))
+	if len(text) == 0 {
+		return errors.New("Usage: mark <text>")
+	}
+	// A mark is a single line in the marks log
+	text = strings.Join(strings.Fields(text), " ")
+
+	now := time.Now()
+	results := capturers.Mark(now, text)
+	if len(results) == 0 {
+		return errors.New("There are no running captures.")
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			tqlog.Error("Can't insert mark for %s: %s", name, err)
+			cio.Printf("Can't insert mark in the capture of <%s>: %s\n", name, err)
+		}
+	}
+
+	for _, s := range capturers.Status() {
+		if _, ok := results[s.Name]; !ok {
+			continue
+		}
+		for _, m := range s.Output.Members {
+			if m.Kind != output.KindFile {
+				continue
+			}
+			if err := appendMark(marksLogPath(m.Path), now, text); err != nil {
+				tqlog.Error("Can't write mark for %s: %s", s.Name, err)
+				cio.Printf("Can't write the mark of <%s> to %s: %s\n", s.Name, marksLogPath(m.Path), err)
+			}
+		}
+	}
+
+	cio.Printf("Marked %d capture(s) at %s\n", len(names), now.Format("15:04:05.000"))
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMarksLogPath(t *testing.T) {
+	if p := marksLogPath("/tmp/web1-login.pcap"); p != "/tmp/web1-login.marks.log" {
+		t.Errorf("Bad marks log path %s", p)
+	}
+}
+
+func TestAppendMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "t1.marks.log")
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	for _, text := range []string{"clicked submit", "got error"} {
+		if err := appendMark(fname, ts, text); err != nil {
+			t.Fatalf("Error appending mark: %s", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "2020-01-02T03:04:05.6Z clicked submit\n2020-01-02T03:04:05.6Z got error\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
+
+func TestCmdMarkErrors(t *testing.T) {
+	initStorage()
+	defer capturers.Close()
+
+	var out bufferIO
+	if err := cmdMark(&out, configParams{}, []string{" "}); err == nil || err.Error() != "Usage: mark <text>" {
+		t.Errorf("Expected usage error, got %v", err)
+	}
+	if err := cmdMark(&out, configParams{}, []string{"clicked", "submit"}); err == nil || err.Error() != "There are no running captures." {
+		t.Errorf("Expected error for no running captures, got %v", err)
+	}
+}
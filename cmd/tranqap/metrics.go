@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// metricFamily is a metric, exported for each target. value returns false if
+// the metric has no value for the target.
+type metricFamily struct {
+	name  string
+	kind  string
+	help  string
+	value func(m capture.Metrics) (float64, bool)
+}
+
+func boolMetric(b bool) float64 {
+	if b == true {
+		return 1
+	}
+	return 0
+}
+
+var metricFamilies = []metricFamily{
+	{"tranqap_capture_up", "gauge", "1 if there is a running capture for the target",
+		func(m capture.Metrics) (float64, bool) { return boolMetric(m.Running), true }},
+	{"tranqap_capture_starts_total", "counter", "Number of started captures",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Starts), true }},
+	{"tranqap_capture_restarts_total", "counter", "Number of captures started after the previous capture has died",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Restarts), true }},
+	{"tranqap_capture_deaths_total", "counter", "Number of captures which have stopped unexpectedly",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Deaths), true }},
+	{"tranqap_capture_received_bytes_total", "counter", "Bytes received from the target",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Bytes), true }},
+	{"tranqap_capture_received_packets_total", "counter", "Packets received from the target",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Packets), true }},
+	{"tranqap_capture_throughput_bytes_per_second", "gauge", "Bytes received during the last second",
+		func(m capture.Metrics) (float64, bool) { return float64(m.Throughput), true }},
+	{"tranqap_capture_last_data_timestamp_seconds", "gauge", "Unix time when data was last received from the target",
+		func(m capture.Metrics) (float64, bool) {
+			if m.LastData.IsZero() {
+				return 0, false
+			}
+			return float64(m.LastData.UnixNano()) / 1e9, true
+		}},
+	{"tranqap_capture_kernel_dropped_packets_total", "counter", "Packets dropped by the kernel, as reported by tcpdump when it exits",
+		func(m capture.Metrics) (float64, bool) { return float64(m.KernelDrops), true }},
+	{"tranqap_outputer_drops_total", "counter", "Number of outputers (e.g. Wireshark) removed because they have exited",
+		func(m capture.Metrics) (float64, bool) { return float64(m.OutputerDrops), true }},
+	{"tranqap_file_rotations_total", "counter", "Number of capture file rotations",
+		func(m capture.Metrics) (float64, bool) { return float64(m.FileRotations), true }},
+}
+
+// escapeLabel escapes a label value for the Prometheus text format
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeMetrics writes the metrics in Prometheus text format. targets are the
+// configured targets. They are exported even if no capture was started for them.
+func writeMetrics(w io.Writer, targets []string, metrics []capture.Metrics) error {
+	all := make(map[string]capture.Metrics)
+	for _, name := range targets {
+		all[name] = capture.Metrics{Name: name}
+	}
+	for _, m := range metrics {
+		all[m.Name] = m
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, f := range metricFamilies {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		for _, name := range names {
+			if v, ok := f.value(all[name]); ok {
+				fmt.Fprintf(bw, "%s{target=\"%s\"} %s\n", f.name, escapeLabel(name), strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// metricsHandler serves the metrics of the captures. targets returns the names
+// of the configured targets.
+type metricsHandler struct {
+	targets func() []string
+}
+
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := writeMetrics(w, h.targets(), capturers.Metrics()); err != nil {
+		tqlog.Error("Error writing metrics: %s", err)
+	}
+}
+
+// configTargetNames returns a function, which returns the names of the targets
+// in the current configuration
+func configTargetNames(lc *loadedConfig) func() []string {
+	return func() []string {
+		cfg := lc.get()
+		ret := make([]string, 0, len(cfg.Targets))
+		for _, t := range cfg.Targets {
+			ret = append(ret, *t.Name)
+		}
+		return ret
+	}
+}
+
+// metricsServer is the optional HTTP listener for /metrics
+type metricsServer struct {
+	server *http.Server
+}
+
+func newMetricsServer(targets func() []string) *metricsServer {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler{targets})
+
+	return &metricsServer{&http.Server{Handler: mux}}
+}
+
+// start starts listening on addr. Requests are served in the background.
+func (s *metricsServer) start(addr string) error {
+	return serveHTTP(s.server, addr, "Metrics listener")
+}
+
+func (s *metricsServer) close() {
+	s.server.Close()
+}
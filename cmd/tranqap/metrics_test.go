@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	metrics := []capture.Metrics{
+		{
+			Name:          "web1",
+			Running:       true,
+			Starts:        3,
+			Restarts:      1,
+			Deaths:        1,
+			Bytes:         123456,
+			Packets:       789,
+			Throughput:    1024,
+			LastData:      time.Unix(1500000000, 500000000),
+			OutputerDrops: 2,
+			KernelDrops:   5,
+			FileRotations: 2,
+		},
+		{Name: `odd"name`},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, []string{"web1", "db1"}, metrics); err != nil {
+		t.Fatalf("Error writing metrics: %s", err)
+	}
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE tranqap_capture_up gauge\n",
+		"# TYPE tranqap_capture_received_bytes_total counter\n",
+		`tranqap_capture_up{target="db1"} 0` + "\n",
+		`tranqap_capture_up{target="web1"} 1` + "\n",
+		`tranqap_capture_up{target="odd\"name"} 0` + "\n",
+		`tranqap_capture_starts_total{target="web1"} 3` + "\n",
+		`tranqap_capture_restarts_total{target="web1"} 1` + "\n",
+		`tranqap_capture_deaths_total{target="web1"} 1` + "\n",
+		`tranqap_capture_received_bytes_total{target="web1"} 123456` + "\n",
+		`tranqap_capture_received_packets_total{target="web1"} 789` + "\n",
+		`tranqap_capture_throughput_bytes_per_second{target="web1"} 1024` + "\n",
+		`tranqap_capture_last_data_timestamp_seconds{target="web1"} 1500000000.5` + "\n",
+		`tranqap_capture_kernel_dropped_packets_total{target="web1"} 5` + "\n",
+		`tranqap_outputer_drops_total{target="web1"} 2` + "\n",
+		`tranqap_file_rotations_total{target="web1"} 2` + "\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in the metrics:\n%s", e, out)
+		}
+	}
+
+	if strings.Contains(out, `tranqap_capture_last_data_timestamp_seconds{target="db1"}`) {
+		t.Errorf("Unexpected last data timestamp for a target without data:\n%s", out)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	initStorage()
+	defer capturers.Close()
+
+	server := httptest.NewServer(newMetricsServer(func() []string { return []string{"t1"} }).server.Handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Error getting metrics: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Bad response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `tranqap_capture_up{target="t1"} 0`) {
+		t.Errorf("Expected t1 to be down:\n%s", body)
+	}
+
+	resp, err = http.Post(server.URL+"/metrics", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Error posting metrics: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for POST, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,225 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+	"gopkg.in/yaml.v3"
+)
+
+// configMigrations contains the functions, which upgrade a config file to the
+// next version. The first one upgrades version 1 to version 2 and so on. Each
+// function edits the content of the file and gets it decoded with the old
+// version. Only the changed lines are replaced, so the comments and the
+// formatting are preserved.
+var configMigrations = []func(data []byte, conf configParams) ([]byte, error){
+	migrateUseSudo,
+}
+
+// migrateUseSudo replaces use_sudo with privilege. use_sudo is removed without
+// replacement if privilege is already set for the target, because privilege
+// takes precedence over use_sudo in version 1.
+func migrateUseSudo(data []byte, conf configParams) ([]byte, error) {
+	defaultsPrivilege := conf.Defaults != nil && conf.Defaults.Privilege != nil
+	groupPrivilege := func(name *string) bool {
+		if name == nil {
+			return false
+		}
+		g, ok := conf.Groups[*name]
+		return ok && g.Privilege != nil
+	}
+
+	var err error
+	ret := data
+
+	if conf.Defaults != nil && conf.Defaults.UseSudo != nil {
+		find := func(doc *yaml.Node) (*yaml.Node, error) {
+			if m := yamlMapping(doc, "defaults"); m != nil {
+				return m, nil
+			}
+			return nil, fmt.Errorf("use_sudo in defaults can't be migrated, because defaults is not a mapping")
+		}
+		if ret, err = replaceUseSudo(ret, find, *conf.Defaults.UseSudo, defaultsPrivilege); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, g := range conf.Groups {
+		if g.UseSudo == nil {
+			continue
+		}
+		name := name
+		find := func(doc *yaml.Node) (*yaml.Node, error) {
+			if m := yamlMapping(doc, "groups", name); m != nil {
+				return m, nil
+			}
+			return nil, fmt.Errorf("use_sudo in group %s can't be migrated, because the group is not a mapping", name)
+		}
+		if ret, err = replaceUseSudo(ret, find, *g.UseSudo, g.Privilege != nil || defaultsPrivilege); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, t := range conf.Targets {
+		if t.UseSudo == nil {
+			continue
+		}
+		i := i
+		find := func(doc *yaml.Node) (*yaml.Node, error) {
+			m, err := yamlSectionItem(doc, "targets", i)
+			if err != nil {
+				return nil, fmt.Errorf("use_sudo in the targets can't be migrated: %s", err)
+			}
+			return m, nil
+		}
+		remove := t.Privilege != nil || groupPrivilege(t.Group) || defaultsPrivilege
+		if ret, err = replaceUseSudo(ret, find, *t.UseSudo, remove); err != nil {
+			return nil, err
+		}
+	}
+
+	return ret, nil
+}
+
+// replaceUseSudo replaces use_sudo in a mapping with the equivalent privilege or
+// removes it, if remove is true. find returns the mapping from the parsed data.
+func replaceUseSudo(data []byte, find func(doc *yaml.Node) (*yaml.Node, error), useSudo bool, remove bool) ([]byte, error) {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := find(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if remove {
+		return yamlRemoveMapKey(data, doc, m, "use_sudo")
+	}
+
+	privilege := capture.PrivilegeNone
+	if useSudo == true {
+		privilege = capture.PrivilegeSudo
+	}
+	return yamlSetMapKey(data, doc, m, "use_sudo", "privilege", privilege)
+}
+
+// yamlSetVersion sets the version key of a config file. If the key doesn't exist,
+// it is added as the first key, after the leading comments.
+func yamlSetVersion(data []byte, version int) ([]byte, error) {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	top := doc.Content[0]
+	value := strconv.Itoa(version)
+
+	if yamlMapIndex(top, "version") != -1 || len(top.Content) == 0 || top.Style&yaml.FlowStyle != 0 {
+		return yamlSetMapKey(data, doc, top, "version", "version", value)
+	}
+
+	first := top.Content[0]
+	return newYAMLLines(data).replace(first.Line, first.Line-1, []string{yamlIndent(first.Column) + "version: " + value}).bytes()
+}
+
+// migrateConfig upgrades the content of a config file to the current version.
+// Returns the new content and the version of the original file. The result is
+// checked with the current version rules before it is returned.
+func migrateConfig(data []byte) ([]byte, int, error) {
+	version, err := readConfigVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ret := data
+	for v := version; v < configVersion; v++ {
+		conf, err := decodeConfig(ret)
+		if err != nil {
+			return nil, version, err
+		}
+
+		if ret, err = configMigrations[v-1](ret, conf); err != nil {
+			return nil, version, err
+		}
+		if ret, err = yamlSetVersion(ret, v+1); err != nil {
+			return nil, version, err
+		}
+	}
+
+	if _, err := decodeConfig(ret); err != nil {
+		return nil, version, fmt.Errorf("The migrated configuration is not valid: %s", err)
+	}
+
+	return ret, version, nil
+}
+
+// backupName returns a name for the backup of a config file, which doesn't exist
+func backupName(fname string, version int) string {
+	base := fmt.Sprintf("%s.v%d.bak", fname, version)
+
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+// cmdMigrate implements migrate subcommand. Returns the exit code of the program.
+func cmdMigrate(fname string) int {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration: %s\n", err)
+		return 1
+	}
+
+	version, err := readConfigVersion(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", fname, err)
+		return 1
+	}
+	if version == configVersion {
+		fmt.Printf("%s is already at version %d\n", fname, configVersion)
+		return 0
+	}
+
+	newData, _, err := migrateConfig(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error migrating %s, the file is not changed: %s\n", fname, err)
+		return 1
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(fname); err == nil {
+		mode = fi.Mode()
+	}
+
+	backup := backupName(fname, version)
+	if err := ioutil.WriteFile(backup, data, mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing backup %s, %s is not changed: %s\n", backup, fname, err)
+		return 1
+	}
+
+	if err := ioutil.WriteFile(fname, newData, mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", fname, err)
+		return 1
+	}
+
+	fmt.Printf("Migrated %s from version %d to version %d. The original file is saved as %s\n", fname, version, configVersion, backup)
+
+	if conf, err := decodeConfig(newData); err == nil && len(conf.Include) > 0 {
+		fmt.Printf("The included files are not migrated. Run migrate for each of them.\n")
+	}
+
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeConfigVersion(t *testing.T) {
+	tests := []struct {
+		config  string
+		version int
+		err     string
+	}{
+		{"targets:\n- name: t1\n  unknown: 1\n", 1, ""},
+		{"targets:\n- name: t1\n  use_sudo: true\n", 1, ""},
+		{"version: 2\ntargets:\n- name: t1\n", 2, ""},
+		{"version: 2\ntargets:\n- name: t1\n  unknown: 1\n", 0, "field unknown not found"},
+		{"version: 2\ntargets:\n- name: t1\n  use_sudo: true\n", 0, "use_sudo is not supported in config version 2, use privilege instead (target <t1>)"},
+		{"version: 2\ngroups:\n  g1:\n    use_sudo: true\ntargets:\n- name: t1\n", 0, "(group g1)"},
+		{"version: 3\ntargets:\n- name: t1\n", 0, "Unsupported config version 3"},
+	}
+
+	for _, test := range tests {
+		conf, err := decodeConfig([]byte(test.config))
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Expected error containing %q for %q, got %v", test.err, test.config, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.config, err)
+			continue
+		}
+		if conf.Version != test.version {
+			t.Errorf("Expected version %d for %q, got %d", test.version, test.config, conf.Version)
+		}
+	}
+}
+
+var migrateV1Config = `# Lab targets
+defaults:
+  user: capture
+  use_sudo: true # everywhere
+groups:
+  db:
+    use_sudo: false
+    privilege: doas
+  web:
+    use_sudo: false
+targets:
+- name: t1
+  host: 10.0.0.1
+  use_sudo: false
+- use_sudo: true
+  name: t2
+  host: 10.0.0.2
+  privilege: run0
+- name: t3
+  group: db
+  use_sudo: true
+- name: t4
+  group: web
+`
+
+var migrateV2Config = `# Lab targets
+version: 2
+defaults:
+  user: capture
+  privilege: sudo # everywhere
+groups:
+  db:
+    privilege: doas
+  web:
+    privilege: none
+targets:
+- name: t1
+  host: 10.0.0.1
+  privilege: none
+- name: t2
+  host: 10.0.0.2
+  privilege: run0
+- name: t3
+  group: db
+- name: t4
+  group: web
+`
+
+func TestMigrateConfig(t *testing.T) {
+	before, err := parseConfig([]byte(migrateV1Config), ".")
+	if err != nil {
+		t.Fatalf("Error parsing version 1 config: %s", err)
+	}
+
+	data, version, err := migrateConfig([]byte(migrateV1Config))
+	if err != nil {
+		t.Fatalf("Error migrating config: %s", err)
+	}
+
+	if version != 1 {
+		t.Errorf("Expected version 1, got %d", version)
+	}
+
+	if string(data) != migrateV2Config {
+		t.Errorf("Bad migrated config:\n%s", data)
+	}
+
+	after, err := parseConfig(data, ".")
+	if err != nil {
+		t.Fatalf("Error parsing migrated config: %s", err)
+	}
+
+	for i := range before.Targets {
+		if *before.Targets[i].Privilege != *after.Targets[i].Privilege {
+			t.Errorf("Privilege of <%s> changed from %s to %s", *before.Targets[i].Name,
+				*before.Targets[i].Privilege, *after.Targets[i].Privilege)
+		}
+	}
+}
+
+func TestMigrateConfigExistingVersion(t *testing.T) {
+	data, _, err := migrateConfig([]byte("---\nversion: 1\ntargets:\n- name: t1\n"))
+	if err != nil {
+		t.Fatalf("Error migrating config: %s", err)
+	}
+
+	expected := "---\nversion: 2\ntargets:\n- name: t1\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
+
+func TestMigrateConfigUnknownKey(t *testing.T) {
+	_, _, err := migrateConfig([]byte("targets:\n- name: t1\n  unknown: 1\n"))
+	if err == nil || !strings.Contains(err.Error(), "The migrated configuration is not valid") {
+		t.Errorf("Expected error for unknown key, got %v", err)
+	}
+}
+
+func TestMigrateConfigFlowStyle(t *testing.T) {
+	conf := "defaults: {use_sudo: true}\ntargets: [{name: t1, use_sudo: false}, {name: t2}]\n"
+
+	data, _, err := migrateConfig([]byte(conf))
+	if err != nil {
+		t.Fatalf("Error migrating config: %s", err)
+	}
+
+	expected := "version: 2\ndefaults: {privilege: sudo}\ntargets: [{name: t1, privilege: none}, {name: t2}]\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
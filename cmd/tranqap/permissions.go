@@ -6,7 +6,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"strings"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
 )
 
 type stdOutWriter struct {
@@ -18,30 +21,71 @@ func (b stdOutWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func cmdPermissions() string {
-	cmd :=
-		`
-	tranqap_permissions() {
-		printf "Check if tcpdump is installed: "
-		TCPDUMP_BIN=$(command -v tcpdump)
+// cmdPermissions returns the permission check script. The privilege escalation checks
+// match the configured method. Without privilege escalation sudo is checked, as it
+// is the most common method. If the password is set, the script expects it on
+// stdin and checks if it works too.
+func cmdPermissions(privilege capture.PrivilegeConfig) string {
+	if !privilege.Elevated() {
+		privilege = capture.PrivilegeConfig{Method: capture.PrivilegeSudo}
+	}
+
+	// Custom templates are too long for the report. Use the binary instead.
+	name := privilege.Method
+	if strings.Contains(name, capture.PrivilegeCmdPlaceholder) {
+		name = privilege.Binary()
+	}
+
+	var checks strings.Builder
+
+	if bin := privilege.Binary(); len(bin) > 0 {
+		checks.WriteString(`
+		printf "Check if ` + bin + ` is installed: "
+		command -v ` + bin + ` > /dev/null
+		if [ $? -ne 0 ]
+		then
+			echo "NO"
+		else
+			echo "Yes"
+		fi
+`)
+	}
+
+	noPassword := capture.PrivilegeConfig{Method: privilege.Method}
+	if cmd, err := noPassword.WrapCommand("tcpdump --version"); err == nil {
+		checks.WriteString(`
+		printf "Check if tcpdump can be run with ` + name + `, without password: "
+		` + cmd + ` > /dev/null 2>&1
 		if [ $? -ne 0 ]
 		then
 			echo "NO"
 		else
 			echo "Yes"
 		fi
+`)
+	}
 
-		printf "Check if sudo is installed: "
-		SUDO_BIN=$(command -v sudo)
+	if privilege.Password != nil {
+		withPassword := capture.PrivilegeConfig{Method: privilege.Method, Password: privilege.Password}
+		if cmd, err := withPassword.WrapCommand("tcpdump --version"); err == nil {
+			checks.WriteString(`
+		printf "Check if tcpdump can be run with ` + name + `, with the configured password: "
+		` + cmd + ` > /dev/null 2>&1
 		if [ $? -ne 0 ]
 		then
 			echo "NO"
 		else
 			echo "Yes"
 		fi
+`)
+		}
+	}
 
-		printf "Check if tcpdump can be run with sudo, without password: "
-		sudo -n tcpdump --version > /dev/null 2>&1
+	cmd :=
+		`
+	tranqap_permissions() {
+		printf "Check if tcpdump is installed: "
+		TCPDUMP_BIN=$(command -v tcpdump)
 		if [ $? -ne 0 ]
 		then
 			echo "NO"
@@ -49,6 +93,7 @@ func cmdPermissions() string {
 			echo "Yes"
 		fi
 
+` + checks.String() + `
 		printf "Check if tcpdump has got cap_net_admin capabilities: "
 		getcap $TCPDUMP_BIN | grep cap_net_admin > /dev/null
 		if [ $? -ne 0 ]
@@ -85,15 +130,21 @@ func cmdPermissions() string {
 	return cmd
 }
 
-// checkPermissions executes a bash function, which checks if tcpdump can be run on a target machine
-func checkPermissions(trans *SSHClient) (string, error) {
+// checkPermissions executes a bash function, which checks if tcpdump can be run on a target machine.
+// If the privilege escalation password is set, it is checked too.
+func checkPermissions(trans *SSHClient, privilege capture.PrivilegeConfig) (string, error) {
 	if err := trans.Connect(); err != nil {
 		return "", fmt.Errorf("Error connecting: %s", err)
 	}
 
 	out := stdOutWriter{&strings.Builder{}}
 
-	if err := trans.Run(cmdPermissions(), out, out); err != nil {
+	var stdin io.Reader
+	if privilege.Password != nil {
+		stdin = strings.NewReader(*privilege.Password + "\n")
+	}
+
+	if err := trans.Run(cmdPermissions(privilege), stdin, out, out); err != nil {
 		return "", fmt.Errorf("Error running permissions command: %s", err)
 	}
 
@@ -0,0 +1,91 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Outputs, which can be attached to a capture when it is started
+const (
+	outputFile      = "file"
+	outputWireshark = "wireshark"
+)
+
+var outputNames = []string{outputFile, outputWireshark}
+
+// profileParams are the parameters, which can be set in a profile
+var profileParams = map[string]bool{
+	"capture_filter":      true,
+	"snaplen":             true,
+	"interfaces":          true,
+	"file_rotation_count": true,
+	"outputs":             true,
+	"wireshark_profile":   true,
+	"wireshark_filter":    true,
+	"wireshark_options":   true,
+}
+
+func hasString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getOutputs returns the outputs of the target. Captures are saved to files,
+// unless outputs are set explicitly.
+func getOutputs(t target) []string {
+	if t.Outputs == nil {
+		return []string{outputFile}
+	}
+
+	return t.Outputs
+}
+
+// validateProfile returns an error if a parameter, which is not in profileParams,
+// is set in the profile
+func validateProfile(name string, p target) error {
+	v := reflect.ValueOf(p)
+	for i := 0; i < v.NumField(); i++ {
+		key := yamlFieldName(v.Type().Field(i))
+		if !v.Field(i).IsNil() && !profileParams[key] {
+			return fmt.Errorf("%s can't be set in profile %s", key, name)
+		}
+	}
+
+	return nil
+}
+
+// getProfilesList returns the names of all profiles in alphabetical order
+func (cp *configParams) getProfilesList() []string {
+	ret := make([]string, 0, len(cp.Profiles))
+	for name := range cp.Profiles {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// applyProfile returns a copy of the target with the parameters from the profile.
+// Unlike groups and defaults, the profile overrides the values set in the target.
+func (cp *configParams) applyProfile(t target, name string) (target, error) {
+	p, ok := cp.Profiles[name]
+	if !ok {
+		return t, fmt.Errorf("Unknown profile %s", name)
+	}
+
+	var ret target
+	mergeTarget(&ret, p)
+	mergeTarget(&ret, t)
+
+	return ret, nil
+}
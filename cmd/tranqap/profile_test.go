@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var profilesConfig = `profiles:
+  sip:
+    capture_filter: udp port 5060
+    snaplen: 0
+    file_rotation_count: 50
+    outputs: [file, wireshark]
+  headers:
+    snaplen: 96
+    interfaces: [eth0]
+targets:
+- name: t1
+  host: 10.0.0.1
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: t1
+  snaplen: 1500
+  file_rotation_count: 5`
+
+func TestApplyProfile(t *testing.T) {
+	conf, err := parseConfig([]byte(profilesConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	if !reflect.DeepEqual(conf.getProfilesList(), []string{"headers", "sip"}) {
+		t.Errorf("Bad profiles list: %v", conf.getProfilesList())
+	}
+
+	tgt := conf.Targets[0]
+	sip, err := conf.applyProfile(tgt, "sip")
+	if err != nil {
+		t.Fatalf("Error applying profile: %s", err)
+	}
+
+	if *sip.CaptureFilter != "udp port 5060" || *sip.Snaplen != 0 || *sip.RotationCnt != 50 {
+		t.Errorf("Bad values from profile: %s %d %d", *sip.CaptureFilter, *sip.Snaplen, *sip.RotationCnt)
+	}
+	if !reflect.DeepEqual(getOutputs(sip), []string{outputFile, outputWireshark}) {
+		t.Errorf("Bad outputs: %v", getOutputs(sip))
+	}
+	if *sip.Host != "10.0.0.1" {
+		t.Errorf("Bad host: %s", *sip.Host)
+	}
+
+	// The configuration itself is not changed
+	if *tgt.Snaplen != 1500 || *tgt.RotationCnt != 5 || tgt.CaptureFilter != nil {
+		t.Errorf("Target is changed by the profile")
+	}
+	if !reflect.DeepEqual(getOutputs(tgt), []string{outputFile}) {
+		t.Errorf("Bad default outputs: %v", getOutputs(tgt))
+	}
+
+	if _, err := conf.applyProfile(tgt, "unknown"); err == nil {
+		t.Errorf("Expected error for unknown profile")
+	}
+}
+
+func TestBadProfile(t *testing.T) {
+	conf := `profiles:
+  bad:
+    host: 10.0.0.1
+targets:
+- name: t1`
+
+	if _, err := parseConfig([]byte(conf), "."); err == nil {
+		t.Errorf("Expected error for host in profile")
+	}
+}
+
+func TestParseCommandOption(t *testing.T) {
+	profile, rest, err := parseCommandOption([]string{"t1", "--profile", "sip", "@web"}, "--profile")
+	if err != nil || profile != "sip" || !reflect.DeepEqual(rest, []string{"t1", "@web"}) {
+		t.Errorf("Bad result: %s %v %v", profile, rest, err)
+	}
+
+	profile, rest, err = parseCommandOption([]string{"--profile=sip"}, "--profile")
+	if err != nil || profile != "sip" || len(rest) != 0 {
+		t.Errorf("Bad result: %s %v %v", profile, rest, err)
+	}
+
+	if _, _, err := parseCommandOption([]string{"--profile"}, "--profile"); err == nil {
+		t.Errorf("Expected error for missing profile name")
+	}
+}
@@ -0,0 +1,244 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// loadedConfig holds the configuration, used by the shell commands. It is
+// replaced on reload, so the commands should always get it with get().
+type loadedConfig struct {
+	mut      sync.Mutex
+	fname    string
+	conf     configParams
+	modTimes map[string]time.Time
+}
+
+// configDiff contains the differences between two configurations.
+// changed maps target name to the names of the changed parameters.
+type configDiff struct {
+	added   []string
+	removed []string
+	changed map[string][]string
+}
+
+func newLoadedConfig(fname string) (*loadedConfig, error) {
+	conf, err := readConfigFromFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loadedConfig{fname: fname, conf: conf, modTimes: fileModTimes(conf.files)}, nil
+}
+
+// fileModTimes returns the modification time of each file. Files which can't
+// be accessed are skipped.
+func fileModTimes(files []string) map[string]time.Time {
+	ret := make(map[string]time.Time)
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			ret[f] = fi.ModTime()
+		}
+	}
+
+	return ret
+}
+
+// get returns the current configuration
+func (lc *loadedConfig) get() configParams {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	return lc.conf
+}
+
+// reload parses the config file again and replaces the current configuration.
+// On error the current configuration is kept.
+func (lc *loadedConfig) reload() (configDiff, error) {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	// On error the modification times of the old files are updated, so that
+	// the watcher doesn't try to reload the same content again
+	lc.modTimes = fileModTimes(lc.conf.files)
+
+	conf, err := readConfigFromFile(lc.fname)
+	if err != nil {
+		return configDiff{}, err
+	}
+
+	diff := diffConfigs(lc.conf, conf)
+	lc.conf = conf
+	lc.modTimes = fileModTimes(conf.files)
+
+	return diff, nil
+}
+
+// modified returns true if the config file or any of the included files is
+// modified since the last (re)load
+func (lc *loadedConfig) modified() bool {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	return !reflect.DeepEqual(fileModTimes(lc.conf.files), lc.modTimes)
+}
+
+// watch reloads the configuration each time the config file or an included file
+// is modified, until stop is closed. The files are polled on each interval.
+func (lc *loadedConfig) watch(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !lc.modified() {
+			continue
+		}
+
+		tqlog.Info("Config file %s is modified. Reloading.", lc.fname)
+		diff, err := lc.reload()
+		if err != nil {
+			tqlog.Feedback("Config file %s is modified, but can't be loaded: %s\n", lc.fname, err)
+			continue
+		}
+
+		tqlog.Feedback("Config file %s is reloaded.\n%s", lc.fname, applyConfigDiff(diff))
+	}
+}
+
+// diffConfigs compares the targets of two configurations by name
+func diffConfigs(oldConf configParams, newConf configParams) configDiff {
+	ret := configDiff{changed: make(map[string][]string)}
+
+	oldTargets := make(map[string]target)
+	for _, t := range oldConf.Targets {
+		oldTargets[*t.Name] = t
+	}
+
+	newNames := make(map[string]struct{})
+	for _, t := range newConf.Targets {
+		newNames[*t.Name] = struct{}{}
+
+		old, exists := oldTargets[*t.Name]
+		if !exists {
+			ret.added = append(ret.added, *t.Name)
+			continue
+		}
+
+		if fields := diffTargets(old, t); len(fields) > 0 {
+			ret.changed[*t.Name] = fields
+		}
+	}
+
+	for _, t := range oldConf.Targets {
+		if _, exists := newNames[*t.Name]; !exists {
+			ret.removed = append(ret.removed, *t.Name)
+		}
+	}
+
+	return ret
+}
+
+// diffTargets returns the YAML names of the parameters, which differ in a and b
+func diffTargets(a target, b target) []string {
+	ret := make([]string, 0)
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+
+	for i := 0; i < av.NumField(); i++ {
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			ret = append(ret, yamlFieldName(av.Type().Field(i)))
+		}
+	}
+
+	return ret
+}
+
+// yamlFieldName returns the key of a struct field in the YAML file
+func yamlFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if len(name) == 0 {
+		return strings.ToLower(f.Name)
+	}
+
+	return name
+}
+
+// empty returns true if there are no differences
+func (d configDiff) empty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+// applyConfigDiff stops the captures of the removed targets and returns a
+// human readable description of the differences. Captures of the changed
+// targets keep running with the previous configuration. Captures of the
+// unchanged targets are not touched.
+func applyConfigDiff(d configDiff) string {
+	if d.empty() {
+		return "No changes in targets.\n"
+	}
+
+	var out strings.Builder
+
+	for _, name := range d.added {
+		fmt.Fprintf(&out, "+ %s\n", name)
+	}
+
+	stop := make([]string, 0)
+	for _, name := range d.removed {
+		fmt.Fprintf(&out, "- %s", name)
+		if capturers.Running(name) {
+			stop = append(stop, name)
+			out.WriteString(" (capture stopped)")
+		}
+		out.WriteString("\n")
+	}
+
+	changed := make([]string, 0, len(d.changed))
+	for name := range d.changed {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	for _, name := range changed {
+		fmt.Fprintf(&out, "~ %s: %s", name, strings.Join(d.changed[name], ", "))
+		if capturers.Running(name) {
+			out.WriteString(" (capture is running with the previous configuration. Stop and start it to apply the changes)")
+		}
+		out.WriteString("\n")
+	}
+
+	if len(stop) > 0 {
+		capturers.Stop(stop)
+	}
+
+	return out.String()
+}
+
+func cmdReload(cio commandIO, lc *loadedConfig) {
+	tqlog.Info("Called reload command")
+
+	diff, err := lc.reload()
+	if err != nil {
+		cio.Printf("Error reloading configuration. The previous configuration is kept: %s\n", err)
+		return
+	}
+
+	cio.Printf("%s", applyConfigDiff(diff))
+}
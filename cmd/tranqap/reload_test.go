@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var reloadOldConfig = `targets:
+- name: same
+  host: 10.0.0.1
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: same
+- name: changed
+  host: 10.0.0.2
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: changed
+- name: removed
+  host: 10.0.0.3
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: removed`
+
+var reloadNewConfig = `targets:
+- name: same
+  host: 10.0.0.1
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: same
+- name: changed
+  host: 10.0.0.2
+  port: 2222
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: changed
+  filter_port: 2222
+- name: added
+  host: 10.0.0.4
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: added`
+
+func TestDiffConfigs(t *testing.T) {
+	oldConf, err := parseConfig([]byte(reloadOldConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing old config: %s", err)
+	}
+	newConf, err := parseConfig([]byte(reloadNewConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing new config: %s", err)
+	}
+
+	diff := diffConfigs(oldConf, newConf)
+
+	if !reflect.DeepEqual(diff.added, []string{"added"}) {
+		t.Errorf("Bad added targets: %v", diff.added)
+	}
+	if !reflect.DeepEqual(diff.removed, []string{"removed"}) {
+		t.Errorf("Bad removed targets: %v", diff.removed)
+	}
+	if len(diff.changed) != 1 {
+		t.Fatalf("Expected one changed target. Got %v", diff.changed)
+	}
+	if !reflect.DeepEqual(diff.changed["changed"], []string{"port", "filter_port"}) {
+		t.Errorf("Bad changed parameters: %v", diff.changed["changed"])
+	}
+
+	if diff := diffConfigs(oldConf, oldConf); !diff.empty() {
+		t.Errorf("Expected no differences. Got %v", diff)
+	}
+}
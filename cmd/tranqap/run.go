@@ -0,0 +1,206 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+const (
+	runStatusStopped = "stopped"
+	runStatusDied    = "died"
+	runStatusFailed  = "failed"
+)
+
+// stdIO is commandIO for the non-interactive modes. Messages are printed to
+// stderr, so that stdout contains only the result of the command.
+type stdIO struct{}
+
+func (stdIO) Printf(format string, val ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, val...)
+}
+
+func (stdIO) Println(val ...interface{}) {
+	fmt.Fprintln(os.Stderr, val...)
+}
+
+func (stdIO) ReadPasswordErr() (string, error) {
+	return "", errNoPasswordInput
+}
+
+// runTargetSummary is the result of the capture for a single target
+type runTargetSummary struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Destination string `json:"destination,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runSummary is printed on stdout as JSON when run subcommand finishes
+type runSummary struct {
+	Duration   float64            `json:"duration_seconds"`
+	StopReason string             `json:"stop_reason"`
+	Targets    []runTargetSummary `json:"targets"`
+}
+
+// newRunSummary creates the summary of a run. startErrors contains the targets
+// which failed to start and died - the capturers which stopped unexpectedly.
+func newRunSummary(targets []target, startErrors map[string]error, died []string, elapsed time.Duration, reason string) runSummary {
+	ret := runSummary{elapsed.Seconds(), reason, make([]runTargetSummary, 0, len(targets))}
+
+	for _, t := range targets {
+		s := runTargetSummary{Name: *t.Name, Status: runStatusStopped}
+		if t.Destination != nil {
+			s.Destination = *t.Destination
+		}
+
+		if err, failed := startErrors[*t.Name]; failed {
+			s.Status = runStatusFailed
+			s.Error = err.Error()
+		} else if hasString(died, *t.Name) {
+			s.Status = runStatusDied
+		}
+
+		ret.Targets = append(ret.Targets, s)
+	}
+
+	return ret
+}
+
+// exitCode returns 0 if all captures were stopped as expected and 1 otherwise
+func (s runSummary) exitCode() int {
+	for _, t := range s.Targets {
+		if t.Status != runStatusStopped {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// cmdRun implements run subcommand - starts the captures without the shell, waits
+// for the duration or a signal and stops them. Returns the exit code of the program.
+func cmdRun(configFile string, logFile string, metricsAddr string, args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	targetsArg := fs.String("targets", "", "comma separated list of targets and @tags to capture on (default all)")
+	duration := fs.Duration("duration", 0, "stop the captures after this duration (default wait for SIGINT or SIGTERM)")
+	profile := fs.String("profile", "", "capture profile to apply")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: tranqap run [--targets a,b] [--duration 60s] [--profile name]\n")
+		return 1
+	}
+
+	cfg, err := readConfigFromFile(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	selectors := make([]string, 0)
+	for _, s := range strings.Split(*targetsArg, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			selectors = append(selectors, s)
+		}
+	}
+
+	targets, err := cfg.selectTargets(selectors)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printCb := func(f string, a ...interface{}) { fmt.Fprintf(os.Stderr, f, a...) }
+	if err := tqlog.Init(logFile, printCb); err != nil {
+		fmt.Fprintf(os.Stderr, "Error initialising logger: %s\nLog file won't be generated\n", err)
+	}
+
+	initStorage()
+
+	if len(metricsAddr) > 0 {
+		names := make([]string, 0, len(targets))
+		for _, t := range targets {
+			names = append(names, *t.Name)
+		}
+		metrics := newMetricsServer(func() []string { return names })
+		if err := metrics.start(metricsAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting metrics listener: %s\n", err)
+			return 1
+		}
+		defer metrics.close()
+	}
+
+	// Catch the signals before the captures are started, so that they are
+	// always stopped cleanly
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	started := time.Now()
+	startErrors := make(map[string]error)
+	for _, t := range targets {
+		if err := startCapture(stdIO{}, cfg, t, *profile, ""); err != nil {
+			tqlog.Error("Can't start capture for %s: %s", *t.Name, err)
+			fmt.Fprintln(os.Stderr, err)
+			startErrors[*t.Name] = err
+		}
+	}
+
+	reason := "all captures stopped"
+	if !capturers.Empty() {
+		reason = waitRun(sigs, *duration)
+	}
+
+	capturers.Close()
+
+	summary := newRunSummary(targets, startErrors, capturers.Died(), time.Since(started), reason)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(summary); err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing summary: %s\n", err)
+		return 1
+	}
+
+	return summary.exitCode()
+}
+
+// waitRun waits for a signal, for the duration to elapse or for all captures to
+// stop. Zero duration means no limit. Returns the reason to stop.
+func waitRun(sigs chan os.Signal, duration time.Duration) string {
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case s := <-sigs:
+			fmt.Fprintf(os.Stderr, "Got %s. Stopping the captures\n", s)
+			return "signal " + s.String()
+		case <-timeout:
+			return "duration elapsed"
+		case <-ticker.C:
+			if capturers.Empty() {
+				return "all captures stopped"
+			}
+		}
+	}
+}
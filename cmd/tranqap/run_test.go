@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRunSummary(t *testing.T) {
+	conf, err := parseConfig([]byte(`targets:
+- name: t1
+  host: 10.0.0.1
+  destination: pcaps/t1
+- name: t2
+  host: 10.0.0.2
+- name: t3
+  host: 10.0.0.3
+`), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	startErrors := map[string]error{"t3": fmt.Errorf("Error connecting to t3")}
+	summary := newRunSummary(conf.Targets, startErrors, []string{"t2"}, 2*time.Second, "duration elapsed")
+
+	expected := []runTargetSummary{
+		{"t1", runStatusStopped, "pcaps/t1", ""},
+		{"t2", runStatusDied, "", ""},
+		{"t3", runStatusFailed, "", "Error connecting to t3"},
+	}
+	if len(summary.Targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d", len(expected), len(summary.Targets))
+	}
+	for i := range expected {
+		if summary.Targets[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], summary.Targets[i])
+		}
+	}
+
+	if summary.Duration != 2 {
+		t.Errorf("Expected duration 2, got %f", summary.Duration)
+	}
+
+	if summary.exitCode() != 1 {
+		t.Errorf("Expected exit code 1, got %d", summary.exitCode())
+	}
+
+	ok := newRunSummary(conf.Targets[:1], nil, nil, time.Second, "signal interrupt")
+	if ok.exitCode() != 0 {
+		t.Errorf("Expected exit code 0, got %d", ok.exitCode())
+	}
+}
@@ -8,20 +8,53 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // SSHClient wraps crypto/ssh library. Destination is set during initialisation
 type SSHClient struct {
+	dest      string
+	config    ssh.ClientConfig
+	jumps     []sshJump
+	hops      []*ssh.Client // clients of the jump hosts, in connection order
+	client    *ssh.Client
+	keepAlive sshKeepAlive
+}
+
+// sshKeepAlive contains the keepalive settings of the SSHClient.
+// interval is the period between two keepalive requests. Zero disables keepalives.
+// countMax is the number of unanswered requests, after which the connection is closed.
+// stop is closed when the connection is closed by Close().
+type sshKeepAlive struct {
+	interval time.Duration
+	countMax int
+	stop     chan struct{}
+}
+
+// sshJump is an intermediate host (ProxyJump), through which the connection
+// to the destination is established
+type sshJump struct {
 	dest   string
 	config ssh.ClientConfig
-	client *ssh.Client
 }
 
-// NewSSHClient creates new sshClient instance
-func NewSSHClient(dest string, config ssh.ClientConfig) *SSHClient {
-	return &SSHClient{dest, config, nil}
+// NewSSHClient creates new sshClient instance. If jump hosts are passed, the
+// connection to dest is tunneled through them, in the order they are passed.
+func NewSSHClient(dest string, config ssh.ClientConfig, jumps ...sshJump) *SSHClient {
+	return &SSHClient{dest, config, jumps, nil, nil, sshKeepAlive{}}
+}
+
+// SetKeepAlive enables sending keepalive requests over the connection. If countMax
+// consecutive requests are not answered, the connection is closed and all running
+// commands return with an error. Should be called before Connect().
+func (c *SSHClient) SetKeepAlive(interval time.Duration, countMax int) {
+	c.keepAlive.interval = interval
+	c.keepAlive.countMax = countMax
 }
 
 // IsActive returns true if there is an initialised SSH client
@@ -32,16 +65,131 @@ func (c *SSHClient) IsActive() bool {
 // Connect initialises connection to the destination
 func (c *SSHClient) Connect() error {
 	var err error
-	c.client, err = ssh.Dial("tcp", c.dest, &c.config)
+
+	if len(c.jumps) == 0 {
+		c.client, err = ssh.Dial("tcp", c.dest, &c.config)
+		if err != nil {
+			return err
+		}
+
+		c.startKeepAlive()
+		return nil
+	}
+
+	client, err := ssh.Dial("tcp", c.jumps[0].dest, &c.jumps[0].config)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error connecting to jump host %s: %s", c.jumps[0].dest, err)
+	}
+
+	// The clients of the jump hosts are kept open while the connection is used
+	// and are closed in reverse order by Close()
+	hopClients := []*ssh.Client{client}
+	hops := append(append([]sshJump{}, c.jumps[1:]...), sshJump{c.dest, c.config})
+	for _, h := range hops {
+		conn, err := client.Dial("tcp", h.dest)
+		if err != nil {
+			closeClients(hopClients)
+			return fmt.Errorf("Error tunneling to %s: %s", h.dest, err)
+		}
+
+		clientConn, chans, reqs, err := ssh.NewClientConn(conn, h.dest, &h.config)
+		if err != nil {
+			conn.Close()
+			closeClients(hopClients)
+			return fmt.Errorf("Error connecting to %s: %s", h.dest, err)
+		}
+
+		client = ssh.NewClient(clientConn, chans, reqs)
+		hopClients = append(hopClients, client)
 	}
 
+	c.client = client
+	c.hops = hopClients[:len(hopClients)-1]
+	c.startKeepAlive()
 	return nil
 }
 
-// Run executes shell command synchronously
-func (c *SSHClient) Run(cmd string, stdout io.Writer, stderr io.Writer) error {
+// Close terminates the connection. Running commands return with an error.
+func (c *SSHClient) Close() error {
+	if c.client == nil {
+		return nil
+	}
+
+	if c.keepAlive.stop != nil {
+		close(c.keepAlive.stop)
+		c.keepAlive.stop = nil
+	}
+
+	err := c.client.Close()
+	closeClients(c.hops)
+	c.hops = nil
+
+	return err
+}
+
+// closeClients closes the clients in reverse order, so that each tunneled
+// connection is closed before the jump host it goes through
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
+
+func (c *SSHClient) startKeepAlive() {
+	if c.keepAlive.interval <= 0 {
+		return
+	}
+
+	c.keepAlive.stop = make(chan struct{})
+	go keepAliveLoop(c.client, c.keepAlive.interval, c.keepAlive.countMax, c.keepAlive.stop)
+}
+
+// keepAliveLoop sends keepalive@openssh.com requests to the server on each interval.
+// A reply of any kind (even failure) means that the server is alive. When the network
+// path is silently dropped the request blocks, so a request which is not answered
+// within the interval is counted as missed.
+func keepAliveLoop(client *ssh.Client, interval time.Duration, countMax int, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-stop:
+			return
+		case err := <-reply:
+			if err != nil {
+				tqlog.Info("Keepalive request to %s failed: %s", client.RemoteAddr(), err)
+				return
+			}
+			missed = 0
+		case <-time.After(interval):
+			missed++
+			tqlog.Info("Keepalive to %s not answered (%d/%d)", client.RemoteAddr(), missed, countMax)
+		}
+
+		if missed >= countMax {
+			tqlog.Error("Connection to %s timed out. Closing it.", client.RemoteAddr())
+			client.Close()
+			return
+		}
+	}
+}
+
+// Run executes shell command synchronously. stdin can be nil.
+func (c *SSHClient) Run(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	session, err := c.client.NewSession()
 	if err != nil {
 		return fmt.Errorf("Error creating session: %s", err)
@@ -49,6 +197,9 @@ func (c *SSHClient) Run(cmd string, stdout io.Writer, stderr io.Writer) error {
 
 	defer session.Close()
 
+	if stdin != nil {
+		session.Stdin = stdin
+	}
 	session.Stdout = stdout
 	session.Stderr = stderr
 
@@ -82,6 +233,21 @@ func (c *SSHClient) GetRemotePort() *int {
 		return nil
 	}
 
+	if len(c.jumps) > 0 {
+		// Tunneled connections don't have got a local port. The session is seen
+		// by the target as coming from the last jump host, so filter out the
+		// SSH port of the target instead.
+		_, portStr, err := net.SplitHostPort(c.dest)
+		if err != nil {
+			return nil
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil
+		}
+		return &port
+	}
+
 	if addr := c.client.RemoteAddr(); addr != nil {
 		return &c.client.LocalAddr().(*net.TCPAddr).Port
 	}
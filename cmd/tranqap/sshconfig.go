@@ -0,0 +1,336 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// defaultSSHConfig is the OpenSSH client configuration, used when the target
+// doesn't set ssh_config explicitly
+const defaultSSHConfig = "~/.ssh/config"
+
+// sshConfigPath is the default OpenSSH client configuration. Empty value means
+// that it is not used. The tests clear it, so that they don't depend on the
+// configuration of the user running them.
+var sshConfigPath = defaultSSHConfig
+
+// sshConfigHost represents a single Host block from OpenSSH client configuration.
+// Options are saved with lowercased keywords, because OpenSSH keywords are case insensitive.
+type sshConfigHost struct {
+	patterns []string
+	options  map[string][]string
+}
+
+// sshConfig is a minimal parser for OpenSSH client configuration files (ssh_config(5)).
+// Only Host blocks and Include directives are supported. Match blocks are ignored.
+type sshConfig struct {
+	hosts []sshConfigHost
+}
+
+// sshConfigLine is a single keyword/arguments pair from the config file
+type sshConfigLine struct {
+	keyword string
+	args    []string
+}
+
+// readSSHConfig loads OpenSSH client configuration from fname. The error
+// from the initial read is returned unwrapped, so that the caller can check
+// it with os.IsNotExist().
+func readSSHConfig(fname string) (*sshConfig, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSSHConfig(data, filepath.Dir(fname))
+}
+
+// parseSSHConfig parses the content of OpenSSH client config. baseDir is used
+// to resolve relative paths in Include directives.
+func parseSSHConfig(data []byte, baseDir string) (*sshConfig, error) {
+	lines, err := readSSHConfigLines(data, baseDir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Options before the first Host block apply to all hosts
+	current := &sshConfigHost{[]string{"*"}, make(map[string][]string)}
+	ret := &sshConfig{}
+
+	for _, l := range lines {
+		switch l.keyword {
+		case "host":
+			ret.hosts = append(ret.hosts, *current)
+			current = &sshConfigHost{l.args, make(map[string][]string)}
+		case "match":
+			// Match criteria are not supported. Use a pattern which matches nothing,
+			// so that the options in the block are skipped.
+			ret.hosts = append(ret.hosts, *current)
+			current = &sshConfigHost{nil, make(map[string][]string)}
+		default:
+			if _, exists := current.options[l.keyword]; !exists {
+				current.options[l.keyword] = l.args
+			}
+		}
+	}
+	ret.hosts = append(ret.hosts, *current)
+
+	return ret, nil
+}
+
+// readSSHConfigLines splits the config to keyword/arguments pairs and inlines
+// the content of the included files
+func readSSHConfigLines(data []byte, baseDir string, depth int) ([]sshConfigLine, error) {
+	// Same limit as OpenSSH
+	const maxIncludeDepth = 16
+
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("Include nesting is too deep")
+	}
+
+	ret := make([]sshConfigLine, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Keyword and arguments can be separated with whitespace or with '='
+		sep := strings.IndexAny(line, " \t=")
+		if sep == -1 {
+			return nil, fmt.Errorf("line %d: missing argument for %s", lineNo, line)
+		}
+		keyword := strings.ToLower(line[:sep])
+		rest := strings.TrimLeft(line[sep:], " \t")
+		rest = strings.TrimPrefix(rest, "=")
+		args := splitSSHConfigArgs(rest)
+
+		if len(args) == 0 {
+			return nil, fmt.Errorf("line %d: missing argument for %s", lineNo, keyword)
+		}
+
+		if keyword != "include" {
+			ret = append(ret, sshConfigLine{keyword, args})
+			continue
+		}
+
+		for _, pattern := range args {
+			pattern = expandHome(pattern)
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(baseDir, pattern)
+			}
+
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad Include pattern %s: %s", lineNo, pattern, err)
+			}
+
+			for _, m := range matches {
+				incData, err := ioutil.ReadFile(m)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: can't read included file: %s", lineNo, err)
+				}
+
+				incLines, err := readSSHConfigLines(incData, baseDir, depth+1)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", m, err)
+				}
+
+				ret = append(ret, incLines...)
+			}
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+// splitSSHConfigArgs splits the arguments of a config line by whitespace.
+// Double quoted arguments can contain whitespace.
+func splitSSHConfigArgs(s string) []string {
+	ret := make([]string, 0)
+	var curr strings.Builder
+	inQuotes := false
+	hasArg := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				ret = append(ret, curr.String())
+				curr.Reset()
+				hasArg = false
+			}
+		default:
+			curr.WriteRune(r)
+			hasArg = true
+		}
+	}
+
+	if hasArg {
+		ret = append(ret, curr.String())
+	}
+
+	return ret
+}
+
+// get returns the arguments of the first occurrence of keyword for alias.
+// As in OpenSSH, the first obtained value wins. Returns nil if the keyword is not set.
+func (sc *sshConfig) get(alias string, keyword string) []string {
+	keyword = strings.ToLower(keyword)
+
+	for _, h := range sc.hosts {
+		if !matchHostPatterns(alias, h.patterns) {
+			continue
+		}
+
+		if args, ok := h.options[keyword]; ok {
+			return args
+		}
+	}
+
+	return nil
+}
+
+// getString returns the first argument for keyword or nil if it is not set
+func (sc *sshConfig) getString(alias string, keyword string) *string {
+	args := sc.get(alias, keyword)
+	if len(args) == 0 {
+		return nil
+	}
+
+	ret := args[0]
+	return &ret
+}
+
+// matchHostPatterns checks alias against the patterns of a Host line.
+// A negated match (!pattern) takes precedence over everything else.
+func matchHostPatterns(alias string, patterns []string) bool {
+	matched := false
+
+	for _, p := range patterns {
+		// Patterns can be comma separated too
+		for _, sub := range strings.Split(p, ",") {
+			if strings.HasPrefix(sub, "!") {
+				if matchWildcard(strings.ToLower(alias), strings.ToLower(sub[1:])) {
+					return false
+				}
+				continue
+			}
+
+			if matchWildcard(strings.ToLower(alias), strings.ToLower(sub)) {
+				matched = true
+			}
+		}
+	}
+
+	return matched
+}
+
+// matchWildcard matches s against a pattern with '*' and '?' wildcards
+func matchWildcard(s string, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if matchWildcard(s[i:], pattern[1:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s = s[1:]
+		pattern = pattern[1:]
+	}
+
+	return len(s) == 0
+}
+
+// expandSSHTokens replaces the tokens supported by tranqap in path-like values:
+// ~ - home dir of the local user (only as a prefix)
+// %d - home dir of the local user
+// %h - remote hostname
+// %r - remote username
+// %u - local username
+// %% - literal %
+func expandSSHTokens(s string, host string, remoteUser string) string {
+	s = expandHome(s)
+
+	var ret strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			ret.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'd':
+			ret.WriteString(homeDir())
+		case 'h':
+			ret.WriteString(host)
+		case 'r':
+			ret.WriteString(remoteUser)
+		case 'u':
+			if u, err := user.Current(); err == nil {
+				ret.WriteString(u.Username)
+			}
+		case '%':
+			ret.WriteByte('%')
+		default:
+			ret.WriteByte('%')
+			ret.WriteByte(s[i])
+		}
+	}
+
+	return ret.String()
+}
+
+// expandHome replaces leading ~ with the home dir of the current user
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir()
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir(), path[2:])
+	}
+
+	return path
+}
+
+func homeDir() string {
+	if home := os.Getenv("HOME"); len(home) > 0 {
+		return home
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+
+	return ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var sampleSSHConfig = `
+# Global options
+User defaultuser
+
+Host lab-*
+    HostName %h.example.com
+    User = labuser
+    Port 2222
+    IdentityFile ~/.ssh/lab_key
+
+Host db1 !db2 db*
+    HostName 10.0.0.5
+    ProxyJump bastion
+
+Host *
+    User fallback
+    Port 22
+`
+
+func TestSSHConfigMatch(t *testing.T) {
+	sc, err := parseSSHConfig([]byte(sampleSSHConfig), "/tmp")
+	if err != nil {
+		t.Fatalf("Error parsing ssh config: %s", err)
+	}
+
+	if v := sc.getString("lab-1", "hostname"); v == nil || *v != "%h.example.com" {
+		t.Errorf("Bad HostName for lab-1: %v", v)
+	}
+
+	// First obtained value wins, options before the first Host apply to all hosts
+	if v := sc.getString("lab-1", "User"); v == nil || *v != "defaultuser" {
+		t.Errorf("Bad User for lab-1: %v", v)
+	}
+
+	if v := sc.getString("db1", "ProxyJump"); v == nil || *v != "bastion" {
+		t.Errorf("Bad ProxyJump for db1: %v", v)
+	}
+
+	// db2 is negated
+	if v := sc.getString("db2", "HostName"); v != nil {
+		t.Errorf("db2 should not match. Got HostName %s", *v)
+	}
+
+	if v := sc.getString("unknown", "Port"); v == nil || *v != "22" {
+		t.Errorf("Bad Port for unknown: %v", v)
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	sc, err := parseSSHConfig([]byte("Host lab-*\n HostName %h.example.com\n User labuser\n Port 2222\n IdentityFile /keys/%r\n ProxyJump jump@bastion:2200\n"), "/tmp")
+	if err != nil {
+		t.Fatalf("Error parsing ssh config: %s", err)
+	}
+
+	name := "lab target"
+	host := "lab-1"
+	tgt := target{Name: &name, Host: &host}
+
+	if err := tgt.applySSHConfig(sc); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if *tgt.Host != "lab-1.example.com" {
+		t.Errorf("Bad host: %s", *tgt.Host)
+	}
+	if tgt.User == nil || *tgt.User != "labuser" {
+		t.Errorf("Bad user: %v", tgt.User)
+	}
+	if tgt.Port == nil || *tgt.Port != 2222 {
+		t.Errorf("Bad port: %v", tgt.Port)
+	}
+	if tgt.Key == nil || *tgt.Key != "/keys/labuser" {
+		t.Errorf("Bad key: %v", tgt.Key)
+	}
+	if tgt.ProxyJump == nil || *tgt.ProxyJump != "jump@bastion:2200" {
+		t.Errorf("Bad proxy jump: %v", tgt.ProxyJump)
+	}
+
+	// Values from tranqap's config take precedence
+	user := "capture"
+	port := 22
+	tgt = target{Name: &name, Host: &host, User: &user, Port: &port}
+	tgt.applySSHConfig(sc)
+
+	if *tgt.User != "capture" || *tgt.Port != 22 {
+		t.Errorf("Explicit values are overridden: %s %d", *tgt.User, *tgt.Port)
+	}
+}
+
+func TestApplySSHConfigAliasAsHost(t *testing.T) {
+	sc, err := parseSSHConfig([]byte("Host router\n User admin\n"), "/tmp")
+	if err != nil {
+		t.Fatalf("Error parsing ssh config: %s", err)
+	}
+
+	name := "router"
+	tgt := target{Name: &name}
+	if err := tgt.applySSHConfig(sc); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if tgt.Host == nil || *tgt.Host != "router" {
+		t.Errorf("Expected the alias to be used as host, got %v", tgt.Host)
+	}
+	if tgt.User == nil || *tgt.User != "admin" {
+		t.Errorf("Bad user: %v", tgt.User)
+	}
+}
+
+func TestGetJumpHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tranqap-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "ssh_config")
+	sshConfig := "Host bastion\n HostName 10.0.0.1\n User jumper\n Port 2200\nHost *\n Port 2222\n"
+	if err := ioutil.WriteFile(fname, []byte(sshConfig), 0600); err != nil {
+		t.Fatalf("Error writing ssh config: %s", err)
+	}
+
+	name := "target"
+	port := 22
+	jumps := "bastion, second@inner:2022, plain"
+	tgt := target{Name: &name, Port: &port, SSHConfig: &fname, ProxyJump: &jumps}
+
+	hops, err := getJumpHosts(&tgt, ssh.ClientConfig{User: "capture"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Each hop uses its own port, not the port of the target
+	expected := []struct {
+		dest string
+		user string
+	}{
+		{"10.0.0.1:2200", "jumper"},
+		{"inner:2022", "second"},
+		{"plain:2222", "capture"},
+	}
+	if len(hops) != len(expected) {
+		t.Fatalf("Expected %d hops, got %d", len(expected), len(hops))
+	}
+	for i, e := range expected {
+		if hops[i].dest != e.dest || hops[i].config.User != e.user {
+			t.Errorf("Bad hop %d: %s %s", i, hops[i].dest, hops[i].config.User)
+		}
+	}
+}
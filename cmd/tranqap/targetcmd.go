@@ -0,0 +1,431 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+	"gopkg.in/yaml.v2"
+)
+
+// targetPromptFields are the parameters, which target add and target edit
+// prompt for. The rest can be set only by editing the config file.
+var targetPromptFields = []string{
+	"name",
+	"host",
+	"port",
+	"user",
+	"key",
+	"destination",
+	"file_pattern",
+	"file_rotation_count",
+	"privilege",
+	"filter_port",
+	"group",
+	"tags",
+}
+
+// targetField returns the field of t, which corresponds to the YAML key
+func targetField(t *target, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(t).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if yamlFieldName(v.Type().Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// targetFieldString returns the value of a target parameter as text. Returns
+// empty string if the parameter is not set.
+func targetFieldString(f reflect.Value) string {
+	if f.IsNil() {
+		return ""
+	}
+
+	if f.Kind() == reflect.Slice {
+		return strings.Join(f.Interface().([]string), ", ")
+	}
+
+	return fmt.Sprint(f.Elem().Interface())
+}
+
+// setTargetField parses input and sets the target parameter to it. '-' unsets
+// the parameter. Lists are separated with commas.
+func setTargetField(t *target, key string, input string) error {
+	f, ok := targetField(t, key)
+	if !ok {
+		return fmt.Errorf("Unknown parameter %s", key)
+	}
+
+	if input == "-" {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+
+	var value interface{}
+
+	switch f.Type() {
+	case reflect.TypeOf((*string)(nil)):
+		value = &input
+	case reflect.TypeOf((*int)(nil)):
+		n, err := strconv.Atoi(input)
+		if err != nil {
+			return fmt.Errorf("%s should be a number", key)
+		}
+		value = &n
+	case reflect.TypeOf((*bool)(nil)):
+		b, err := strconv.ParseBool(input)
+		if err != nil {
+			return fmt.Errorf("%s should be true or false", key)
+		}
+		value = &b
+	case reflect.TypeOf([]string(nil)):
+		list := make([]string, 0)
+		for _, s := range strings.Split(input, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+		value = list
+	default:
+		return fmt.Errorf("Parameter %s can't be set from the shell", key)
+	}
+
+	f.Set(reflect.ValueOf(value))
+	return nil
+}
+
+// targetYAMLValue returns the value of a target parameter, formatted as YAML.
+// Returns false if the parameter is not set.
+func targetYAMLValue(t *target, key string) (string, bool, error) {
+	f, ok := targetField(t, key)
+	if !ok || f.IsNil() {
+		return "", false, nil
+	}
+
+	var value interface{}
+	if f.Kind() == reflect.Slice {
+		value = f.Interface()
+	} else {
+		value = f.Elem().Interface()
+	}
+
+	ret, err := yamlFormatValue(value)
+	return ret, true, err
+}
+
+// promptTarget asks the user for each parameter in targetPromptFields.
+// The current values of t are offered as defaults.
+func promptTarget(ctx *ishell.Context, t *target) error {
+	ctx.ShowPrompt(false)
+	defer ctx.ShowPrompt(true)
+
+	ctx.Println("Press Enter to keep the value in brackets or type - to unset it.")
+
+	for _, key := range targetPromptFields {
+		f, _ := targetField(t, key)
+
+		for {
+			ctx.Printf("%s [%s]: ", key, targetFieldString(f))
+			input, err := ctx.ReadLineErr()
+			if err != nil {
+				return err
+			}
+
+			input = strings.TrimSpace(input)
+			if len(input) == 0 {
+				break
+			}
+
+			if err := setTargetField(t, key, input); err != nil {
+				ctx.Println(err)
+				continue
+			}
+			break
+		}
+	}
+
+	if t.Name == nil || len(*t.Name) == 0 {
+		return fmt.Errorf("Missing name")
+	}
+
+	return nil
+}
+
+// confirm asks a yes/no question. The default answer is no.
+func confirm(ctx *ishell.Context, question string) bool {
+	ctx.ShowPrompt(false)
+	defer ctx.ShowPrompt(true)
+
+	ctx.Printf("%s (y/N): ", question)
+	answer, err := ctx.ReadLineErr()
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// readRawConfig reads the config file without resolving the targets, i.e. each
+// target contains only the values set in the file
+func readRawConfig(fname string) ([]byte, configParams, error) {
+	var conf configParams
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, conf, err
+	}
+
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, conf, err
+	}
+
+	return data, conf, nil
+}
+
+// findRawTarget returns the index of the target in the targets section
+func findRawTarget(conf configParams, name string) (int, error) {
+	for i, t := range conf.Targets {
+		if t.Name != nil && *t.Name == name {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("Target <%s> is not defined in the config file. Targets from included files can't be edited", name)
+}
+
+// rawTargetNames returns the names of the targets, defined in the config file
+func rawTargetNames(fname string) []string {
+	ret := make([]string, 0)
+
+	_, raw, err := readRawConfig(fname)
+	if err != nil {
+		return ret
+	}
+
+	for _, t := range raw.Targets {
+		if t.Name != nil {
+			ret = append(ret, *t.Name)
+		}
+	}
+
+	return ret
+}
+
+// checkEditedTargets validates the targets, generated from the target with the
+// name, and tries to connect to each of them. Returns false if there are problems
+// and the user doesn't want to save anyway.
+func checkEditedTargets(ctx *ishell.Context, conf configParams, name string) bool {
+	ok := true
+
+	for i := range conf.Targets {
+		if conf.sourceNames[conf.sources[i]] != name {
+			continue
+		}
+		t := &conf.Targets[i]
+
+		errs := validateTarget(t)
+		for _, err := range errs {
+			ctx.Println(err)
+		}
+		if len(errs) > 0 {
+			ok = false
+			continue
+		}
+
+		ctx.Printf("Connecting to <%s>... ", *t.Name)
+		sshClient, err := newTargetSSHClient(t)
+		if err == nil {
+			err = sshClient.Connect()
+		}
+		if err != nil {
+			ctx.Printf("failed: %s\n", err)
+			ok = false
+			continue
+		}
+		sshClient.Close()
+		ctx.Println("ok")
+	}
+
+	if ok {
+		return true
+	}
+
+	return confirm(ctx, "There are problems with the target. Save anyway?")
+}
+
+// saveConfig writes the edited configuration to the config file and reloads it.
+// data is checked before it is written and the file is not changed on error.
+func saveConfig(ctx *ishell.Context, lc *loadedConfig, data []byte) {
+	if _, err := parseConfigFile(data, lc.fname); err != nil {
+		ctx.Printf("The change results in a bad configuration and is not saved: %s\n", err)
+		return
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(lc.fname); err == nil {
+		mode = fi.Mode()
+	}
+
+	if err := ioutil.WriteFile(lc.fname, data, mode); err != nil {
+		ctx.Printf("Error saving %s: %s\n", lc.fname, err)
+		return
+	}
+
+	cmdReload(ctx, lc)
+}
+
+func cmdTargetAdd(ctx *ishell.Context, lc *loadedConfig) {
+	tqlog.Info("Called target add command")
+
+	data, _, err := readRawConfig(lc.fname)
+	if err != nil {
+		ctx.Printf("Error reading %s: %s\n", lc.fname, err)
+		return
+	}
+
+	var t target
+	if err := promptTarget(ctx, &t); err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	keys := make([]yamlKeyValue, 0)
+	for _, key := range targetPromptFields {
+		value, set, err := targetYAMLValue(&t, key)
+		if err != nil {
+			ctx.Println(err)
+			return
+		}
+		if set {
+			keys = append(keys, yamlKeyValue{key, value})
+		}
+	}
+
+	newData, err := yamlAddItem(data, "targets", keys)
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	conf, err := parseConfigFile(newData, lc.fname)
+	if err != nil {
+		ctx.Printf("Bad target: %s\n", err)
+		return
+	}
+
+	if !checkEditedTargets(ctx, conf, *t.Name) {
+		return
+	}
+
+	saveConfig(ctx, lc, newData)
+}
+
+func cmdTargetEdit(ctx *ishell.Context, lc *loadedConfig) {
+	tqlog.Info("Called target edit command with args %v", ctx.Args)
+
+	if len(ctx.Args) != 1 {
+		ctx.Println("Usage: target edit <name>")
+		return
+	}
+
+	data, raw, err := readRawConfig(lc.fname)
+	if err != nil {
+		ctx.Printf("Error reading %s: %s\n", lc.fname, err)
+		return
+	}
+
+	idx, err := findRawTarget(raw, ctx.Args[0])
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	old := raw.Targets[idx]
+	var t target
+	mergeTarget(&t, old)
+	if err := promptTarget(ctx, &t); err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	newData := data
+	for _, key := range targetPromptFields {
+		oldValue, _, _ := targetYAMLValue(&old, key)
+		value, set, err := targetYAMLValue(&t, key)
+		if err != nil {
+			ctx.Println(err)
+			return
+		}
+		if value == oldValue {
+			continue
+		}
+
+		if set {
+			newData, err = yamlSetKey(newData, "targets", idx, key, value)
+		} else {
+			newData, err = yamlRemoveKey(newData, "targets", idx, key)
+		}
+		if err != nil {
+			ctx.Println(err)
+			return
+		}
+	}
+
+	conf, err := parseConfigFile(newData, lc.fname)
+	if err != nil {
+		ctx.Printf("Bad target: %s\n", err)
+		return
+	}
+
+	if !checkEditedTargets(ctx, conf, *t.Name) {
+		return
+	}
+
+	saveConfig(ctx, lc, newData)
+}
+
+func cmdTargetRemove(ctx *ishell.Context, lc *loadedConfig) {
+	tqlog.Info("Called target remove command with args %v", ctx.Args)
+
+	if len(ctx.Args) != 1 {
+		ctx.Println("Usage: target remove <name>")
+		return
+	}
+
+	data, raw, err := readRawConfig(lc.fname)
+	if err != nil {
+		ctx.Printf("Error reading %s: %s\n", lc.fname, err)
+		return
+	}
+
+	idx, err := findRawTarget(raw, ctx.Args[0])
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	if !confirm(ctx, fmt.Sprintf("Remove target <%s> from %s?", ctx.Args[0], lc.fname)) {
+		return
+	}
+
+	newData, err := yamlRemoveItem(data, "targets", idx)
+	if err != nil {
+		ctx.Println(err)
+		return
+	}
+
+	saveConfig(ctx, lc, newData)
+}
@@ -0,0 +1,288 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlLineRe extracts the line number from the errors of the yaml package
+var yamlLineRe = regexp.MustCompile(`line ([0-9]+): (.*)$`)
+
+// configProblem is a single problem, found during config validation.
+// line is the line in the config file. Zero means the line is unknown.
+// file is set for the problems in included files. Empty file means the
+// validated file itself.
+type configProblem struct {
+	line int
+	msg  string
+	file string
+}
+
+// format returns the problem as a message, prefixed with its file and line.
+// fname is used for the problems in the validated file itself.
+func (p configProblem) format(fname string) string {
+	if len(p.file) > 0 {
+		fname = p.file
+	}
+
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s", fname, p.msg)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", fname, p.line, p.msg)
+}
+
+// in returns the problem as a problem in the included file fname
+func (p configProblem) in(fname string) configProblem {
+	p.file = fname
+	return p
+}
+
+// problemFromYAMLError converts an error message from the yaml package to configProblem
+func problemFromYAMLError(msg string) configProblem {
+	m := yamlLineRe.FindStringSubmatch(msg)
+	if m == nil {
+		return configProblem{0, msg, ""}
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	return configProblem{line, m[2], ""}
+}
+
+// validateConfig checks the whole configuration and returns all problems found.
+// Unlike parseConfig it doesn't stop on the first problem and checks also the
+// parameters which are otherwise checked when the capture is started - missing
+// parameters, keys, host CA and known_hosts files, ports and destinations. fname
+// is the path of the configuration and is used to resolve the included files.
+func validateConfig(data []byte, fname string) []configProblem {
+	problems := make([]configProblem, 0)
+
+	// Unknown keys
+	var strict configParams
+	if err := yaml.UnmarshalStrict(data, &strict); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			// Syntax error. Nothing else can be checked.
+			return append(problems, problemFromYAMLError(err.Error()))
+		}
+
+		for _, e := range typeErr.Errors {
+			problems = append(problems, problemFromYAMLError(e))
+		}
+	}
+
+	problems = append(problems, versionProblems(data)...)
+
+	// The type errors are already reported by the strict decoding. Continue with
+	// the values which were decoded successfully.
+	var conf configParams
+	yaml.Unmarshal(data, &conf)
+
+	// Lines of the targets with each name. There can be more than one, if the
+	// name is duplicated.
+	itemLines := yamlSectionItemLines(data, "targets")
+	nameLines := make(map[string][]int)
+	for i, t := range conf.Targets {
+		if t.Name != nil && i < len(itemLines) {
+			nameLines[*t.Name] = append(nameLines[*t.Name], itemLines[i])
+		}
+	}
+
+	root, err := filepath.Abs(fname)
+	if err != nil {
+		return append(problems, configProblem{0, err.Error(), ""})
+	}
+	if err := loadIncludes(&conf, filepath.Dir(fname), []string{root}); err != nil {
+		return append(problems, configProblem{0, err.Error(), ""})
+	}
+
+	for _, f := range conf.files {
+		problems = append(problems, validateIncludedFile(f)...)
+	}
+
+	if len(conf.Targets) == 0 {
+		return append(problems, configProblem{0, "No targets defined in config", ""})
+	}
+
+	// Targets from included files, which are not overridden, have got no line
+	targetLines := make([]int, len(conf.Targets))
+	seen := make(map[string]int)
+	for i, t := range conf.Targets {
+		if t.Name == nil {
+			continue
+		}
+		if n := seen[*t.Name]; n < len(nameLines[*t.Name]) {
+			targetLines[i] = nameLines[*t.Name][n]
+		}
+		seen[*t.Name]++
+	}
+	lineOf := func(idx int) int {
+		return targetLines[idx]
+	}
+
+	// A problem in one target doesn't hide the problems in the others
+	for _, err := range resolveAllTargets(&conf) {
+		line := 0
+		if te, ok := err.(targetError); ok {
+			line = lineOf(te.source)
+		}
+		problems = append(problems, configProblem{line, err.Error(), ""})
+	}
+
+	names := make(map[string]int)
+	destinations := make(map[string]string)
+
+	for i := range conf.Targets {
+		t := &conf.Targets[i]
+		line := lineOf(conf.sources[i])
+
+		for _, err := range validateTarget(t) {
+			problems = append(problems, configProblem{line, err.Error(), ""})
+		}
+
+		if _, err := getHostKeyCallback(t); err != nil {
+			problems = append(problems, configProblem{line, fmt.Sprintf("target <%s>: %s", *t.Name, err), ""})
+		}
+
+		if _, err := getAuthMethods(t); err != nil {
+			problems = append(problems, configProblem{line, fmt.Sprintf("target <%s>: %s", *t.Name, err), ""})
+		}
+
+		if _, err := getJumpHosts(t, ssh.ClientConfig{}); err != nil {
+			problems = append(problems, configProblem{line, fmt.Sprintf("target <%s>: bad proxy_jump: %s", *t.Name, err), ""})
+		}
+
+		if first, exists := names[*t.Name]; exists {
+			problems = append(problems, configProblem{line,
+				fmt.Sprintf("target %s is defined more than once (first definition on line %d)", *t.Name, first), ""})
+		} else {
+			names[*t.Name] = line
+		}
+
+		// Two targets writing to the same files overwrite each other's captures
+		if t.Destination != nil && t.FilePattern != nil {
+			file := filepath.Join(filepath.Clean(*t.Destination), *t.FilePattern)
+			if other, exists := destinations[file]; exists {
+				problems = append(problems, configProblem{line,
+					fmt.Sprintf("target <%s> saves its files to %s, as target <%s> does", *t.Name, file, other), ""})
+			} else {
+				destinations[file] = *t.Name
+			}
+		}
+	}
+
+	return problems
+}
+
+// validateIncludedFile checks an included file for unknown keys and syntax errors
+func validateIncludedFile(fname string) []configProblem {
+	problems := make([]configProblem, 0)
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return append(problems, configProblem{0, err.Error(), fname})
+	}
+
+	var strict configParams
+	if err := yaml.UnmarshalStrict(data, &strict); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return append(problems, problemFromYAMLError(err.Error()).in(fname))
+		}
+
+		for _, e := range typeErr.Errors {
+			problems = append(problems, problemFromYAMLError(e).in(fname))
+		}
+	}
+
+	for _, p := range versionProblems(data) {
+		problems = append(problems, p.in(fname))
+	}
+
+	return problems
+}
+
+// versionProblems checks if the version of a config file is supported and if the
+// file contains keys, which are removed in its version
+func versionProblems(data []byte) []configProblem {
+	version, err := readConfigVersion(data)
+	if err != nil {
+		return []configProblem{problemFromYAMLError(err.Error())}
+	}
+
+	var conf configParams
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		// Already reported
+		return nil
+	}
+	conf.Version = version
+
+	if err := checkNoUseSudo(conf); err != nil {
+		return []configProblem{{0, err.Error(), ""}}
+	}
+
+	return nil
+}
+
+// yamlSectionItemLines returns the line numbers of the items of a top level
+// sequence, e.g.:
+//
+// targets:
+// - name: t1    <- line 2
+// - name: t2    <- line 3
+//
+// Returns nil if the section is not found or it is not a sequence.
+func yamlSectionItemLines(data []byte, section string) []int {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil
+	}
+
+	items, err := yamlSection(doc, section)
+	if err != nil {
+		return nil
+	}
+
+	var ret []int
+	for _, item := range items {
+		ret = append(ret, item.Line)
+	}
+
+	return ret
+}
+
+// cmdValidate implements validate subcommand. Returns the exit code of the program.
+func cmdValidate(fname string) int {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration: %s\n", err)
+		return 1
+	}
+
+	problems := validateConfig(data, fname)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p.format(fname))
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found in %s\n", len(problems), fname)
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", fname)
+	if version, err := readConfigVersion(data); err == nil && version < configVersion {
+		fmt.Printf("%s uses config version %d. Run migrate subcommand to upgrade it to version %d\n", fname, version, configVersion)
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var invalidConfig = `# Config with problems
+defaults:
+  user: capture
+  key: /nonexistent/key
+  destination: pcaps
+
+targets:
+- name: web
+  host: 10.0.0.1
+  file_pattern: trace
+  unknown_key: 1
+- name: db
+  host: 10.0.0.2
+  port: 70000
+  file_pattern: trace
+- name: web
+  file_pattern: other
+`
+
+func TestValidateConfig(t *testing.T) {
+	problems := validateConfig([]byte(invalidConfig), "config.yaml")
+
+	expected := []struct {
+		line int
+		msg  string
+	}{
+		{11, "unknown_key"},
+		{8, "unable to read private key"},
+		{12, "Invalid port for target <db>"},
+		{12, "saves its files to pcaps/trace, as target <web> does"},
+		{16, "Missing Host for target <web>"},
+		{16, "defined more than once (first definition on line 8)"},
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, p := range problems {
+			if p.line == e.line && strings.Contains(p.msg, e.msg) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("Expected problem on line %d: %s. Got:", e.line, e.msg)
+			for _, p := range problems {
+				t.Errorf("\t%s", p.format("config.yaml"))
+			}
+		}
+	}
+}
+
+func TestValidateConfigAllTargets(t *testing.T) {
+	conf := `defaults:
+  user: capture
+  key: /nonexistent/key
+  destination: pcaps
+targets:
+- name: a
+  group: missing
+  file_pattern: a
+- host: 10.0.0.2
+  file_pattern: b
+- name: c
+  host: worker[5-1]
+  file_pattern: c
+- name: d
+  host: 10.0.0.4
+  port: 0
+  file_pattern: d
+`
+	problems := validateConfig([]byte(conf), "config.yaml")
+
+	expected := []struct {
+		line int
+		msg  string
+	}{
+		{6, "target <a> refers to unknown group missing"},
+		{0, "Missing Name for target #2"},
+		{11, "Invalid host for target <c>"},
+		{14, "Invalid port for target <d>"},
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, p := range problems {
+			if p.line == e.line && strings.Contains(p.msg, e.msg) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("Expected problem on line %d: %s. Got: %v", e.line, e.msg, problems)
+		}
+	}
+}
+
+func TestValidateConfigSyntaxError(t *testing.T) {
+	problems := validateConfig([]byte("targets:\n- name: a\n   host: b\n"), "config.yaml")
+
+	if len(problems) != 1 || problems[0].line != 3 {
+		t.Errorf("Expected one problem on line 3, got %v", problems)
+	}
+}
+
+func TestYAMLSectionItemLines(t *testing.T) {
+	data := "defaults:\n  user: a\ntargets:\n  # comment\n  - name: a\n    host: b\n\n  - name: c\ngroups:\n  - x\n"
+	lines := yamlSectionItemLines([]byte(data), "targets")
+
+	if len(lines) != 2 || lines[0] != 5 || lines[1] != 8 {
+		t.Errorf("Bad item lines: %v", lines)
+	}
+}
+
+func TestValidateConfigUseSudo(t *testing.T) {
+	conf := "version: 2\ntargets:\n- name: t1\n  host: h\n  use_sudo: true\n"
+
+	count := 0
+	for _, p := range validateConfig([]byte(conf), "config.yaml") {
+		if strings.Contains(p.msg, "use_sudo") {
+			count++
+		}
+	}
+
+	if count != 1 {
+		t.Errorf("Expected use_sudo to be reported once, got %d times", count)
+	}
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configVersion is the current version of the config file format. Files
+// without version key are version 1.
+//
+// Version history:
+// 1 - use_sudo is supported, unknown keys are ignored
+// 2 - use_sudo is replaced by privilege, unknown keys are an error
+const configVersion = 2
+
+// readConfigVersion returns the version of a config file
+func readConfigVersion(data []byte) (int, error) {
+	var v struct {
+		Version *int
+	}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return 0, err
+	}
+
+	if v.Version == nil {
+		return 1, nil
+	}
+
+	if *v.Version < 1 || *v.Version > configVersion {
+		return 0, fmt.Errorf("Unsupported config version %d. This version of tranqap supports versions 1 to %d", *v.Version, configVersion)
+	}
+
+	return *v.Version, nil
+}
+
+// decodeConfig decodes a single config file according to its version. Files with
+// the current version are decoded strictly - unknown keys are an error. Older
+// files are decoded as before, so that they keep working until they are migrated.
+// conf.Version is set to the version of the file.
+func decodeConfig(data []byte) (configParams, error) {
+	var conf configParams
+
+	version, err := readConfigVersion(data)
+	if err != nil {
+		return conf, err
+	}
+
+	if version < configVersion {
+		err = yaml.Unmarshal(data, &conf)
+	} else {
+		err = yaml.UnmarshalStrict(data, &conf)
+	}
+	if err != nil {
+		return conf, err
+	}
+
+	conf.Version = version
+
+	if err := checkNoUseSudo(conf); err != nil {
+		return conf, err
+	}
+
+	return conf, nil
+}
+
+// checkNoUseSudo returns an error if use_sudo is set anywhere in the configuration
+// and its version is 2 or later. use_sudo is not supported since version 2.
+func checkNoUseSudo(conf configParams) error {
+	const msg = "use_sudo is not supported in config version %d, use privilege instead (%s)"
+
+	if conf.Version < 2 {
+		return nil
+	}
+
+	if conf.Defaults != nil && conf.Defaults.UseSudo != nil {
+		return fmt.Errorf(msg, conf.Version, "defaults")
+	}
+
+	for name, g := range conf.Groups {
+		if g.UseSudo != nil {
+			return fmt.Errorf(msg, conf.Version, "group "+name)
+		}
+	}
+
+	for i, t := range conf.Targets {
+		if t.UseSudo == nil {
+			continue
+		}
+		if t.Name != nil {
+			return fmt.Errorf(msg, conf.Version, "target <"+*t.Name+">")
+		}
+		return fmt.Errorf(msg, conf.Version, fmt.Sprintf("target #%d", i+1))
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tdimitrov/tranqap/internal/output"
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// viewerParams describes an external program, which reads the PCAP stream of
+// a capture, e.g. termshark or tshark
+type viewerParams struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args,omitempty"`
+	Log     *string  `yaml:"log,omitempty"`
+}
+
+// validateViewers checks the viewers and expands the environment variables in them
+func validateViewers(conf *configParams) error {
+	for name, v := range conf.Viewers {
+		if name == outputFile || name == outputWireshark {
+			return fmt.Errorf("%s is a built-in output and can't be used as a viewer name", name)
+		}
+		if len(v.Command) == 0 {
+			return fmt.Errorf("Missing command for viewer %s", name)
+		}
+
+		var err error
+		if v.Command, err = expandEnv(v.Command); err != nil {
+			return fmt.Errorf("Error in viewer %s: %s", name, err)
+		}
+		if v.Log != nil {
+			log, err := expandEnv(*v.Log)
+			if err != nil {
+				return fmt.Errorf("Error in viewer %s: %s", name, err)
+			}
+			log = expandHome(log)
+			v.Log = &log
+		}
+		conf.Viewers[name] = v
+	}
+
+	return nil
+}
+
+// validateOutputs returns an error if an output is neither built-in, nor a viewer.
+// owner is used for error reporting, e.g. "target <a>".
+func (cp *configParams) validateOutputs(outputs []string, owner string) error {
+	for _, o := range outputs {
+		if !cp.validOutput(o) {
+			return fmt.Errorf("Unknown output %s for %s. Expected one of: %s", o, owner, strings.Join(cp.getOutputsList(), ", "))
+		}
+	}
+
+	return nil
+}
+
+func (cp *configParams) validOutput(name string) bool {
+	_, isViewer := cp.Viewers[name]
+	return isViewer || hasString(outputNames, name)
+}
+
+// getOutputsList returns the built-in outputs, followed by the viewers in
+// alphabetical order
+func (cp *configParams) getOutputsList() []string {
+	return append(append([]string{}, outputNames...), cp.getViewersList()...)
+}
+
+// getViewersList returns the names of all viewers in alphabetical order
+func (cp *configParams) getViewersList() []string {
+	ret := make([]string, 0, len(cp.Viewers))
+	for name := range cp.Viewers {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// viewerPlaceholders returns the values of the placeholders, which can be used
+// in the arguments of a viewer
+func viewerPlaceholders(t target) map[string]string {
+	ret := map[string]string{"{target}": *t.Name}
+	if t.Host != nil {
+		ret["{host}"] = *t.Host
+	}
+	if t.Destination != nil {
+		ret["{destination}"] = *t.Destination
+	}
+	if t.FilePattern != nil {
+		ret["{file_pattern}"] = *t.FilePattern
+	}
+
+	return ret
+}
+
+// getViewerConfig returns the configuration of the viewer for the target
+func getViewerConfig(name string, v viewerParams, t target) output.ViewerConfig {
+	placeholders := viewerPlaceholders(t)
+	replace := func(s string) string {
+		for k, val := range placeholders {
+			s = strings.Replace(s, k, val, -1)
+		}
+		return s
+	}
+
+	args := make([]string, 0, len(v.Args))
+	for _, a := range v.Args {
+		args = append(args, replace(a))
+	}
+
+	ret := output.ViewerConfig{Name: name, Command: v.Command, Args: args, Respawn: respawnViewers(t)}
+	if v.Log != nil {
+		ret.Log = replace(*v.Log)
+	}
+
+	return ret
+}
+
+// newViewerOutputer returns an OutputerFactory, which starts the viewer for the target
+func newViewerOutputer(name string, v viewerParams, t target) output.OutputerFactory {
+	cfg := getViewerConfig(name, v, t)
+	return func(p output.MOEventChan) output.Outputer {
+		return output.NewViewerOutput(cfg, p)
+	}
+}
+
+// cmdView starts a viewer for each selected running capture
+func cmdView(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called view command with args %v", args)
+
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: view <viewer> [target|@tag ...]. Viewers: %s", strings.Join(cfg.getViewersList(), ", "))
+	}
+
+	v, ok := cfg.Viewers[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown viewer %s", args[0])
+	}
+
+	targets, err := cfg.selectTargets(args[1:])
+	if err != nil {
+		return err
+	}
+
+	started := 0
+	for _, t := range targets {
+		if !capturers.Running(*t.Name) {
+			if len(args) > 1 {
+				cio.Printf("There is no running capture for target <%s>\n", *t.Name)
+			}
+			continue
+		}
+
+		capturers.AddNewOutput(newViewerOutputer(args[0], v, t), []string{*t.Name})
+		started++
+	}
+
+	if started == 0 {
+		return fmt.Errorf("There are no running captures.")
+	}
+
+	return nil
+}
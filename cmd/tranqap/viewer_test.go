@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tdimitrov/tranqap/internal/output"
+)
+
+var viewersConfig = `viewers:
+  termshark:
+    command: xterm
+    args: [-T, "{target}", -e, termshark, -r, "{fifo}"]
+  sip:
+    command: tshark
+    args: [-i, -, -Y, sip, -w, "{destination}/{file_pattern}-sip.pcap"]
+    log: /tmp/{target}-tshark.log
+profiles:
+  debug:
+    outputs: [file, termshark]
+targets:
+- name: t1
+  host: 10.0.0.1
+  user: capture
+  key: secret.key
+  destination: pcaps
+  file_pattern: t1
+  outputs: [sip]
+  respawn_viewers: true`
+
+func TestViewers(t *testing.T) {
+	conf, err := parseConfig([]byte(viewersConfig), ".")
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	if !reflect.DeepEqual(conf.getViewersList(), []string{"sip", "termshark"}) {
+		t.Errorf("Bad viewers list: %v", conf.getViewersList())
+	}
+	if !reflect.DeepEqual(conf.getOutputsList(), []string{outputFile, outputWireshark, "sip", "termshark"}) {
+		t.Errorf("Bad outputs list: %v", conf.getOutputsList())
+	}
+
+	tgt := conf.Targets[0]
+	expected := output.ViewerConfig{
+		Name:    "sip",
+		Command: "tshark",
+		Args:    []string{"-i", "-", "-Y", "sip", "-w", "pcaps/t1-sip.pcap"},
+		Log:     "/tmp/t1-tshark.log",
+		Respawn: true,
+	}
+	if cfg := getViewerConfig("sip", conf.Viewers["sip"], tgt); !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Bad viewer config: %+v", cfg)
+	}
+
+	// {fifo} is replaced when the viewer is started
+	expected = output.ViewerConfig{
+		Name:    "termshark",
+		Command: "xterm",
+		Args:    []string{"-T", "t1", "-e", "termshark", "-r", output.FifoPlaceholder},
+		Respawn: true,
+	}
+	if cfg := getViewerConfig("termshark", conf.Viewers["termshark"], tgt); !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("Bad viewer config: %+v", cfg)
+	}
+}
+
+func TestBadViewers(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"viewers:\n  tv:\n    args: [-r, -]\ntargets:\n- name: t1", "Missing command for viewer tv"},
+		{"viewers:\n  file:\n    command: cat\ntargets:\n- name: t1", "file is a built-in output"},
+		{"targets:\n- name: t1\n  outputs: [tv]", "Unknown output tv for target <t1>. Expected one of: file, wireshark"},
+		{"profiles:\n  p:\n    outputs: [tv]\ntargets:\n- name: t1", "Unknown output tv for profile p"},
+	}
+
+	for _, tc := range tests {
+		_, err := parseConfig([]byte(tc.config), ".")
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected error '%s', got %v", tc.err, err)
+		}
+	}
+}
+
+func TestMergeViewers(t *testing.T) {
+	high := map[string]viewerParams{"tv": {Command: "termshark"}}
+	low := map[string]viewerParams{"tv": {Command: "tshark", Args: []string{"-i", "-"}}, "zeek": {Command: "zeek"}}
+
+	merged := mergeViewers(high, low)
+	if len(merged) != 2 || !reflect.DeepEqual(merged["tv"], high["tv"]) || merged["zeek"].Command != "zeek" {
+		t.Errorf("Bad merged viewers: %v", merged)
+	}
+
+	if mergeViewers(nil, nil) != nil {
+		t.Errorf("Expected nil viewers")
+	}
+}
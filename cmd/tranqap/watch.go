@@ -0,0 +1,129 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/output"
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// watchBufferSize is the number of packet summaries, which are buffered for
+// printing. If the terminal can't keep up, the summaries are dropped.
+const watchBufferSize = 1024
+
+// cmdWatch prints a summary of each captured packet until Ctrl-C is pressed
+func cmdWatch(cio commandIO, cfg configParams, args []string) error {
+	tqlog.Info("Called watch command with args %v", args)
+
+	// The terminal is not in raw mode while a command is executed, so Ctrl-C
+	// generates SIGINT
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	return watchCaptures(cio, cfg, args, sigs)
+}
+
+// watchTargets returns the running captures, selected by args. All running
+// captures are selected if args is empty.
+func watchTargets(cio commandIO, cfg configParams, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return capturers.Names(), nil
+	}
+
+	names, err := cfg.selectTargetNames(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(names))
+	for _, name := range names {
+		if capturers.Running(name) == true {
+			ret = append(ret, name)
+		} else {
+			cio.Printf("There is no running capture for target <%s>.\n", name)
+		}
+	}
+
+	return ret, nil
+}
+
+// watchCaptures attaches SummaryOutput to the captures and prints the summaries
+// until stop receives a value or all captures stop
+func watchCaptures(cio commandIO, cfg configParams, args []string, stop <-chan os.Signal) error {
+	names, err := watchTargets(cio, cfg, args)
+	if err != nil {
+		return err
+	}
+
+	lines := make(chan string, watchBufferSize)
+	outs := make([]*output.SummaryOutput, 0, len(names))
+	watched := make([]string, 0, len(names))
+	for _, name := range names {
+		name := name
+		before := len(outs)
+		capturers.AddNewOutput(func(events output.MOEventChan) output.Outputer {
+			so := output.NewSummaryOutput(name, lines, events)
+			outs = append(outs, so)
+			return so
+		}, []string{name})
+		if len(outs) > before {
+			watched = append(watched, name)
+		}
+	}
+
+	if len(outs) == 0 {
+		return errors.New("There are no running captures.")
+	}
+
+	defer func() {
+		var dropped uint64
+		for _, so := range outs {
+			so.Close()
+			dropped += so.Dropped()
+		}
+		if dropped > 0 {
+			cio.Printf("%d packet summaries were dropped, because the terminal was too slow\n", dropped)
+		}
+	}()
+
+	cio.Printf("Watching %s. Press Ctrl-C to stop.\n", strings.Join(watched, ", "))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case l := <-lines:
+			cio.Println(l)
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if allDone(outs) {
+				cio.Println("The watched captures have stopped.")
+				return nil
+			}
+		}
+	}
+}
+
+// allDone returns true if all outputs are closed
+func allDone(outs []*output.SummaryOutput) bool {
+	for _, so := range outs {
+		select {
+		case <-so.Done():
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/capture"
+	"github.com/tdimitrov/tranqap/internal/output"
+)
+
+// fakeCapturer is a Capturer, which writes to MultiOutput the data written to it
+type fakeCapturer struct {
+	name string
+	out  *output.MultiOutput
+}
+
+func (c *fakeCapturer) Start() error { return nil }
+func (c *fakeCapturer) Stop() error  { return nil }
+func (c *fakeCapturer) Name() string { return c.name }
+
+func (c *fakeCapturer) AddOutputer(fn output.OutputerFactory) error {
+	return c.out.AddExtMember(fn)
+}
+
+func (c *fakeCapturer) Status() capture.Status {
+	return capture.Status{Name: c.name, Output: c.out.Stats()}
+}
+
+func (c *fakeCapturer) Mark(t time.Time, text string) error {
+	return c.out.Mark(t, text)
+}
+
+// rawIPStream returns a PCAP stream with link type raw IPv4 and a UDP packet
+func rawIPStream() []byte {
+	stream := make([]byte, 24)
+	binary.LittleEndian.PutUint32(stream[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(stream[20:24], 101)
+
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = 17
+	copy(packet[12:16], []byte{192, 168, 0, 1})
+	copy(packet[16:20], []byte{192, 168, 0, 2})
+	binary.BigEndian.PutUint16(packet[20:22], 1234)
+	binary.BigEndian.PutUint16(packet[22:24], 53)
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+
+	return append(append(stream, record...), packet...)
+}
+
+func TestWatchCaptures(t *testing.T) {
+	initStorage()
+	capt := &fakeCapturer{"t1", output.NewMultiOutput()}
+	capturers.Add(capt)
+
+	var out bufferIO
+	stop := make(chan os.Signal, 1)
+	finished := make(chan error)
+	go func() {
+		finished <- watchCaptures(&out, configParams{}, nil, stop)
+	}()
+
+	for i := 0; i < 100 && len(capt.out.Stats().Members) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	capt.out.Write(rawIPStream())
+	time.Sleep(50 * time.Millisecond)
+
+	stop <- os.Interrupt
+	if err := <-finished; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !strings.Contains(out.String(), "<t1> 192.168.0.1:1234 → 192.168.0.2:53 UDP len 28") {
+		t.Errorf("Expected packet summary, got:\n%s", out.String())
+	}
+
+	for i := 0; i < 100 && len(capt.out.Stats().Members) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(capt.out.Stats().Members); n != 0 {
+		t.Errorf("Expected the summary output to be detached, got %d members", n)
+	}
+
+	// The fake capturer can't report that it has stopped, so the storage is
+	// not closed
+	capt.out.Close()
+}
+
+func TestWatchNoCaptures(t *testing.T) {
+	initStorage()
+	defer capturers.Close()
+
+	var out bufferIO
+	if err := watchCaptures(&out, configParams{}, nil, nil); err == nil || err.Error() != "There are no running captures." {
+		t.Errorf("Expected error for no running captures, got %v", err)
+	}
+}
@@ -0,0 +1,600 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file is edited in place. The edited nodes are found with yaml.v3,
+// which reports the line and the column of each node, and only their lines are
+// replaced. The rest of the file - comments, empty lines, indentation and
+// quoting - is kept as it is. Flow style collections (e.g. [{name: t1}]) are
+// the exception. They are encoded again as a whole, when they are edited.
+
+// yamlKeyValue is a key and its value, already formatted as YAML
+type yamlKeyValue struct {
+	key   string
+	value string
+}
+
+// yamlParse parses a config file. A file without content (empty or only
+// comments) is returned as a document with an empty mapping.
+func yamlParse(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("The configuration is not a mapping")
+	}
+
+	return &doc, nil
+}
+
+// yamlMapIndex returns the index of the key node of key in the content of a
+// mapping node. Returns -1 if the key is not found.
+func yamlMapIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// yamlMapValue returns the value of a key in a mapping node or nil if the key is
+// not found
+func yamlMapValue(m *yaml.Node, key string) *yaml.Node {
+	idx := yamlMapIndex(m, key)
+	if idx == -1 {
+		return nil
+	}
+
+	return m.Content[idx+1]
+}
+
+// yamlMapping returns the mapping at a path of keys, starting from the top level
+// mapping of doc, e.g. ("groups", "backend") returns the parameters of group
+// backend. Returns nil if a key is not found or its value is not a mapping.
+func yamlMapping(doc *yaml.Node, path ...string) *yaml.Node {
+	m := doc.Content[0]
+
+	for _, key := range path {
+		m = yamlMapValue(m, key)
+		if m == nil || m.Kind != yaml.MappingNode {
+			return nil
+		}
+	}
+
+	return m
+}
+
+// yamlSection returns the items of a top level sequence. Returns nil if the
+// section doesn't exist.
+func yamlSection(doc *yaml.Node, section string) ([]*yaml.Node, error) {
+	seq := yamlMapValue(doc.Content[0], section)
+	if seq == nil {
+		return nil, nil
+	}
+
+	if seq.Kind != yaml.SequenceNode {
+		if seq.Kind == yaml.ScalarNode && seq.Tag == "!!null" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s section is not a sequence", section)
+	}
+
+	return seq.Content, nil
+}
+
+// yamlSectionItem returns the idx-th item of a top level sequence. The item
+// has to be a mapping.
+func yamlSectionItem(doc *yaml.Node, section string, idx int) (*yaml.Node, error) {
+	items, err := yamlSection(doc, section)
+	if err != nil {
+		return nil, err
+	}
+
+	if idx >= len(items) {
+		return nil, fmt.Errorf("Item #%d not found in %s section", idx+1, section)
+	}
+	if items[idx].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Item #%d of %s section is not a mapping", idx+1, section)
+	}
+
+	return items[idx], nil
+}
+
+// yamlFormatValue formats a value as a YAML scalar or a flow sequence
+func yamlFormatValue(v interface{}) (string, error) {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return "", err
+	}
+	if n.Kind == yaml.SequenceNode {
+		n.Style = yaml.FlowStyle
+	}
+
+	out, err := yaml.Marshal(&n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// yamlValueNode parses a value, formatted with yamlFormatValue
+func yamlValueNode(value string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+	}
+
+	return doc.Content[0], nil
+}
+
+// yamlMapSet sets the value of a key in a mapping node and renames the key to
+// newKey. The comment after the old value is kept. The key is added at the end
+// of the mapping if it doesn't exist.
+func yamlMapSet(m *yaml.Node, key string, newKey string, value *yaml.Node) {
+	idx := yamlMapIndex(m, key)
+	if idx == -1 {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: newKey}, value)
+		return
+	}
+
+	old := m.Content[idx+1]
+	if len(value.LineComment) == 0 {
+		value.LineComment = old.LineComment
+	}
+
+	m.Content[idx].Value = newKey
+	m.Content[idx+1] = value
+}
+
+// yamlMapRemove removes a key from a mapping node. Nothing is changed if the
+// key doesn't exist.
+func yamlMapRemove(m *yaml.Node, key string) {
+	idx := yamlMapIndex(m, key)
+	if idx == -1 {
+		return
+	}
+
+	m.Content = append(m.Content[:idx], m.Content[idx+2:]...)
+}
+
+// yamlLines is the text of a config file, split in lines. Each line keeps its
+// line ending. The lines are numbered from 1, as in yaml.Node.
+type yamlLines []string
+
+func newYAMLLines(data []byte) yamlLines {
+	text := string(data)
+	if len(text) > 0 && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	return lines[:len(lines)-1]
+}
+
+// replace replaces the lines from first to last (inclusive) with text. text is
+// inserted before first if last is first-1.
+func (l yamlLines) replace(first int, last int, text []string) yamlLines {
+	ret := append(yamlLines{}, l[:first-1]...)
+	for _, t := range text {
+		ret = append(ret, t+"\n")
+	}
+
+	return append(ret, l[last:]...)
+}
+
+// bytes returns the text of the edited file. It has to be valid YAML, so that a
+// bug in the edit can't corrupt the config file.
+func (l yamlLines) bytes() ([]byte, error) {
+	data := []byte(strings.Join(l, ""))
+	if _, err := yamlParse(data); err != nil {
+		return nil, fmt.Errorf("The edited configuration is not valid: %s", err)
+	}
+
+	return data, nil
+}
+
+// yamlOffset returns the offset in bytes of a column of a line. The columns
+// are counted in characters, starting from 1.
+func yamlOffset(line string, column int) int {
+	col := 1
+	for i := range line {
+		if col == column {
+			return i
+		}
+		col++
+	}
+
+	return len(line)
+}
+
+// yamlIndent returns the indentation of a column
+func yamlIndent(column int) string {
+	if column < 1 {
+		return ""
+	}
+
+	return strings.Repeat(" ", column-1)
+}
+
+// yamlComment returns the comment of the nodes at the end of a line, together
+// with the spaces before it, e.g. "  # lab server". Returns an empty string if
+// there is no comment.
+func yamlComment(line string, nodes ...*yaml.Node) string {
+	text := strings.TrimRight(line, "\r\n")
+
+	for _, n := range nodes {
+		if len(n.LineComment) > 0 && strings.HasSuffix(text, n.LineComment) {
+			rest := strings.TrimSuffix(text, n.LineComment)
+			return text[len(strings.TrimRight(rest, " \t")):]
+		}
+	}
+
+	return ""
+}
+
+// yamlNodes returns a node and all nodes under it in the order of the file
+func yamlNodes(n *yaml.Node) []*yaml.Node {
+	ret := []*yaml.Node{n}
+	for _, c := range n.Content {
+		ret = append(ret, yamlNodes(c)...)
+	}
+
+	return ret
+}
+
+// yamlLastLine returns the last line of a block style node. The node ends
+// before the next node of the document. The empty lines and the comments before
+// the next node are not part of the node.
+func yamlLastLine(doc *yaml.Node, lines yamlLines, n *yaml.Node) int {
+	last := len(lines)
+
+	nodes := yamlNodes(doc)
+	for i, c := range nodes {
+		if c != n {
+			continue
+		}
+		if next := i + len(yamlNodes(n)); next < len(nodes) {
+			last = nodes[next].Line - 1
+		}
+		break
+	}
+
+	for last > n.Line {
+		text := strings.TrimSpace(lines[last-1])
+		if len(text) > 0 && !strings.HasPrefix(text, "#") {
+			break
+		}
+		last--
+	}
+
+	if last < n.Line {
+		return n.Line
+	}
+
+	return last
+}
+
+// yamlQuoteEnd returns the offset of the end of a quoted scalar, which starts at
+// offset start
+func yamlQuoteEnd(text []byte, start int) int {
+	quote := text[start]
+
+	for i := start + 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+
+	return len(text)
+}
+
+// yamlFlowEnd returns the offset after the end of a flow style collection, which
+// starts at offset start. Returns -1 if the end is not found.
+func yamlFlowEnd(text []byte, start int) int {
+	depth := 0
+
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case '"', '\'':
+			// A quote in the middle of a plain scalar, e.g. don't, is not a quoted scalar
+			if strings.IndexByte(" \t\r\n[{,:", text[i-1]) != -1 {
+				i = yamlQuoteEnd(text, i)
+			}
+		case '#':
+			if strings.IndexByte(" \t\r\n", text[i-1]) != -1 {
+				for i < len(text) && text[i] != '\n' {
+					i++
+				}
+			}
+		}
+	}
+
+	return -1
+}
+
+// yamlReplaceFlow replaces the text of a flow style collection with the encoded
+// node. It is used after the collection is edited as a node.
+func yamlReplaceFlow(data []byte, n *yaml.Node) ([]byte, error) {
+	lines := newYAMLLines(data)
+	text := []byte(strings.Join(lines, ""))
+
+	start := len(strings.Join(lines[:n.Line-1], "")) + yamlOffset(lines[n.Line-1], n.Column)
+	end := yamlFlowEnd(text, start)
+	if end == -1 {
+		return nil, fmt.Errorf("Can't find the end of the collection at line %d", n.Line)
+	}
+
+	// The comments of the collection are not part of its text
+	encoded := *n
+	encoded.Style = yaml.FlowStyle
+	encoded.HeadComment, encoded.LineComment, encoded.FootComment = "", "", ""
+	out, err := yaml.Marshal(&encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	newText := string(text[:start]) + string(bytes.TrimSpace(out)) + string(text[end:])
+	return newYAMLLines([]byte(newText)).bytes()
+}
+
+// yamlSetMapKey sets the value of a key in a mapping of the document doc, parsed
+// from data. The key is renamed to newKey. value is already formatted as YAML.
+// The comment after the old value is kept. The key is added at the end of the
+// mapping if it doesn't exist.
+func yamlSetMapKey(data []byte, doc *yaml.Node, m *yaml.Node, key string, newKey string, value string) ([]byte, error) {
+	if m.Style&yaml.FlowStyle != 0 {
+		valueNode, err := yamlValueNode(value)
+		if err != nil {
+			return nil, err
+		}
+		yamlMapSet(m, key, newKey, valueNode)
+		return yamlReplaceFlow(data, m)
+	}
+
+	lines := newYAMLLines(data)
+
+	idx := yamlMapIndex(m, key)
+	if idx == -1 {
+		text := yamlIndent(m.Column) + newKey + ": " + value
+		if len(m.Content) == 0 {
+			// Only the top level mapping of a file without content is empty
+			return append(lines, text+"\n").bytes()
+		}
+
+		last := yamlLastLine(doc, lines, m)
+		return lines.replace(last+1, last, []string{text}).bytes()
+	}
+
+	k, v := m.Content[idx], m.Content[idx+1]
+	line := lines[k.Line-1]
+	text := line[:yamlOffset(line, k.Column)] + newKey + ": " + value + yamlComment(line, k, v)
+
+	return lines.replace(k.Line, yamlLastLine(doc, lines, v), []string{text}).bytes()
+}
+
+// yamlRemoveMapKey removes a key from a mapping of the document doc, parsed from
+// data. Nothing is changed if the key doesn't exist.
+func yamlRemoveMapKey(data []byte, doc *yaml.Node, m *yaml.Node, key string) ([]byte, error) {
+	idx := yamlMapIndex(m, key)
+	if idx == -1 {
+		return data, nil
+	}
+
+	if m.Style&yaml.FlowStyle != 0 {
+		yamlMapRemove(m, key)
+		return yamlReplaceFlow(data, m)
+	}
+
+	lines := newYAMLLines(data)
+	k, v := m.Content[idx], m.Content[idx+1]
+	last := yamlLastLine(doc, lines, v)
+
+	line := lines[k.Line-1]
+	prefix := line[:yamlOffset(line, k.Column)]
+	if len(strings.TrimSpace(prefix)) == 0 {
+		return lines.replace(k.Line, last, nil).bytes()
+	}
+
+	// The key starts an item of a sequence, e.g. "- name: t1". The dash is moved
+	// to the next key or the item becomes an empty mapping.
+	if idx+2 >= len(m.Content) {
+		return lines.replace(k.Line, last, []string{prefix + "{}"}).bytes()
+	}
+
+	next := m.Content[idx+2]
+	nextLine := lines[next.Line-1]
+	lines[next.Line-1] = prefix + nextLine[yamlOffset(nextLine, next.Column):]
+
+	return lines.replace(k.Line, last, nil).bytes()
+}
+
+// yamlItemLines formats an item of a block sequence. prefix is the text before
+// the first key, e.g. "  - ".
+func yamlItemLines(prefix string, keys []yamlKeyValue) []string {
+	ret := make([]string, 0, len(keys))
+	for i, kv := range keys {
+		if i == 0 {
+			ret = append(ret, prefix+kv.key+": "+kv.value)
+		} else {
+			ret = append(ret, strings.Repeat(" ", len(prefix))+kv.key+": "+kv.value)
+		}
+	}
+
+	return ret
+}
+
+// yamlAddItem appends an item with the keys to a top level section. The section
+// is created if it doesn't exist.
+func yamlAddItem(data []byte, section string, keys []yamlKeyValue) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Can't add an empty item to %s", section)
+	}
+
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := yamlSection(doc, section); err != nil {
+		return nil, err
+	}
+
+	top := doc.Content[0]
+	seq := yamlMapValue(top, section)
+	if top.Style&yaml.FlowStyle != 0 || (seq != nil && seq.Style&yaml.FlowStyle != 0) {
+		item := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, kv := range keys {
+			value, err := yamlValueNode(kv.value)
+			if err != nil {
+				return nil, err
+			}
+			yamlMapSet(item, kv.key, kv.key, value)
+		}
+
+		if seq == nil {
+			seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+			yamlMapSet(top, section, section, seq)
+			return yamlReplaceFlow(data, top)
+		}
+		seq.Content = append(seq.Content, item)
+		return yamlReplaceFlow(data, seq)
+	}
+
+	lines := newYAMLLines(data)
+
+	if seq != nil && seq.Kind == yaml.SequenceNode {
+		// The dashes of a block sequence are at the column of the sequence
+		last := yamlLastLine(doc, lines, seq)
+		return lines.replace(last+1, last, yamlItemLines(yamlIndent(seq.Column)+"- ", keys)).bytes()
+	}
+
+	indent := yamlIndent(top.Column)
+	if len(top.Content) == 0 {
+		indent = ""
+	}
+	items := yamlItemLines(indent+"    - ", keys)
+
+	if seq == nil {
+		return lines.replace(len(lines)+1, len(lines), append([]string{indent + section + ":"}, items...)).bytes()
+	}
+
+	// The section exists without items, e.g. "targets:"
+	k := top.Content[yamlMapIndex(top, section)]
+	line := lines[k.Line-1]
+	text := line[:yamlOffset(line, k.Column)] + section + ":" + yamlComment(line, k, seq)
+
+	return lines.replace(k.Line, yamlLastLine(doc, lines, seq), append([]string{text}, items...)).bytes()
+}
+
+// yamlSetKey sets the value of a key in the idx-th item of a section. The rest of
+// the item is not changed. The key is added at the end of the item if it doesn't
+// exist.
+func yamlSetKey(data []byte, section string, idx int, key string, value string) ([]byte, error) {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := yamlSectionItem(doc, section, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return yamlSetMapKey(data, doc, item, key, key, value)
+}
+
+// yamlRemoveKey removes a key from the idx-th item of a section. Nothing is
+// changed if the key doesn't exist.
+func yamlRemoveKey(data []byte, section string, idx int, key string) ([]byte, error) {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := yamlSectionItem(doc, section, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return yamlRemoveMapKey(data, doc, item, key)
+}
+
+// yamlRemoveItem removes the idx-th item of a section together with the comments
+// right above it
+func yamlRemoveItem(data []byte, section string, idx int) ([]byte, error) {
+	doc, err := yamlParse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := yamlSection(doc, section)
+	if err != nil {
+		return nil, err
+	}
+	if idx >= len(items) {
+		return nil, fmt.Errorf("Item #%d not found in %s section", idx+1, section)
+	}
+
+	seq := yamlMapValue(doc.Content[0], section)
+	if seq.Style&yaml.FlowStyle != 0 {
+		seq.Content = append(seq.Content[:idx], seq.Content[idx+1:]...)
+		return yamlReplaceFlow(data, seq)
+	}
+
+	lines := newYAMLLines(data)
+	item := items[idx]
+	first := item.Line
+
+	// The dash can be on a line of its own, before the item
+	line := lines[first-1]
+	if len(strings.TrimSpace(line[:yamlOffset(line, item.Column)])) == 0 && first > 1 &&
+		strings.TrimSpace(lines[first-2]) == "-" {
+		first--
+	}
+
+	// The comments above the item, which are indented like the dash
+	dash := yamlIndent(seq.Column) + "#"
+	for first > 1 && strings.HasPrefix(strings.TrimRight(lines[first-2], "\r\n"), dash) {
+		first--
+	}
+
+	return lines.replace(first, yamlLastLine(doc, lines, item), nil).bytes()
+}
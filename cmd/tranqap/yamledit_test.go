@@ -0,0 +1,264 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var editConfig = `# tranqap configuration
+targets:
+# The first target
+- name: t1
+  host: 10.0.0.1 # lab server
+  tags:
+  - web
+  - prod
+
+# The second target
+- name: t2
+  host: 10.0.0.2
+groups:
+  g1:
+    port: 2222
+`
+
+func TestYAMLSection(t *testing.T) {
+	doc, err := yamlParse([]byte(editConfig))
+	if err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+
+	items, err := yamlSection(doc, "targets")
+	if err != nil {
+		t.Fatalf("Error getting section: %s", err)
+	}
+	if len(items) != 2 || items[0].Line != 4 || items[1].Line != 11 {
+		t.Errorf("Bad items: %v", items)
+	}
+
+	if _, err := yamlSection(doc, "groups"); err == nil {
+		t.Errorf("Expected error for mapping section")
+	}
+
+	if items, err := yamlSection(doc, "profiles"); items != nil || err != nil {
+		t.Errorf("Expected no items for missing section. Got %v %v", items, err)
+	}
+}
+
+func TestYAMLAddItem(t *testing.T) {
+	res, err := yamlAddItem([]byte(editConfig), "targets", []yamlKeyValue{{"name", "t3"}, {"host", "10.0.0.3"}})
+	if err != nil {
+		t.Fatalf("Error adding item: %s", err)
+	}
+
+	expected := `# tranqap configuration
+targets:
+# The first target
+- name: t1
+  host: 10.0.0.1 # lab server
+  tags:
+  - web
+  - prod
+
+# The second target
+- name: t2
+  host: 10.0.0.2
+- name: t3
+  host: 10.0.0.3
+groups:
+  g1:
+    port: 2222
+`
+	if string(res) != expected {
+		t.Errorf("Bad result:\n%s", res)
+	}
+
+	res, err = yamlAddItem([]byte("# empty\n"), "targets", []yamlKeyValue{{"name", "t1"}})
+	if err != nil {
+		t.Fatalf("Error adding item: %s", err)
+	}
+	if string(res) != "# empty\ntargets:\n    - name: t1\n" {
+		t.Errorf("Bad result:\n%s", res)
+	}
+
+	res, err = yamlAddItem([]byte("targets: # none yet\nversion: 2\n"), "targets", []yamlKeyValue{{"name", "t1"}})
+	if err != nil {
+		t.Fatalf("Error adding item: %s", err)
+	}
+	if string(res) != "targets: # none yet\n    - name: t1\nversion: 2\n" {
+		t.Errorf("Bad result:\n%s", res)
+	}
+}
+
+func TestYAMLSetKey(t *testing.T) {
+	res, err := yamlSetKey([]byte(editConfig), "targets", 0, "host", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	res, err = yamlSetKey(res, "targets", 0, "tags", "[db]")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	res, err = yamlSetKey(res, "targets", 1, "port", "22")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+
+	expected := `# tranqap configuration
+targets:
+# The first target
+- name: t1
+  host: 10.0.0.5 # lab server
+  tags: [db]
+
+# The second target
+- name: t2
+  host: 10.0.0.2
+  port: 22
+groups:
+  g1:
+    port: 2222
+`
+	if string(res) != expected {
+		t.Errorf("Bad result:\n%s", res)
+	}
+}
+
+func TestYAMLRemove(t *testing.T) {
+	res, err := yamlRemoveKey([]byte(editConfig), "targets", 0, "name")
+	if err != nil {
+		t.Fatalf("Error removing key: %s", err)
+	}
+	res, err = yamlRemoveItem(res, "targets", 1)
+	if err != nil {
+		t.Fatalf("Error removing item: %s", err)
+	}
+
+	expected := `# tranqap configuration
+targets:
+# The first target
+- host: 10.0.0.1 # lab server
+  tags:
+  - web
+  - prod
+
+groups:
+  g1:
+    port: 2222
+`
+	if string(res) != expected {
+		t.Errorf("Bad result:\n%s", res)
+	}
+
+	res, err = yamlRemoveKey([]byte("targets:\n- name: t1\n"), "targets", 0, "name")
+	if err != nil {
+		t.Fatalf("Error removing key: %s", err)
+	}
+	if string(res) != "targets:\n- {}\n" {
+		t.Errorf("Bad result:\n%s", res)
+	}
+}
+
+var formattedConfig = `# tranqap configuration
+
+version: 2
+
+defaults:
+    user: "capture"   # quoted on purpose
+
+targets:
+
+    # The first target
+    -   name: t1
+        host: 10.0.0.1
+
+        tags:
+            - web
+
+    # The second target
+    -   name: t2
+        host: 10.0.0.2
+
+# End of targets
+`
+
+func TestYAMLKeepFormatting(t *testing.T) {
+	res, err := yamlSetKey([]byte(formattedConfig), "targets", 0, "host", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	res, err = yamlRemoveKey(res, "targets", 0, "tags")
+	if err != nil {
+		t.Fatalf("Error removing key: %s", err)
+	}
+	res, err = yamlSetKey(res, "targets", 1, "port", "22")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	res, err = yamlAddItem(res, "targets", []yamlKeyValue{{"name", "t3"}, {"host", "10.0.0.3"}})
+	if err != nil {
+		t.Fatalf("Error adding item: %s", err)
+	}
+
+	expected := `# tranqap configuration
+
+version: 2
+
+defaults:
+    user: "capture"   # quoted on purpose
+
+targets:
+
+    # The first target
+    -   name: t1
+        host: 10.0.0.5
+
+
+    # The second target
+    -   name: t2
+        host: 10.0.0.2
+        port: 22
+    - name: t3
+      host: 10.0.0.3
+
+# End of targets
+`
+	if string(res) != expected {
+		t.Errorf("Bad result:\n%s", res)
+	}
+
+	res, err = yamlRemoveItem(res, "targets", 1)
+	if err != nil {
+		t.Fatalf("Error removing item: %s", err)
+	}
+	if !strings.Contains(string(res), "        host: 10.0.0.5\n\n\n    - name: t3\n") {
+		t.Errorf("Bad result after removing an item:\n%s", res)
+	}
+}
+
+func TestYAMLFlowStyle(t *testing.T) {
+	res, err := yamlSetKey([]byte("targets: [{name: t1, host: 10.0.0.1}] # lab\n"), "targets", 0, "host", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("Error setting key: %s", err)
+	}
+	res, err = yamlAddItem(res, "targets", []yamlKeyValue{{"name", "t2"}})
+	if err != nil {
+		t.Fatalf("Error adding item: %s", err)
+	}
+
+	expected := "targets: [{name: t1, host: 10.0.0.5}, {name: t2}] # lab\n"
+	if string(res) != expected {
+		t.Errorf("Expected %q, got %q", expected, res)
+	}
+
+	// Brackets and comments in quoted values are not the end of the collection
+	res, err = yamlRemoveItem([]byte("targets: [{name: 't]1'}, {name: \"t}2\"}]\ngroups: {}\n"), "targets", 0)
+	if err != nil {
+		t.Fatalf("Error removing item: %s", err)
+	}
+
+	expected = "targets: [{name: \"t}2\"}]\ngroups: {}\n"
+	if string(res) != expected {
+		t.Errorf("Expected %q, got %q", expected, res)
+	}
+}
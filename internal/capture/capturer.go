@@ -5,6 +5,8 @@
 package capture
 
 import (
+	"time"
+
 	"github.com/tdimitrov/tranqap/internal/output"
 )
 
@@ -27,6 +29,31 @@ type CapturerEvent struct {
 // CapturerEventChan is the type of the channel used by MultiOutput for event handling
 type CapturerEventChan chan CapturerEvent
 
+// States of a Capturer, reported in Status
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+)
+
+// Status contains the state and the counters of a Capturer. PID is the PID of
+// the capturer process on the target or -1 if it is not known yet. Bytes is
+// the number of bytes received from the target and Throughput is the number
+// of bytes received during the last second. LastData is the time when data
+// was last received. KernelDrops is the number of packets dropped by the
+// kernel, as reported by the capturer.
+type Status struct {
+	Name        string
+	State       string
+	Started     time.Time
+	PID         int
+	Bytes       uint64
+	Throughput  uint64
+	LastData    time.Time
+	KernelDrops uint64
+	Output      output.MultiOutputStats
+}
+
 // Capturer interface represents a general capturer. There are concrete implementations
 // for tcpdump. In the future more can be added, e.g. tshark, dumpcap, etc.
 type Capturer interface {
@@ -34,4 +61,6 @@ type Capturer interface {
 	Stop() error
 	AddOutputer(newOutputer output.OutputerFactory) error
 	Name() string
+	Status() Status
+	Mark(t time.Time, text string) error
 }
@@ -0,0 +1,159 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package capture
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Privilege escalation methods. Any other value is treated as a custom
+// prefix template, which should contain PrivilegeCmdPlaceholder.
+const (
+	PrivilegeNone   = "none"
+	PrivilegeSudo   = "sudo"
+	PrivilegeDoas   = "doas"
+	PrivilegeSu     = "su"
+	PrivilegeRun0   = "run0"
+	PrivilegePkexec = "pkexec"
+)
+
+// PrivilegeCmdPlaceholder is replaced with the actual command in the privilege templates
+const PrivilegeCmdPlaceholder = "{cmd}"
+
+// Kill semantics of the privilege escalation methods
+const (
+	// killSelf - the method execs the command or doesn't stay as a privileged parent,
+	// so the PID of the started process can be killed directly
+	killSelf = iota
+	// killChild - the method forks the command and stays as a privileged parent.
+	// The parent can't be killed by a regular user, that's why the child is killed.
+	killChild = iota
+	// killAny - unknown method (custom template). Kill the children, if there are
+	// any, otherwise the process itself.
+	killAny = iota
+)
+
+// privilegeMethod describes how a command is run with elevated privileges
+// template - {cmd} is replaced with the command to run
+// passwordTemplate - same as template, but the password is read from stdin.
+// Empty if the method doesn't support it.
+// kill - one of the kill* consts above
+// shell - the command is run by another shell, e.g. su -c. It is prefixed with
+// exec and quoted, so that it is passed as a single argument.
+type privilegeMethod struct {
+	template         string
+	passwordTemplate string
+	kill             int
+	shell            bool
+}
+
+var privilegeMethods = map[string]privilegeMethod{
+	PrivilegeNone: {"{cmd}", "", killSelf, false},
+	// Empty prompt, so that nothing is written on the terminal. Background jobs get
+	// /dev/null as stdin, unless it is redirected explicitly.
+	PrivilegeSudo: {"sudo -n {cmd}", "sudo -S -p '' {cmd} 0<&0", killChild, false},
+	// doas execs the command
+	PrivilegeDoas: {"doas -n {cmd}", "", killSelf, false},
+	// su stays as a parent for the PAM session. exec avoids an intermediate shell.
+	// su reads the password from the terminal, so it can't be passed over stdin.
+	PrivilegeSu: {"su root -c {cmd} < /dev/null", "", killChild, true},
+	// The command runs in a transient unit. run0 itself runs as the user and
+	// when it is killed the pipe to the command is closed.
+	PrivilegeRun0: {"run0 --no-ask-password {cmd}", "", killSelf, false},
+	// pkexec execs the command
+	PrivilegePkexec: {"pkexec --disable-internal-agent {cmd}", "", killSelf, false},
+}
+
+// PrivilegeConfig contains config params regarding privilege escalation.
+// Method is one of the Privilege* consts or a custom prefix template, e.g. "sudo -u root {cmd}".
+// Username is pointer to a string with the username, which tcpdump will use
+// to drop privilege to (-Z option). If Method is PrivilegeNone, Username is nil
+// Password is the password for the method, passed over stdin. Supported only with sudo.
+// If it is nil, the method should be configured not to ask for password.
+type PrivilegeConfig struct {
+	Method   string
+	Username *string
+	Password *string
+}
+
+// ValidatePrivilege checks if method is a known privilege escalation method or a valid
+// custom template
+func ValidatePrivilege(method string) error {
+	if _, ok := privilegeMethods[method]; ok {
+		return nil
+	}
+
+	if !strings.Contains(method, PrivilegeCmdPlaceholder) {
+		return fmt.Errorf("unknown privilege method '%s'. Expected one of none, sudo, doas, su, run0, pkexec or a template containing %s",
+			method, PrivilegeCmdPlaceholder)
+	}
+
+	return nil
+}
+
+// Elevated returns true if the command should be run with elevated privileges
+func (p PrivilegeConfig) Elevated() bool {
+	return p.Method != PrivilegeNone
+}
+
+// WrapCommand returns cmd, wrapped with the privilege escalation method
+func (p PrivilegeConfig) WrapCommand(cmd string) (string, error) {
+	if err := ValidatePrivilege(p.Method); err != nil {
+		return "", err
+	}
+
+	m := p.method()
+	template := m.template
+	if p.Password != nil {
+		if len(m.passwordTemplate) == 0 {
+			return "", fmt.Errorf("password is not supported with privilege method '%s'", p.Method)
+		}
+		template = m.passwordTemplate
+	}
+
+	if m.shell == true {
+		cmd = shellQuote("exec " + cmd)
+	}
+
+	return strings.Replace(template, PrivilegeCmdPlaceholder, cmd, -1), nil
+}
+
+// Binary returns the name of the binary, used for privilege escalation.
+// Empty for PrivilegeNone.
+func (p PrivilegeConfig) Binary() string {
+	if !p.Elevated() {
+		return ""
+	}
+
+	fields := strings.Fields(p.method().template)
+	if len(fields) == 0 || fields[0] == PrivilegeCmdPlaceholder {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// stopCommand returns the command which terminates the process with pid, started
+// with the privilege escalation method
+func (p PrivilegeConfig) stopCommand(pid int) string {
+	switch p.method().kill {
+	case killChild:
+		return fmt.Sprintf("kill `ps --ppid %d -o pid=`", pid)
+	case killAny:
+		return fmt.Sprintf("TRANQAP_CHILDREN=`ps --ppid %d -o pid=` ; if [ -n \"$TRANQAP_CHILDREN\" ] ; then kill $TRANQAP_CHILDREN ; else kill %d ; fi",
+			pid, pid)
+	default:
+		return fmt.Sprintf("kill %d", pid)
+	}
+}
+
+func (p PrivilegeConfig) method() privilegeMethod {
+	if m, ok := privilegeMethods[p.Method]; ok {
+		return m
+	}
+
+	return privilegeMethod{p.Method, "", killAny, false}
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package capture
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tdimitrov/tranqap/internal/output"
+)
+
+func newPrivilegedTcpdump(privilege PrivilegeConfig) *Tcpdump {
+	trans := transportMock{false, false, false, make(chan struct{}, 1), sync.Mutex{}, false}
+	inst := NewTcpdump("Test Instance", output.NewMultiOutput(&outputMock{make(chan struct{}), sync.Once{}}), make(CapturerEventChan), &trans,
+		privilege, FilterConfig{nil, nil}, CaptureConfig{}, WatchdogConfig{})
+	if inst == nil {
+		return nil
+	}
+
+	return inst.(*Tcpdump)
+}
+
+func TestPrivilegeSudo(t *testing.T) {
+	user := "capture"
+	password := "secret"
+
+	inst := newPrivilegedTcpdump(PrivilegeConfig{PrivilegeSudo, &user, nil})
+	if !strings.HasPrefix(inst.captureCmd, "sudo -n tcpdump") || !strings.Contains(inst.captureCmd, "-Z capture") {
+		t.Errorf("Expected non-interactive sudo, got: %s", inst.captureCmd)
+	}
+
+	inst = newPrivilegedTcpdump(PrivilegeConfig{PrivilegeSudo, &user, &password})
+	if !strings.HasPrefix(inst.captureCmd, "sudo -S -p '' tcpdump") || !strings.Contains(inst.captureCmd, "0<&0 &") {
+		t.Errorf("Expected sudo reading password from stdin, got: %s", inst.captureCmd)
+	}
+	if strings.Contains(inst.captureCmd, password) {
+		t.Errorf("Password should not be part of the command: %s", inst.captureCmd)
+	}
+
+	if cmd := inst.privilege.stopCommand(42); cmd != "kill `ps --ppid 42 -o pid=`" {
+		t.Errorf("Bad stop command for sudo: %s", cmd)
+	}
+}
+
+func TestPrivilegeMethods(t *testing.T) {
+	user := "capture"
+	password := "secret"
+
+	inst := newPrivilegedTcpdump(PrivilegeConfig{PrivilegeNone, nil, nil})
+	if !strings.HasPrefix(inst.captureCmd, "tcpdump") || strings.Contains(inst.captureCmd, "-Z") {
+		t.Errorf("Unexpected command without privilege escalation: %s", inst.captureCmd)
+	}
+	if cmd := inst.privilege.stopCommand(42); cmd != "kill 42" {
+		t.Errorf("Bad stop command for none: %s", cmd)
+	}
+
+	inst = newPrivilegedTcpdump(PrivilegeConfig{PrivilegeDoas, &user, nil})
+	if !strings.HasPrefix(inst.captureCmd, "doas -n tcpdump") {
+		t.Errorf("Unexpected doas command: %s", inst.captureCmd)
+	}
+	if inst.privilege.Binary() != "doas" {
+		t.Errorf("Bad binary for doas: %s", inst.privilege.Binary())
+	}
+
+	// The command is quoted, so that the filter is not expanded by the shell
+	filter := "port 80 and $(echo x) `echo y` 'x'"
+	inst = NewTcpdump("Test Instance", output.NewMultiOutput(), make(CapturerEventChan), nil,
+		PrivilegeConfig{PrivilegeSu, &user, nil}, FilterConfig{nil, &filter}, CaptureConfig{}, WatchdogConfig{}).(*Tcpdump)
+	suCmd := strings.SplitN(inst.captureCmd, " & ", 2)[0]
+	if !strings.HasPrefix(suCmd, "su root -c ") || !strings.HasSuffix(suCmd, " < /dev/null") {
+		t.Fatalf("Unexpected su command: %s", inst.captureCmd)
+	}
+
+	// The argument of su -c, as seen by su
+	arg := strings.TrimSuffix(strings.TrimPrefix(suCmd, "su root -c "), " < /dev/null")
+	out, err := exec.Command("sh", "-c", "printf %s "+arg).Output()
+	if err != nil {
+		t.Fatalf("Error running sh: %s", err)
+	}
+	if !strings.HasPrefix(string(out), "exec tcpdump") || !strings.Contains(string(out), "$(echo x) `echo y`") {
+		t.Errorf("su command is not quoted: %s", out)
+	}
+
+	// Password is supported only with sudo
+	if newPrivilegedTcpdump(PrivilegeConfig{PrivilegeDoas, &user, &password}) != nil {
+		t.Errorf("Expected nil capturer for doas with password")
+	}
+
+	inst = newPrivilegedTcpdump(PrivilegeConfig{"/usr/local/bin/become {cmd}", &user, nil})
+	if !strings.HasPrefix(inst.captureCmd, "/usr/local/bin/become tcpdump") {
+		t.Errorf("Unexpected custom command: %s", inst.captureCmd)
+	}
+	if inst.privilege.Binary() != "/usr/local/bin/become" {
+		t.Errorf("Bad binary for custom template: %s", inst.privilege.Binary())
+	}
+	if cmd := inst.privilege.stopCommand(42); !strings.Contains(cmd, "--ppid 42") || !strings.Contains(cmd, "kill 42") {
+		t.Errorf("Bad stop command for custom template: %s", cmd)
+	}
+
+	if err := ValidatePrivilege("gibberish"); err == nil {
+		t.Errorf("Expected error for unknown method")
+	}
+}
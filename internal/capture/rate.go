@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package capture
+
+import (
+	"sync"
+	"time"
+)
+
+// rateMeter measures the throughput during the last complete second
+type rateMeter struct {
+	mut      sync.Mutex
+	second   int64  // the current second (unix time)
+	current  uint64 // bytes received during the current second
+	previous uint64 // bytes received during the previous second
+}
+
+// add records n bytes, received at now
+func (r *rateMeter) add(now time.Time, n int) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.advance(now)
+	r.current += uint64(n)
+}
+
+// rate returns the bytes received during the last complete second before now
+func (r *rateMeter) rate(now time.Time) uint64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.advance(now)
+	return r.previous
+}
+
+// advance moves the current second to now. Should be called with mut locked.
+func (r *rateMeter) advance(now time.Time) {
+	sec := now.Unix()
+	switch {
+	case sec == r.second:
+		return
+	case sec == r.second+1:
+		r.previous = r.current
+	default:
+		r.previous = 0
+	}
+
+	r.second = sec
+	r.current = 0
+}
@@ -0,0 +1,35 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	var r rateMeter
+	start := time.Unix(1000, 0)
+
+	r.add(start, 100)
+	r.add(start.Add(500*time.Millisecond), 50)
+	if rate := r.rate(start.Add(900 * time.Millisecond)); rate != 0 {
+		t.Errorf("Expected 0 during the first second, got %d", rate)
+	}
+
+	r.add(start.Add(1200*time.Millisecond), 10)
+	if rate := r.rate(start.Add(1500 * time.Millisecond)); rate != 150 {
+		t.Errorf("Expected 150, got %d", rate)
+	}
+
+	if rate := r.rate(start.Add(2100 * time.Millisecond)); rate != 10 {
+		t.Errorf("Expected 10, got %d", rate)
+	}
+
+	// No data for more than a second
+	if rate := r.rate(start.Add(5 * time.Second)); rate != 0 {
+		t.Errorf("Expected 0 after a pause, got %d", rate)
+	}
+}
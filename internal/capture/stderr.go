@@ -5,6 +5,7 @@
 package capture
 
 import (
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +25,9 @@ func cmdGetPid() string {
 	return "TRANQAP_MY_PID=$! ; echo " + pidPrefix + " $TRANQAP_MY_PID >&2 ; wait $TRANQAP_MY_PID"
 }
 
+// kernelDropsRe matches the statistics line, printed by tcpdump on exit
+var kernelDropsRe = regexp.MustCompile(`(\d+) packets? dropped by kernel`)
+
 // stdErrHandler parses PID of the Capturer from stderr and saves all stderr messages in a string slice
 // If needed these messages are dumped to the user
 type stdErrHandler struct {
@@ -89,3 +93,19 @@ func (pw *stdErrHandler) DumpStdErr() string {
 
 	return out.String()
 }
+
+// kernelDrops returns the number of packets dropped by the kernel, reported by
+// tcpdump on stderr, or 0 if nothing is reported yet
+func (pw *stdErrHandler) kernelDrops() uint64 {
+	matches := kernelDropsRe.FindAllStringSubmatch(pw.DumpStdErr(), -1)
+	if len(matches) == 0 {
+		return 0
+	}
+
+	ret, err := strconv.ParseUint(matches[len(matches)-1][1], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return ret
+}
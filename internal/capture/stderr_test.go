@@ -50,3 +50,19 @@ func TestPidSMalformedPrefix(t *testing.T) {
 		t.Errorf("Expected value -1, but received %d\n", pid)
 	}
 }
+
+func TestKernelDrops(t *testing.T) {
+	inst := newStdErrHandler()
+
+	if drops := inst.kernelDrops(); drops != 0 {
+		t.Errorf("Expected 0 drops before the statistics, got %d\n", drops)
+	}
+
+	inst.Write([]byte("tcpdump: listening on any, link-type LINUX_SLL\n"))
+	inst.Write([]byte("120 packets captured\n130 packets received by filter\n1"))
+	inst.Write([]byte("0 packets dropped by kernel\n"))
+
+	if drops := inst.kernelDrops(); drops != 10 {
+		t.Errorf("Expected 10 drops, got %d\n", drops)
+	}
+}
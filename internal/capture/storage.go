@@ -6,7 +6,9 @@ package capture
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/output"
 	"github.com/tdimitrov/tranqap/internal/tqlog"
@@ -19,6 +21,47 @@ type Storage struct {
 	events          CapturerEventChan
 	wg              sync.WaitGroup
 	handlerFinished chan struct{}
+	died            []string
+	totals          map[string]*targetTotals
+}
+
+// Metrics contains the counters of all captures of a target since the Storage
+// was created. Running is true if there is a Capturer for the target. Restarts
+// is the number of captures started after the previous capture of the target
+// has died. Throughput is the throughput of the running capture.
+type Metrics struct {
+	Name          string
+	Running       bool
+	Starts        uint64
+	Restarts      uint64
+	Deaths        uint64
+	Bytes         uint64
+	Packets       uint64
+	Throughput    uint64
+	LastData      time.Time
+	OutputerDrops uint64
+	KernelDrops   uint64
+	FileRotations uint64
+}
+
+// targetTotals contains the counters of the finished captures of a target
+type targetTotals struct {
+	Metrics
+	lastDied bool
+}
+
+// add adds the final counters of a capture
+func (t *targetTotals) add(s Status) {
+	t.Bytes += s.Bytes
+	t.Packets += s.Output.Packets
+	t.OutputerDrops += s.Output.Dropped
+	t.KernelDrops += s.KernelDrops
+	for _, m := range s.Output.Members {
+		t.FileRotations += m.Rotations
+	}
+	if s.LastData.After(t.LastData) {
+		t.LastData = s.LastData
+	}
 }
 
 // NewStorage creates a Storage instance
@@ -29,6 +72,8 @@ func NewStorage() *Storage {
 		make(CapturerEventChan, 1),
 		sync.WaitGroup{},
 		make(chan struct{}, 1),
+		nil,
+		make(map[string]*targetTotals),
 	}
 
 	go ret.eventHandler()
@@ -54,9 +99,27 @@ func (c *Storage) Add(newCapt Capturer) error {
 	c.capturers[newCapt.Name()] = newCapt
 	c.wg.Add(1)
 
+	totals := c.getTotals(newCapt.Name())
+	totals.Starts++
+	if totals.lastDied == true {
+		totals.Restarts++
+		totals.lastDied = false
+	}
+
 	return nil
 }
 
+// getTotals returns the counters of a target. Should be called with mut locked.
+func (c *Storage) getTotals(name string) *targetTotals {
+	totals, ok := c.totals[name]
+	if !ok {
+		totals = &targetTotals{Metrics: Metrics{Name: name}}
+		c.totals[name] = totals
+	}
+
+	return totals
+}
+
 // StopAll calls Stop() on each Capturer in the container
 func (c *Storage) StopAll() {
 	if c == nil {
@@ -79,6 +142,39 @@ func (c *Storage) StopAll() {
 
 }
 
+// Stop calls Stop() on the Capturers for the targets
+func (c *Storage) Stop(targets []string) {
+	tqlog.Info("capture.Storage: Calling Stop for %v", targets)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for _, t := range targets {
+		capt, ok := c.capturers[t]
+		if !ok {
+			errMsg := fmt.Sprintf("There is no running capture for target <%s>.\n", t)
+			tqlog.Feedback(errMsg)
+			tqlog.Error(errMsg)
+			continue
+		}
+
+		if err := capt.Stop(); err != nil {
+			errMsg := fmt.Sprintf("Can't stop %s. %s", capt.Name(), err)
+			tqlog.Error(errMsg)
+			tqlog.Feedback(errMsg)
+		}
+	}
+}
+
+// Running returns true if there is a Capturer for the target in the storage
+func (c *Storage) Running(target string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	_, exists := c.capturers[target]
+	return exists
+}
+
 // Close terminates the event handler routine
 func (c *Storage) Close() {
 	tqlog.Info("Terminating storage")
@@ -129,7 +225,7 @@ func (c *Storage) AddNewOutput(factFn output.OutputerFactory, targets []string)
 					tqlog.Error("Error adding Outputer to capturer %s", capt.Name())
 				}
 			} else {
-				errMsg := fmt.Sprintf("Target <%s> doesn't exist.\n", t)
+				errMsg := fmt.Sprintf("There is no running capture for target <%s>.\n", t)
 				tqlog.Feedback(errMsg)
 				tqlog.Error(errMsg)
 			}
@@ -145,6 +241,81 @@ func (c *Storage) Empty() bool {
 	return len(c.capturers) == 0
 }
 
+// Names returns the sorted names of the Capturers in the storage
+func (c *Storage) Names() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ret := make([]string, 0, len(c.capturers))
+	for name := range c.capturers {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// Status returns the status of each Capturer in the storage, sorted by name
+func (c *Storage) Status() []Status {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ret := make([]Status, 0, len(c.capturers))
+	for _, capt := range c.capturers {
+		ret = append(ret, capt.Status())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return ret
+}
+
+// Mark inserts a marker in the output of each Capturer in the storage. Returns
+// the error for each Capturer by name. The error is nil if the marker is inserted.
+func (c *Storage) Mark(t time.Time, text string) map[string]error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ret := make(map[string]error)
+	for name, capt := range c.capturers {
+		ret[name] = capt.Mark(t, text)
+	}
+
+	return ret
+}
+
+// Died returns the names of the Capturers, which have stopped unexpectedly, in
+// the order they died
+func (c *Storage) Died() []string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return append([]string{}, c.died...)
+}
+
+// Metrics returns the counters of each target, which has been captured on,
+// sorted by name. The counters of the running captures are included.
+func (c *Storage) Metrics() []Metrics {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ret := make([]Metrics, 0, len(c.totals))
+	for name, totals := range c.totals {
+		m := totals.Metrics
+		if capt, ok := c.capturers[name]; ok {
+			running := targetTotals{Metrics: m}
+			s := capt.Status()
+			running.add(s)
+			m = running.Metrics
+			m.Running = true
+			m.Throughput = s.Throughput
+		}
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return ret
+}
+
 func (c *Storage) eventHandler() {
 	defer func() { c.handlerFinished <- struct{}{} }()
 
@@ -152,7 +323,16 @@ func (c *Storage) eventHandler() {
 	for e := range c.events {
 		tqlog.Info("Storage: got an event from %s", e.from)
 		c.mut.Lock()
+		totals := c.getTotals(e.from)
+		if capt, ok := c.capturers[e.from]; ok {
+			totals.add(capt.Status())
+		}
 		delete(c.capturers, e.from)
+		totals.lastDied = e.event == CapturerDead
+		if e.event == CapturerDead {
+			c.died = append(c.died, e.from)
+			totals.Deaths++
+		}
 		tqlog.Info("Storage: Removed %s", e.from)
 		c.wg.Done()
 		c.mut.Unlock()
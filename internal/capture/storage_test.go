@@ -5,7 +5,9 @@
 package capture
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/output"
 )
@@ -37,6 +39,18 @@ func (capt capturerMock) Name() string {
 	return capt.name
 }
 
+func (capt capturerMock) Status() Status {
+	return Status{Name: capt.name, State: StateRunning, PID: 1, Bytes: 100, Output: output.MultiOutputStats{Packets: 2,
+		Members: []output.MemberInfo{{Kind: output.KindFile, Rotations: 1}}}}
+}
+
+func (capt capturerMock) Mark(t time.Time, text string) error {
+	if capt.isStarted == false {
+		return fmt.Errorf("%s is not started", capt.name)
+	}
+	return nil
+}
+
 func TestStorageAdd(t *testing.T) {
 	storage := NewStorage()
 
@@ -88,3 +102,117 @@ func TestStorageStopAll(t *testing.T) {
 		t.Errorf("Error occurred during StopAll(). There are still %d capturers in the storage\n", cnt)
 	}
 }
+
+func TestStorageStop(t *testing.T) {
+	storage := NewStorage()
+
+	capt := newCapturerMock()
+	storage.Add(capt)
+
+	if storage.Running(capt.Name()) == false {
+		t.Errorf("Capturer should be running\n")
+	}
+
+	// Unknown targets are ignored
+	storage.Stop([]string{"Unknown"})
+	if capt.isStarted == false {
+		t.Errorf("Capturer is stopped, but it was not selected\n")
+	}
+
+	storage.Stop([]string{capt.Name()})
+	if capt.isStarted == true {
+		t.Errorf("Selected capturer is not stopped\n")
+	}
+
+	storage.GetChan() <- CapturerEvent{capt.Name(), CapturerStopped}
+	storage.Close()
+
+	if storage.Running(capt.Name()) == true {
+		t.Errorf("Capturer should not be running after stop\n")
+	}
+}
+
+func TestStorageDied(t *testing.T) {
+	storage := NewStorage()
+
+	stopped := &capturerMock{true, "stopped"}
+	dead := &capturerMock{true, "dead"}
+	storage.Add(stopped)
+	storage.Add(dead)
+
+	storage.GetChan() <- CapturerEvent{dead.Name(), CapturerDead}
+	storage.GetChan() <- CapturerEvent{stopped.Name(), CapturerStopped}
+	storage.Close()
+
+	died := storage.Died()
+	if len(died) != 1 || died[0] != dead.Name() {
+		t.Errorf("Expected only %s to be dead, got %v\n", dead.Name(), died)
+	}
+}
+
+func TestStorageStatus(t *testing.T) {
+	storage := NewStorage()
+
+	storage.Add(&capturerMock{true, "b"})
+	storage.Add(&capturerMock{true, "a"})
+
+	status := storage.Status()
+	if len(status) != 2 || status[0].Name != "a" || status[1].Name != "b" {
+		t.Errorf("Expected status of a and b, got %v\n", status)
+	}
+
+	storage.GetChan() <- CapturerEvent{"a", CapturerStopped}
+	storage.GetChan() <- CapturerEvent{"b", CapturerStopped}
+	storage.Close()
+}
+
+func TestStorageMetrics(t *testing.T) {
+	storage := NewStorage()
+
+	storage.Add(&capturerMock{true, "a"})
+	storage.GetChan() <- CapturerEvent{"a", CapturerDead}
+	for i := 0; i < 100 && storage.Running("a"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Started again after it has died
+	storage.Add(&capturerMock{true, "a"})
+
+	metrics := storage.Metrics()
+	if len(metrics) != 1 {
+		t.Fatalf("Expected metrics for a single target, got %v\n", metrics)
+	}
+
+	m := metrics[0]
+	if m.Name != "a" || m.Running != true || m.Starts != 2 || m.Restarts != 1 || m.Deaths != 1 {
+		t.Errorf("Bad metrics %+v\n", m)
+	}
+	if m.Bytes != 200 || m.Packets != 4 || m.FileRotations != 2 {
+		t.Errorf("Expected the counters of both captures, got %d bytes, %d packets and %d rotations\n",
+			m.Bytes, m.Packets, m.FileRotations)
+	}
+
+	storage.GetChan() <- CapturerEvent{"a", CapturerStopped}
+	storage.Close()
+
+	m = storage.Metrics()[0]
+	if m.Running != false || m.Starts != 2 || m.Deaths != 1 || m.Bytes != 200 || m.FileRotations != 2 {
+		t.Errorf("Bad metrics after stop %+v\n", m)
+	}
+}
+
+func TestStorageMark(t *testing.T) {
+	storage := NewStorage()
+
+	storage.Add(&capturerMock{true, "a"})
+	storage.Add(&capturerMock{false, "b"})
+
+	res := storage.Mark(time.Now(), "mark")
+	if len(res) != 2 || res["a"] != nil || res["b"] == nil {
+		t.Errorf("Expected mark only for a, got %v\n", res)
+	}
+
+	storage.GetChan() <- CapturerEvent{"a", CapturerStopped}
+	storage.GetChan() <- CapturerEvent{"b", CapturerStopped}
+	storage.Close()
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/output"
 	"github.com/tdimitrov/tranqap/internal/tqlog"
@@ -16,30 +18,30 @@ import (
 type captureTransport interface {
 	IsActive() bool
 	Connect() error
-	Run(cmd string, stdout io.Writer, stderr io.Writer) error
+	Close() error
+	Run(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error
 	GetRemoteIP() *string
 	GetRemotePort() *int
 }
 
 // Tcpdump is Capturer implementation for tcpdump
 type Tcpdump struct {
+	lastData   int64  // unix nanoseconds, accessed atomically. First field for 64-bit alignment
+	received   uint64 // bytes received from the target, accessed atomically
 	name       string
 	captureCmd string
 	pid        *stdErrHandler
 	out        *output.MultiOutput
 	onDie      CapturerEventChan
 	trans      captureTransport
-	useSudo    bool
+	privilege  PrivilegeConfig
 	filter     FilterConfig
-}
-
-// SudoConfig contains config params regarding sudo usage.
-// Use is a bool which indicates if tcpdump should be started with sudo
-// Username is pointer to a string with the username, which tcpdump will use
-// to drop privilege to (-Z option ). If UseSudo is false, Username is nil
-type SudoConfig struct {
-	Use      bool
-	Username *string
+	options    CaptureConfig
+	watchdog   WatchdogConfig
+	stalled    int32 // accessed atomically
+	stopping   int32 // accessed atomically
+	started    time.Time
+	rate       *rateMeter
 }
 
 // FilterConfig contains Port, which is set as tcpdump capture
@@ -47,38 +49,107 @@ type SudoConfig struct {
 // accessed via port and/or IP redirection. If so, the port used to connect
 // to the target will differ from the actual on which the SSH service is
 // bound.
+// Expression is an additional capture filter in pcap-filter syntax. The
+// traffic of the SSH session is always excluded.
 type FilterConfig struct {
-	Port *int
+	Port       *int
+	Expression *string
+}
+
+// CaptureConfig contains tcpdump options. Interfaces is the list of interfaces
+// to capture on. tcpdump supports a single interface, so it can contain at most
+// one element. Empty list means any interface. Snaplen is the number of bytes
+// captured from each packet. Zero means the whole packet.
+type CaptureConfig struct {
+	Interfaces []string
+	Snaplen    int
+}
+
+// ValidateCapture returns an error if the options can't be used with tcpdump
+func (c CaptureConfig) ValidateCapture() error {
+	if len(c.Interfaces) > 1 {
+		return fmt.Errorf("tcpdump can capture on a single interface or on any. Got %s", strings.Join(c.Interfaces, ", "))
+	}
+	if c.Snaplen < 0 {
+		return fmt.Errorf("Invalid snaplen %d", c.Snaplen)
+	}
+
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// captureCommand returns the tcpdump command. It contains %d for the port,
+// which is excluded from the capture.
+func captureCommand(filter FilterConfig, options CaptureConfig) string {
+	iface := "any"
+	if len(options.Interfaces) == 1 {
+		iface = options.Interfaces[0]
+	}
+
+	expr := "not port %d"
+	if filter.Expression != nil && len(strings.TrimSpace(*filter.Expression)) > 0 {
+		userExpr := strings.Replace(*filter.Expression, "%", "%%", -1)
+		expr = "(" + userExpr + ") and " + expr
+	}
+
+	return fmt.Sprintf("tcpdump -U -s%d -i %s -w - %s",
+		options.Snaplen, strings.Replace(shellQuote(iface), "%", "%%", -1), shellQuote(expr))
+}
+
+// WatchdogConfig contains StallTimeout - the maximal period without any data
+// from the capturer, after which the session is considered dead. This is useful
+// for targets which are expected to have got constant traffic. Silently dropped
+// network path is detected this way, even if the SSH connection doesn't report
+// an error. Zero value disables the watchdog.
+type WatchdogConfig struct {
+	StallTimeout time.Duration
 }
 
-// NewTcpdump creates Tcpdump Capturer
-func NewTcpdump(name string, outer *output.MultiOutput, subsc CapturerEventChan, trans captureTransport, sudo SudoConfig, filter FilterConfig) Capturer {
-	const sudoCmd = "sudo -n "
-	const captureCmd = "tcpdump -U -s0 -i any -w - 'not port %d'"
+// NewTcpdump creates Tcpdump Capturer. Returns nil if the privilege configuration or
+// the capture options are invalid.
+func NewTcpdump(name string, outer *output.MultiOutput, subsc CapturerEventChan, trans captureTransport, privilege PrivilegeConfig, filter FilterConfig, options CaptureConfig, watchdog WatchdogConfig) Capturer {
 	const dropPrivileges = " -Z "
 	const runInBackground = " & "
 
-	var cmd strings.Builder
-	if sudo.Use == true {
-		cmd.WriteString(sudoCmd)
+	if err := options.ValidateCapture(); err != nil {
+		tqlog.Error("Can't create capturer %s: %s", name, err)
+		return nil
 	}
-	cmd.WriteString(captureCmd)
-	if sudo.Use == true {
+
+	var cmd strings.Builder
+	cmd.WriteString(captureCommand(filter, options))
+	if privilege.Elevated() {
 		cmd.WriteString(dropPrivileges)
-		cmd.WriteString(*sudo.Username)
+		cmd.WriteString(*privilege.Username)
+	}
+
+	wrapped, err := privilege.WrapCommand(cmd.String())
+	if err != nil {
+		tqlog.Error("Can't create capturer %s: %s", name, err)
+		return nil
 	}
-	cmd.WriteString(runInBackground)
-	cmd.WriteString(cmdGetPid())
 
 	return &Tcpdump{
+		0,
+		0,
 		name,
-		cmd.String(),
+		wrapped + runInBackground + cmdGetPid(),
 		newStdErrHandler(),
 		outer,
 		subsc,
 		trans,
-		sudo.Use,
+		privilege,
 		filter,
+		options,
+		watchdog,
+		0,
+		0,
+		time.Time{},
+		&rateMeter{},
 	}
 }
 
@@ -93,6 +164,7 @@ func (capt *Tcpdump) Start() error {
 		return fmt.Errorf("Error connecting to %s: %s", capt.Name(), err)
 	}
 
+	capt.started = time.Now()
 	go capt.startSession()
 
 	tqlog.Info("Connected to %s and started a session.", capt.Name())
@@ -105,17 +177,11 @@ func (capt *Tcpdump) Stop() error {
 	pid := capt.pid.GetPid()
 	// Clear PID to indicate an expected kill
 	capt.pid.ClearPid()
+	atomic.StoreInt32(&capt.stopping, 1)
 
-	var cmd string
-	if capt.useSudo == true {
-		// the sudo process runs as root and it can't be killed with a regular user
-		// that's why the child process is killed
-		cmd = fmt.Sprintf("kill `ps --ppid %d -o pid=`", pid)
-	} else {
-		cmd = fmt.Sprintf("kill %d", pid)
-	}
+	cmd := capt.privilege.stopCommand(pid)
 
-	err := capt.trans.Run(cmd, nil, nil)
+	err := capt.trans.Run(cmd, nil, nil, nil)
 	if err != nil {
 		return fmt.Errorf("Error running kill command: %s", err)
 	}
@@ -149,7 +215,27 @@ func (capt *Tcpdump) startSession() {
 	cmd := fmt.Sprintf(capt.captureCmd, *port)
 
 	// Run capturer
-	err = capt.trans.Run(cmd, capt.out, capt.pid)
+	atomic.StoreInt64(&capt.lastData, time.Now().UnixNano())
+	sessionDone := make(chan struct{})
+	if capt.watchdog.StallTimeout > 0 {
+		go capt.stallWatchdog(sessionDone)
+	}
+
+	var stdin io.Reader
+	if capt.privilege.Password != nil {
+		// The privilege escalation method reads the password from stdin
+		stdin = strings.NewReader(*capt.privilege.Password + "\n")
+	}
+
+	err = capt.trans.Run(cmd, stdin, activityWriter{capt}, capt.pid)
+	close(sessionDone)
+
+	if atomic.LoadInt32(&capt.stalled) == 1 {
+		tqlog.Feedback("Capturer %s died. No data received for %s.\n", capt.Name(), capt.watchdog.StallTimeout)
+		capt.onDie <- CapturerEvent{capt.Name(), CapturerDead}
+		return
+	}
+
 	if err != nil {
 		tqlog.Error("Session error for %s. Can't run tcpdump command: %s", capt.Name(), err)
 		capt.onDie <- CapturerEvent{capt.Name(), CapturerDead}
@@ -170,6 +256,89 @@ func (capt *Tcpdump) startSession() {
 	return
 }
 
+// stallWatchdog closes the transport if no data is received from the capturer
+// for StallTimeout. This terminates the session and the capturer is reported as dead.
+func (capt *Tcpdump) stallWatchdog(done chan struct{}) {
+	ticker := time.NewTicker(capt.watchdog.StallTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&capt.lastData))
+		if time.Since(last) < capt.watchdog.StallTimeout {
+			continue
+		}
+
+		// The stall is measured from the start of the session, so a connection
+		// which hangs before the PID of tcpdump is received is detected too
+		if atomic.LoadInt32(&capt.stopping) == 1 {
+			// Stopped by command - the session is about to finish
+			continue
+		}
+
+		tqlog.Error("Session error for %s. No data received since %s. Closing the session.",
+			capt.Name(), last.Format(time.RFC3339))
+		atomic.StoreInt32(&capt.stalled, 1)
+		if err := capt.trans.Close(); err != nil {
+			tqlog.Error("Error closing transport for %s: %s", capt.Name(), err)
+		}
+		return
+	}
+}
+
+// activityWriter forwards the capturer output to the MultiOutput and records
+// the time of the last received data for the stall watchdog
+type activityWriter struct {
+	capt *Tcpdump
+}
+
+func (w activityWriter) Write(p []byte) (n int, err error) {
+	now := time.Now()
+	atomic.StoreInt64(&w.capt.lastData, now.UnixNano())
+	atomic.AddUint64(&w.capt.received, uint64(len(p)))
+	w.capt.rate.add(now, len(p))
+	return w.capt.out.Write(p)
+}
+
+// Status returns the state and the counters of the capture
+func (capt *Tcpdump) Status() Status {
+	pid := capt.pid.GetPid()
+
+	state := StateRunning
+	if atomic.LoadInt32(&capt.stopping) == 1 {
+		state = StateStopping
+	} else if pid == -1 {
+		state = StateStarting
+	}
+
+	var lastData time.Time
+	if bytes := atomic.LoadUint64(&capt.received); bytes > 0 {
+		lastData = time.Unix(0, atomic.LoadInt64(&capt.lastData))
+	}
+
+	return Status{
+		capt.name,
+		state,
+		capt.started,
+		pid,
+		atomic.LoadUint64(&capt.received),
+		capt.rate.rate(time.Now()),
+		lastData,
+		capt.pid.kernelDrops(),
+		capt.out.Stats(),
+	}
+}
+
+// Mark inserts a marker packet in the output of the capture
+func (capt *Tcpdump) Mark(t time.Time, text string) error {
+	return capt.out.Mark(t, text)
+}
+
 // Name returns the name of the capturer's target (used only for logging purposes)
 func (capt *Tcpdump) Name() string {
 	return capt.name
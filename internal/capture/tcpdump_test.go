@@ -7,13 +7,18 @@ package capture
 import (
 	"fmt"
 	"io"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/output"
 )
 
+// outputMock is closed by the session goroutine after the capturer event is
+// sent, so isClosed waits for Close()
 type outputMock struct {
-	isClosed bool
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func (out *outputMock) Write(p []byte) (n int, err error) {
@@ -21,7 +26,16 @@ func (out *outputMock) Write(p []byte) (n int, err error) {
 }
 
 func (out *outputMock) Close() {
-	out.isClosed = true
+	out.closeOnce.Do(func() { close(out.closed) })
+}
+
+func (out *outputMock) isClosed() bool {
+	select {
+	case <-out.closed:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
 }
 
 type transportMock struct {
@@ -29,6 +43,15 @@ type transportMock struct {
 	failOnRun     bool
 	failOnConnect bool
 	finish        chan struct{}
+	closedMut     sync.Mutex
+	closed        bool
+}
+
+func (trans *transportMock) isClosed() bool {
+	trans.closedMut.Lock()
+	defer trans.closedMut.Unlock()
+
+	return trans.closed
 }
 
 func (trans *transportMock) IsActive() bool {
@@ -44,6 +67,14 @@ func (trans *transportMock) Connect() error {
 	return nil
 }
 
+func (trans *transportMock) Close() error {
+	trans.closedMut.Lock()
+	trans.closed = true
+	trans.closedMut.Unlock()
+	trans.finish <- struct{}{}
+	return nil
+}
+
 func (trans *transportMock) GetRemoteIP() *string {
 	ret := "127.0.0.1"
 	return &ret
@@ -54,21 +85,28 @@ func (trans *transportMock) GetRemotePort() *int {
 	return &ret
 }
 
-func (trans *transportMock) Run(cmd string, stdout io.Writer, stderr io.Writer) error {
+func (trans *transportMock) Run(cmd string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
 	if trans.failOnRun == true {
 		return fmt.Errorf("Something went wrong")
 	}
 
 	<-trans.finish
+	if trans.isClosed() == true {
+		return fmt.Errorf("Connection closed")
+	}
 	return nil
 }
 
 func createTestInstances() (CapturerEventChan, *transportMock, Capturer, *outputMock) {
+	return createTestInstancesWithWatchdog(WatchdogConfig{})
+}
+
+func createTestInstancesWithWatchdog(watchdog WatchdogConfig) (CapturerEventChan, *transportMock, Capturer, *outputMock) {
 	events := make(CapturerEventChan)
-	trans := transportMock{false, false, false, make(chan struct{}, 1)}
-	out := &outputMock{false}
+	trans := transportMock{false, false, false, make(chan struct{}, 1), sync.Mutex{}, false}
+	out := &outputMock{make(chan struct{}), sync.Once{}}
 
-	inst := NewTcpdump("Test Instance", output.NewMultiOutput(out), events, &trans, SudoConfig{false, nil}, FilterConfig{nil})
+	inst := NewTcpdump("Test Instance", output.NewMultiOutput(out), events, &trans, PrivilegeConfig{PrivilegeNone, nil, nil}, FilterConfig{nil, nil}, CaptureConfig{}, watchdog)
 
 	return events, &trans, inst, out
 }
@@ -94,7 +132,7 @@ func TestTcpdumpStop(t *testing.T) {
 		t.Errorf("Got wrong event type")
 	}
 
-	if out.isClosed == false {
+	if out.isClosed() == false {
 		t.Errorf("Outputer is not closed")
 	}
 }
@@ -120,7 +158,7 @@ func TestTcpdumpDie(t *testing.T) {
 		t.Errorf("Got wrong event type")
 	}
 
-	if out.isClosed == false {
+	if out.isClosed() == false {
 		t.Errorf("Outputer is not closed")
 	}
 }
@@ -144,7 +182,7 @@ func TestTcpdumpFailOnRun(t *testing.T) {
 		t.Errorf("Got wrong event type")
 	}
 
-	if out.isClosed == false {
+	if out.isClosed() == false {
 		t.Errorf("Outputer is not closed")
 	}
 }
@@ -158,7 +196,53 @@ func TestTcpdumpFailOnConnect(t *testing.T) {
 		t.Errorf("Start() should return error\n")
 	}
 
-	if out.isClosed == false {
+	if out.isClosed() == false {
 		t.Errorf("Outputer is not closed")
 	}
 }
+
+func TestTcpdumpStall(t *testing.T) {
+	events, trans, inst, out := createTestInstancesWithWatchdog(WatchdogConfig{20 * time.Millisecond})
+
+	if inst.Start() != nil {
+		t.Errorf("Unexpected Start() failure\n")
+	}
+
+	// No data is written and no PID is received from the mock transport. The
+	// connection is considered stalled before tcpdump has started.
+	ev := <-events
+
+	if trans.isClosed() == false {
+		t.Errorf("Transport is not closed by the watchdog")
+	}
+
+	if ev.event != CapturerDead {
+		t.Errorf("Got wrong event type")
+	}
+
+	if out.isClosed() == false {
+		t.Errorf("Outputer is not closed")
+	}
+}
+
+func TestCaptureCommand(t *testing.T) {
+	cmd := captureCommand(FilterConfig{nil, nil}, CaptureConfig{})
+	if cmd != "tcpdump -U -s0 -i 'any' -w - 'not port %d'" {
+		t.Errorf("Unexpected default command: %s", cmd)
+	}
+
+	expr := "udp port 5060 and host '10.0.0.1' and len > 100%"
+	cmd = captureCommand(FilterConfig{nil, &expr}, CaptureConfig{[]string{"eth0"}, 96})
+	expected := `tcpdump -U -s96 -i 'eth0' -w - '(udp port 5060 and host '\''10.0.0.1'\'' and len > 100%%) and not port %d'`
+	if cmd != expected {
+		t.Errorf("Unexpected command: %s", cmd)
+	}
+
+	if fmt.Sprintf(cmd, 22) != `tcpdump -U -s96 -i 'eth0' -w - '(udp port 5060 and host '\''10.0.0.1'\'' and len > 100%) and not port 22'` {
+		t.Errorf("Unexpected formatted command: %s", fmt.Sprintf(cmd, 22))
+	}
+
+	if err := (CaptureConfig{[]string{"eth0", "eth1"}, 0}).ValidateCapture(); err == nil {
+		t.Errorf("Expected error for more than one interface")
+	}
+}
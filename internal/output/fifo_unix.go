@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"syscall"
+)
+
+// nonBlockFlag opens a named pipe without waiting for the other end
+const nonBlockFlag = syscall.O_NONBLOCK
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
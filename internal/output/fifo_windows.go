@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"errors"
+)
+
+const nonBlockFlag = 0
+
+func mkfifo(path string) error {
+	return errors.New("Named pipes are not supported on Windows")
+}
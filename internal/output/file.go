@@ -11,22 +11,40 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/tdimitrov/tranqap/internal/tqlog"
 )
 
+// fileOutput writes the capture to a file. rotations is the number of times the
+// capture files were rotated by the outputer. It is accessed atomically.
 type fileOutput struct {
-	fd *os.File
+	fd        *os.File
+	rotations uint64
 }
 
 // NewFileOutput constructs fileOutput object
 func NewFileOutput(destDir string, filePattern string, rotationCnt int) Outputer {
-	fd, err := openFile(destDir, filePattern, rotationCnt)
+	fd, rotated, err := openFile(destDir, filePattern, rotationCnt)
 	if err != nil {
 		return nil
 	}
 
-	return &fileOutput{fd}
+	ret := &fileOutput{fd, 0}
+	if rotated == true {
+		ret.rotated()
+	}
+
+	return ret
+}
+
+// rotated counts a rotation of the capture files
+func (pw *fileOutput) rotated() {
+	atomic.AddUint64(&pw.rotations, 1)
+}
+
+func (pw *fileOutput) rotationCount() uint64 {
+	return atomic.LoadUint64(&pw.rotations)
 }
 
 func (pw fileOutput) Write(p []byte) (n int, err error) {
@@ -39,15 +57,21 @@ func (pw fileOutput) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func (pw *fileOutput) describe() MemberInfo {
+	return MemberInfo{Kind: KindFile, Path: pw.fd.Name()}
+}
+
 func (pw *fileOutput) Close() {
 	pw.fd.Close()
 }
 
-func openFile(destDir string, filePattern string, rotationCnt int) (*os.File, error) {
+// openFile creates the capture file. If the file exists, it is rotated first.
+// Returns true if the existing files were rotated.
+func openFile(destDir string, filePattern string, rotationCnt int) (*os.File, bool, error) {
 	// If destination dir doesn't exist - create it
 	err := prepareDestDir(destDir)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	filePath := destDir + "/" + filePattern + ".pcap"
@@ -57,9 +81,9 @@ func openFile(destDir string, filePattern string, rotationCnt int) (*os.File, er
 		fd, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0755)
 		if err != nil {
 			tqlog.Error("Error opening file: %s", err)
-			return nil, err
+			return nil, false, err
 		}
-		return fd, err
+		return fd, false, err
 	}
 
 	// Split filename and extenson
@@ -72,7 +96,7 @@ func openFile(destDir string, filePattern string, rotationCnt int) (*os.File, er
 		err := os.Remove(lastFile)
 		if err != nil {
 			tqlog.Error("Error removing %v during file rotation: %v\n", lastFile, err)
-			return nil, err
+			return nil, false, err
 		}
 	}
 
@@ -102,10 +126,10 @@ func openFile(destDir string, filePattern string, rotationCnt int) (*os.File, er
 	fd, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0755)
 	if err != nil {
 		tqlog.Error("Error creating file: %s", err)
-		return nil, err
+		return nil, false, err
 	}
 
-	return fd, nil
+	return fd, true, nil
 }
 
 func fileExists(path string) bool {
@@ -108,12 +108,16 @@ func TestOutputOpenFile(t *testing.T) {
 
 	// On iteration 0, the destination DIR is empty, new file is created
 	for i := 0; i < loopRange; i++ {
-		f, err := openFile(dir, filepattern, rotationCount)
+		f, rotated, err := openFile(dir, filepattern, rotationCount)
 		if err != nil {
 			t.Errorf("Error opening file on iteration %d: %s", i, err)
 		}
 		defer f.Close()
 
+		if rotated != (i > 0) {
+			t.Errorf("Unexpected rotated %v on iteration %d", rotated, i)
+		}
+
 		expectedFName := fmt.Sprintf("%s/%s.pcap", dir, filepattern)
 		if _, err := os.Stat(expectedFName); os.IsNotExist(err) {
 			t.Errorf("Error on iteration %d: %s doesn't exist", i, expectedFName)
@@ -0,0 +1,139 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The streams from tcpdump are in PCAP format, which doesn't support comments.
+// A mark is inserted as a synthetic UDP packet from 127.0.0.1 to 127.0.0.1 on
+// MarkerPort. The payload is MarkerPrefix followed by the text of the mark. The
+// markers can be found in Wireshark with "udp.port == 9 && frame contains TRANQAP".
+const (
+	MarkerPort   = 9 // discard
+	MarkerPrefix = "TRANQAP MARK: "
+)
+
+// maxMarkerText is the maximal length of the text in a marker packet
+const maxMarkerText = 1024
+
+// Link types, for which a marker can be generated
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLoop     = 108
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeSLL2     = 276
+)
+
+// linkHeader returns the link layer header of an IPv4 packet for the link type
+func linkHeader(linkType uint32, order binary.ByteOrder) ([]byte, error) {
+	switch linkType {
+	case linkTypeNull:
+		// AF_INET in the byte order of the capturing host
+		hdr := make([]byte, 4)
+		order.PutUint32(hdr, 2)
+		return hdr, nil
+	case linkTypeLoop:
+		return []byte{0, 0, 0, 2}, nil
+	case linkTypeEthernet:
+		hdr := make([]byte, 14)
+		binary.BigEndian.PutUint16(hdr[12:14], 0x0800)
+		return hdr, nil
+	case linkTypeRaw, linkTypeIPv4:
+		return []byte{}, nil
+	case linkTypeLinuxSLL:
+		hdr := make([]byte, 16)
+		binary.BigEndian.PutUint16(hdr[0:2], 4)   // sent by us
+		binary.BigEndian.PutUint16(hdr[2:4], 772) // ARPHRD_LOOPBACK
+		binary.BigEndian.PutUint16(hdr[14:16], 0x0800)
+		return hdr, nil
+	case linkTypeSLL2:
+		hdr := make([]byte, 20)
+		binary.BigEndian.PutUint16(hdr[0:2], 0x0800)
+		binary.BigEndian.PutUint16(hdr[8:10], 772) // ARPHRD_LOOPBACK
+		hdr[10] = 4                                // sent by us
+		return hdr, nil
+	}
+
+	return nil, fmt.Errorf("Marks are not supported for link type %d", linkType)
+}
+
+// ipv4Checksum returns the checksum of an IPv4 header
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
+
+// markerPacket returns a PCAP record with a marker packet for the stream with
+// the header. Long texts are truncated.
+func markerPacket(header []byte, t time.Time, text string) ([]byte, error) {
+	if len(header) < pcapHeaderSize {
+		return nil, fmt.Errorf("No data is received yet")
+	}
+
+	order := pcapByteOrder(header)
+	if order == nil {
+		return nil, fmt.Errorf("The capture is not in PCAP format")
+	}
+
+	link, err := linkHeader(order.Uint32(header[20:24]), order)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(text) > maxMarkerText {
+		text = text[:maxMarkerText]
+	}
+	payload := []byte(MarkerPrefix + text)
+
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], MarkerPort)
+	binary.BigEndian.PutUint16(udp[2:4], MarkerPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)+len(payload)))
+	// Zero UDP checksum means no checksum in IPv4
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45 // version 4, header length 20
+	binary.BigEndian.PutUint16(ip[2:4], uint16(len(ip)+len(udp)+len(payload)))
+	ip[8] = 64 // TTL
+	ip[9] = 17 // UDP
+	copy(ip[12:16], []byte{127, 0, 0, 1})
+	copy(ip[16:20], []byte{127, 0, 0, 1})
+	binary.BigEndian.PutUint16(ip[10:12], ipv4Checksum(ip))
+
+	packetLen := len(link) + len(ip) + len(udp) + len(payload)
+
+	frac := uint32(t.Nanosecond() / 1000)
+	if order.Uint32(header[0:4]) == pcapMagicNano {
+		frac = uint32(t.Nanosecond())
+	}
+
+	record := make([]byte, pcapRecordHeaderSize, pcapRecordHeaderSize+packetLen)
+	order.PutUint32(record[0:4], uint32(t.Unix()))
+	order.PutUint32(record[4:8], frac)
+	order.PutUint32(record[8:12], uint32(packetLen))
+	order.PutUint32(record[12:16], uint32(packetLen))
+
+	record = append(record, link...)
+	record = append(record, ip...)
+	record = append(record, udp...)
+	record = append(record, payload...)
+
+	return record, nil
+}
@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type bufferOutputer struct {
+	bytes.Buffer
+}
+
+func (b *bufferOutputer) Close() {
+}
+
+func TestMarkerPacket(t *testing.T) {
+	ts := time.Unix(1500000000, 123456789)
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		header := pcapStream(order)
+		order.PutUint32(header[20:24], linkTypeLinuxSLL)
+
+		record, err := markerPacket(header, ts, "clicked submit")
+		if err != nil {
+			t.Fatalf("Error creating marker: %s", err)
+		}
+
+		if sec := order.Uint32(record[0:4]); sec != 1500000000 {
+			t.Errorf("Expected seconds 1500000000, got %d", sec)
+		}
+		if usec := order.Uint32(record[4:8]); usec != 123456 {
+			t.Errorf("Expected microseconds 123456, got %d", usec)
+		}
+
+		packet := record[pcapRecordHeaderSize:]
+		if l := order.Uint32(record[8:12]); int(l) != len(packet) {
+			t.Errorf("Expected length %d, got %d", len(packet), l)
+		}
+
+		ip := packet[16:36]
+		if ipv4Checksum(ip) != 0 {
+			t.Errorf("Bad IPv4 checksum %x", binary.BigEndian.Uint16(ip[10:12]))
+		}
+		if !bytes.HasSuffix(packet, []byte(MarkerPrefix+"clicked submit")) {
+			t.Errorf("Bad payload %q", packet)
+		}
+	}
+
+	header := pcapStream(binary.LittleEndian)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicNano)
+	record, err := markerPacket(header, ts, "nano")
+	if err != nil || binary.LittleEndian.Uint32(record[4:8]) != 123456789 {
+		t.Errorf("Expected nanosecond timestamp, got %v %v", record, err)
+	}
+
+	binary.LittleEndian.PutUint32(header[20:24], 147)
+	if _, err := markerPacket(header, ts, "user"); err == nil {
+		t.Errorf("Expected error for unsupported link type")
+	}
+	if _, err := markerPacket(nil, ts, "empty"); err == nil {
+		t.Errorf("Expected error for a stream without header")
+	}
+}
+
+func TestMultiOutputMark(t *testing.T) {
+	out := &bufferOutputer{}
+	mo := NewMultiOutput(out)
+
+	if err := mo.Mark(time.Now(), "too early"); err == nil {
+		t.Errorf("Expected error for mark before the header is received")
+	}
+
+	stream := pcapStream(binary.LittleEndian, 60, 100)
+
+	// Mark in the middle of the second packet
+	mo.Write(stream[:150])
+	if err := mo.Mark(time.Now(), "in the middle"); err != nil {
+		t.Fatalf("Error marking: %s", err)
+	}
+	if out.Len() != 150 {
+		t.Errorf("The mark should wait for the end of the packet")
+	}
+	mo.Write(stream[150:])
+
+	// Mark on a packet boundary
+	if err := mo.Mark(time.Now(), "at the end"); err != nil {
+		t.Fatalf("Error marking: %s", err)
+	}
+	mo.Close()
+
+	var c pcapCounter
+	if c.feed(out.Bytes()); c.packets != 4 || !c.atBoundary() {
+		t.Errorf("Expected 4 whole packets, got %d", c.packets)
+	}
+
+	data := out.Bytes()
+	if !bytes.Equal(data[:len(stream)], stream) {
+		t.Errorf("The captured packets are changed")
+	}
+
+	first := bytes.Index(data, []byte("in the middle"))
+	second := bytes.Index(data, []byte("at the end"))
+	if first < len(stream) || second < first {
+		t.Errorf("Bad position of the marks: %d and %d", first, second)
+	}
+
+	if stats := mo.Stats(); stats.Packets != 2 {
+		t.Errorf("The marks should not be counted as received packets, got %d", stats.Packets)
+	}
+}
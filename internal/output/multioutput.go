@@ -6,7 +6,9 @@ package output
 
 import (
 	"errors"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/tdimitrov/tranqap/internal/tqlog"
 )
@@ -35,6 +37,12 @@ const (
 	// OutputerDead is generated to the MultiOutput when
 	// the Outputer process (e.g. Wireshark) dies
 	OutputerDead = iota
+	// OutputerClosed is generated to the MultiOutput when the Outputer is
+	// detached on request, e.g. when watch command is stopped
+	OutputerClosed = iota
+	// OutputerCrashed is generated to the MultiOutput when the Outputer process
+	// exits abnormally and should be restarted
+	OutputerCrashed = iota
 )
 
 // MultiOutputEvent represents the structure of the event generated from Outputer
@@ -67,6 +75,61 @@ type MultiOutput struct {
 	events          MOEventChan
 	wg              sync.WaitGroup
 	handlerFinished chan struct{}
+	pendingMarks    [][]byte          // marker packets, waiting for a packet boundary
+	waiting         map[Outputer]bool // members added in the middle of a packet
+	factories       map[Outputer]OutputerFactory
+	closing         bool
+
+	// The counters are protected by a separate mutex, so that they can be read
+	// while membersMut is held for a long time, e.g. by Close()
+	statsMut   sync.Mutex
+	bytes      uint64
+	counter    pcapCounter
+	memberInfo []MemberInfo
+	rotators   []rotationCounter // rotationCounter of each member or nil
+	dropped    uint64
+}
+
+// Kinds of the outputers, reported in MemberInfo
+const (
+	KindFile      = "file"
+	KindWireshark = "wireshark"
+	KindViewer    = "viewer"
+	KindUnknown   = "unknown"
+)
+
+// MemberInfo describes a member of MultiOutput. Path, Size and Rotations are set
+// only for file outputers. Rotations is the number of times the capture files
+// were rotated by the outputer. Name is set for viewers.
+type MemberInfo struct {
+	Kind      string
+	Name      string
+	Path      string
+	Size      int64
+	Rotations uint64
+}
+
+// MultiOutputStats contains the number of bytes and packets, written to
+// MultiOutput, and its current members. Dropped is the number of members,
+// removed because they have died (e.g. Wireshark has exited or crashed).
+// Members closed by tranqap are not counted.
+type MultiOutputStats struct {
+	Bytes   uint64
+	Packets uint64
+	Members []MemberInfo
+	Dropped uint64
+}
+
+// describer is implemented by the Outputers, which can report MemberInfo
+type describer interface {
+	describe() MemberInfo
+}
+
+// rotationCounter is implemented by the Outputers, which rotate files. The
+// count is read on each Stats() call, so that the rotations done while the
+// capture is running are reported.
+type rotationCounter interface {
+	rotationCount() uint64
 }
 
 // NewMultiOutput create new MultiOutput instance. The function receives one or more
@@ -79,7 +142,18 @@ func NewMultiOutput(outputers ...Outputer) *MultiOutput {
 		make(MOEventChan, 1),
 		sync.WaitGroup{},
 		make(chan struct{}, 1),
+		nil,
+		make(map[Outputer]bool),
+		make(map[Outputer]OutputerFactory),
+		false,
+		sync.Mutex{},
+		0,
+		pcapCounter{},
+		nil,
+		nil,
+		0,
 	}
+	ret.updateMemberInfo()
 
 	go ret.eventHandler()
 
@@ -87,28 +161,88 @@ func NewMultiOutput(outputers ...Outputer) *MultiOutput {
 }
 
 // Write delivers PCAP traffic to all Outputers. It also saves the pcap header.
+// The pending marks are inserted at the last packet boundary in p. The members
+// added in the middle of a packet start receiving data from this boundary.
 func (mo *MultiOutput) Write(p []byte) (n int, err error) {
 	// Save the header
 	currHdrLen := len(mo.pcapHeader)
 	if currHdrLen < pcapHeaderSize {
-		mo.pcapHeader = append(mo.pcapHeader, p[0:pcapHeaderSize-currHdrLen]...)
+		mo.pcapHeader = append(mo.pcapHeader, p[0:minInt(pcapHeaderSize-currHdrLen, len(p))]...)
 	}
 
-	// Forward to the capturers
+	// membersMut is held while the counter is updated, so that Mark sees the
+	// same stream as the members
 	mo.membersMut.Lock()
-	for _, o := range mo.members {
-		o.Write(p)
+	defer mo.membersMut.Unlock()
+
+	mo.statsMut.Lock()
+	mo.bytes += uint64(len(p))
+	last := mo.counter.feed(p)
+	mo.statsMut.Unlock()
+
+	rest := p
+	if last >= 0 && (len(mo.pendingMarks) > 0 || len(mo.waiting) > 0) {
+		mo.writeMembers(p[:last])
+		for _, m := range mo.pendingMarks {
+			mo.writeMembers(m)
+		}
+		mo.pendingMarks = nil
+		mo.waiting = make(map[Outputer]bool)
+		rest = p[last:]
 	}
-	mo.membersMut.Unlock()
+	mo.writeMembers(rest)
 
 	return len(p), nil
 }
 
-// Close closes all member Outputers
-func (mo *MultiOutput) Close() {
+// writeMembers forwards p to the members, which are not waiting for a packet
+// boundary. Should be called with membersMut locked.
+func (mo *MultiOutput) writeMembers(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	for _, o := range mo.members {
+		if !mo.waiting[o] {
+			o.Write(p)
+		}
+	}
+}
+
+// Mark inserts a marker packet with the text and the timestamp t in the
+// stream. If a packet is being received, the marker is inserted after it.
+func (mo *MultiOutput) Mark(t time.Time, text string) error {
 	mo.membersMut.Lock()
 	defer mo.membersMut.Unlock()
-	for _, o := range mo.members {
+
+	mo.statsMut.Lock()
+	header := append([]byte{}, mo.counter.header...)
+	atBoundary := mo.counter.atBoundary()
+	mo.statsMut.Unlock()
+
+	packet, err := markerPacket(header, t, text)
+	if err != nil {
+		return err
+	}
+
+	if atBoundary {
+		mo.writeMembers(packet)
+	} else {
+		mo.pendingMarks = append(mo.pendingMarks, packet)
+	}
+
+	return nil
+}
+
+// Close closes all member Outputers. membersMut is not held while waiting for
+// the members to stop, because the event handler needs it to remove them.
+func (mo *MultiOutput) Close() {
+	mo.membersMut.Lock()
+	mo.closing = true
+	members := append([]Outputer{}, mo.members...)
+	mo.membersMut.Unlock()
+
+	for _, o := range members {
 		o.Close()
 	}
 	mo.wg.Wait()
@@ -123,11 +257,16 @@ func (mo *MultiOutput) Close() {
 // newOutFn is a function which accepts MOEventChan and creates an
 // Outputer. This way MultiOutpit's event channel is passed to the
 // newly created Outputer, and the creation of the Outputer is
-// decoupled from MultiOutput
+// decoupled from MultiOutput. The factory is called again, if the
+// Outputer crashes (see OutputerCrashed).
 func (mo *MultiOutput) AddExtMember(newOutFn OutputerFactory) error {
 	mo.membersMut.Lock()
 	defer mo.membersMut.Unlock()
 
+	if mo.closing == true {
+		return errors.New("MultiOutput is closed")
+	}
+
 	// Create new member
 	newMember := newOutFn(mo.events)
 	if newMember == nil {
@@ -139,23 +278,94 @@ func (mo *MultiOutput) AddExtMember(newOutFn OutputerFactory) error {
 	// Send the PCAP header
 	newMember.Write(mo.pcapHeader)
 
+	// If a packet is being received, the new member gets data from the next
+	// packet, so that it receives a valid stream
+	mo.statsMut.Lock()
+	midPacket := mo.counter.order != nil && !mo.counter.atBoundary()
+	mo.statsMut.Unlock()
+	if midPacket {
+		mo.waiting[newMember] = true
+	}
+
 	// Add to members list
 	mo.members = append(mo.members, newMember)
+	mo.factories[newMember] = newOutFn
+	mo.updateMemberInfo()
 	return nil
 }
 
+// updateMemberInfo saves the description of the current members for Stats().
+// Should be called with membersMut locked.
+func (mo *MultiOutput) updateMemberInfo() {
+	info := make([]MemberInfo, 0, len(mo.members))
+	rotators := make([]rotationCounter, 0, len(mo.members))
+	for _, m := range mo.members {
+		if d, ok := m.(describer); ok {
+			info = append(info, d.describe())
+		} else {
+			info = append(info, MemberInfo{Kind: KindUnknown})
+		}
+
+		r, _ := m.(rotationCounter)
+		rotators = append(rotators, r)
+	}
+
+	mo.statsMut.Lock()
+	mo.memberInfo = info
+	mo.rotators = rotators
+	mo.statsMut.Unlock()
+}
+
+// Stats returns the counters of the MultiOutput and its members. The size and
+// the rotations of each file member are their current values.
+func (mo *MultiOutput) Stats() MultiOutputStats {
+	mo.statsMut.Lock()
+	ret := MultiOutputStats{mo.bytes, mo.counter.packets, append([]MemberInfo{}, mo.memberInfo...), mo.dropped}
+	rotators := mo.rotators
+	mo.statsMut.Unlock()
+
+	for i := range ret.Members {
+		if rotators[i] != nil {
+			ret.Members[i].Rotations = rotators[i].rotationCount()
+		}
+	}
+
+	for i := range ret.Members {
+		if len(ret.Members[i].Path) == 0 {
+			continue
+		}
+		if fi, err := os.Stat(ret.Members[i].Path); err == nil {
+			ret.Members[i].Size = fi.Size()
+		}
+	}
+
+	return ret
+}
+
 // eventHandler handles events from member Outputers
-// Effectively at the moment this function just removes dead Outputers from
-// the members slice
+// It removes the stopped Outputers from the members slice. Crashed Outputers
+// are restarted with their factory function, which replays the PCAP header.
 func (mo *MultiOutput) eventHandler() {
 	defer func() { mo.handlerFinished <- struct{}{} }()
 
 	for event := range mo.events {
 		mo.membersMut.Lock()
 
+		var respawn OutputerFactory
 		for i, c := range mo.members {
 			if c == event.from {
 				mo.members = append(mo.members[:i], mo.members[i+1:]...)
+				delete(mo.waiting, c)
+				if event.event == OutputerCrashed {
+					respawn = mo.factories[c]
+				}
+				delete(mo.factories, c)
+				mo.updateMemberInfo()
+				if event.event == OutputerDead || event.event == OutputerCrashed {
+					mo.statsMut.Lock()
+					mo.dropped++
+					mo.statsMut.Unlock()
+				}
 				tqlog.Info("Outputer stopped.")
 				mo.wg.Done()
 				break
@@ -163,5 +373,13 @@ func (mo *MultiOutput) eventHandler() {
 		}
 
 		mo.membersMut.Unlock()
+
+		if respawn != nil {
+			if err := mo.AddExtMember(respawn); err != nil {
+				tqlog.Error("Can't restart crashed outputer: %s", err)
+			} else {
+				tqlog.Info("Crashed outputer restarted.")
+			}
+		}
 	}
 }
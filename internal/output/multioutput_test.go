@@ -0,0 +1,172 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMultiOutputStats(t *testing.T) {
+	dir := getTmpDir()
+	defer cleanup(dir)
+
+	f := NewFileOutput(dir, "stats", 1)
+	if f == nil {
+		t.Fatalf("Can't create file output")
+	}
+	mo := NewMultiOutput(f)
+
+	stream := pcapStream(binary.LittleEndian, 60, 60, 100)
+	mo.Write(stream[:30])
+	mo.Write(stream[30:])
+
+	stats := mo.Stats()
+	mo.Close()
+
+	if stats.Bytes != uint64(len(stream)) {
+		t.Errorf("Expected %d bytes, got %d", len(stream), stats.Bytes)
+	}
+	if stats.Packets != 3 {
+		t.Errorf("Expected 3 packets, got %d", stats.Packets)
+	}
+
+	if len(stats.Members) != 1 {
+		t.Fatalf("Expected 1 member, got %d", len(stats.Members))
+	}
+	expected := MemberInfo{KindFile, "", dir + "/stats.pcap", int64(len(stream)), 0}
+	if stats.Members[0] != expected {
+		t.Errorf("Expected %v, got %v", expected, stats.Members[0])
+	}
+}
+
+func TestMultiOutputRotations(t *testing.T) {
+	dir := getTmpDir()
+	defer cleanup(dir)
+
+	f := NewFileOutput(dir, "rotations", 1)
+	if f == nil {
+		t.Fatalf("Can't create file output")
+	}
+	f.Close()
+
+	// The existing file is rotated when the new one is opened
+	f = NewFileOutput(dir, "rotations", 1)
+	if f == nil {
+		t.Fatalf("Can't create file output")
+	}
+	mo := NewMultiOutput(f)
+	defer mo.Close()
+
+	if r := mo.Stats().Members[0].Rotations; r != 1 {
+		t.Errorf("Expected 1 rotation, got %d", r)
+	}
+
+	// Rotations done after the member was added are reported too
+	f.(*fileOutput).rotated()
+	if r := mo.Stats().Members[0].Rotations; r != 2 {
+		t.Errorf("Expected 2 rotations, got %d", r)
+	}
+}
+
+type outputerMock struct {
+	events MOEventChan
+}
+
+func (o *outputerMock) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (o *outputerMock) Close() {
+}
+
+func TestMultiOutputDropped(t *testing.T) {
+	mo := NewMultiOutput()
+
+	var member *outputerMock
+	err := mo.AddExtMember(func(events MOEventChan) Outputer {
+		member = &outputerMock{events}
+		return member
+	})
+	if err != nil {
+		t.Fatalf("Error adding member: %s", err)
+	}
+
+	if stats := mo.Stats(); len(stats.Members) != 1 || stats.Members[0].Kind != KindUnknown {
+		t.Errorf("Expected a single member of unknown kind, got %v", stats.Members)
+	}
+
+	// The member dies
+	member.events <- MultiOutputEvent{member, OutputerDead}
+
+	for i := 0; i < 100 && mo.Stats().Dropped == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := mo.Stats()
+	if stats.Dropped != 1 || len(stats.Members) != 0 {
+		t.Errorf("Expected 1 dropped member and no members, got %d and %v", stats.Dropped, stats.Members)
+	}
+
+	mo.Close()
+}
+
+func TestMultiOutputRespawn(t *testing.T) {
+	mo := NewMultiOutput()
+	header := pcapStream(binary.LittleEndian)
+	mo.Write(header)
+
+	var members []*bufferOutputer
+	var events MOEventChan
+	created := make(chan struct{}, 2)
+	err := mo.AddExtMember(func(e MOEventChan) Outputer {
+		events = e
+		m := &bufferOutputer{}
+		members = append(members, m)
+		created <- struct{}{}
+		return m
+	})
+	if err != nil {
+		t.Fatalf("Error adding member: %s", err)
+	}
+	<-created
+
+	// A crashed member is restarted and receives the header again
+	events <- MultiOutputEvent{members[0], OutputerCrashed}
+	select {
+	case <-created:
+	case <-time.After(time.Second):
+		t.Fatalf("Crashed member was not restarted")
+	}
+
+	for i := 0; i < 100 && len(mo.Stats().Members) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := mo.Stats(); stats.Dropped != 1 || len(stats.Members) != 1 {
+		t.Errorf("Expected 1 dropped member and 1 member, got %d and %v", stats.Dropped, stats.Members)
+	}
+	if !bytes.Equal(members[1].Bytes(), header) {
+		t.Errorf("Expected the header to be replayed, got %v", members[1].Bytes())
+	}
+
+	// A member closed by the user is not restarted
+	events <- MultiOutputEvent{members[1], OutputerDead}
+	for i := 0; i < 100 && len(mo.Stats().Members) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-created:
+		t.Errorf("Dead member was restarted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mo.Close()
+
+	if err := mo.AddExtMember(func(e MOEventChan) Outputer { return &bufferOutputer{} }); err == nil {
+		t.Errorf("Expected error adding a member to closed MultiOutput")
+	}
+}
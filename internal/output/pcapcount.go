@@ -0,0 +1,104 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"encoding/binary"
+)
+
+// Each packet in a PCAP stream is preceded by a record header like this:
+//
+//	typedef struct pcaprec_hdr_s {
+//		guint32 ts_sec;         /* timestamp seconds */
+//		guint32 ts_usec;        /* timestamp microseconds */
+//		guint32 incl_len;       /* number of octets of packet saved in file */
+//		guint32 orig_len;       /* actual length of packet */
+//	} pcaprec_hdr_t;
+const pcapRecordHeaderSize = 16
+
+// Magic numbers of PCAP streams with microsecond and nanosecond timestamps
+const (
+	pcapMagic     = 0xa1b2c3d4
+	pcapMagicNano = 0xa1b23c4d
+)
+
+// pcapCounter counts the packets in a PCAP stream. The stream can be split in
+// chunks at any position.
+type pcapCounter struct {
+	header  []byte
+	order   binary.ByteOrder
+	record  []byte // partially received record header
+	skip    uint32 // bytes of the current packet, which are not received yet
+	packets uint64
+}
+
+// feed processes the next chunk of the stream. Returns the offset in p of the
+// last packet boundary - the position after the file header or after a whole
+// packet, or -1 if there is no boundary in p.
+func (c *pcapCounter) feed(p []byte) int {
+	last := -1
+
+	for off := 0; off < len(p); {
+		rest := p[off:]
+
+		if len(c.header) < pcapHeaderSize {
+			n := minInt(pcapHeaderSize-len(c.header), len(rest))
+			c.header = append(c.header, rest[:n]...)
+			off += n
+			if len(c.header) == pcapHeaderSize {
+				c.order = pcapByteOrder(c.header)
+			}
+		} else if c.order == nil {
+			// Not a PCAP stream
+			return -1
+		} else if c.skip > 0 {
+			n := minInt(int(c.skip), len(rest))
+			c.skip -= uint32(n)
+			off += n
+		} else {
+			n := minInt(pcapRecordHeaderSize-len(c.record), len(rest))
+			c.record = append(c.record, rest[:n]...)
+			off += n
+			if len(c.record) == pcapRecordHeaderSize {
+				c.skip = c.order.Uint32(c.record[8:12])
+				c.record = c.record[:0]
+				c.packets++
+			}
+		}
+
+		if c.atBoundary() {
+			last = off
+		}
+	}
+
+	return last
+}
+
+// atBoundary returns true if a whole number of packets is processed, i.e. a
+// new packet can be inserted in the stream
+func (c *pcapCounter) atBoundary() bool {
+	return c.order != nil && c.skip == 0 && len(c.record) == 0
+}
+
+// pcapByteOrder returns the byte order of a PCAP stream or nil if the header
+// doesn't contain a known magic number
+func pcapByteOrder(header []byte) binary.ByteOrder {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		magic := order.Uint32(header[0:4])
+		if magic == pcapMagic || magic == pcapMagicNano {
+			return order
+		}
+	}
+
+	return nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,76 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// pcapStream generates a PCAP stream with packets with the given lengths
+func pcapStream(order binary.ByteOrder, lengths ...int) []byte {
+	var buf bytes.Buffer
+
+	header := make([]byte, pcapHeaderSize)
+	order.PutUint32(header[0:4], pcapMagic)
+	buf.Write(header)
+
+	for _, l := range lengths {
+		record := make([]byte, pcapRecordHeaderSize)
+		order.PutUint32(record[8:12], uint32(l))
+		order.PutUint32(record[12:16], uint32(l))
+		buf.Write(record)
+		buf.Write(make([]byte, l))
+	}
+
+	return buf.Bytes()
+}
+
+func TestPcapCounter(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		stream := pcapStream(order, 60, 0, 1500, 7)
+
+		// Feed the stream in chunks of each size
+		for chunk := 1; chunk <= len(stream); chunk++ {
+			var c pcapCounter
+			for i := 0; i < len(stream); i += chunk {
+				c.feed(stream[i:minInt(i+chunk, len(stream))])
+			}
+
+			if c.packets != 4 {
+				t.Fatalf("Expected 4 packets with %s and chunk size %d, got %d", order, chunk, c.packets)
+			}
+		}
+	}
+
+	var c pcapCounter
+	c.feed(make([]byte, 100))
+	if c.packets != 0 {
+		t.Errorf("Expected no packets in a stream with bad magic, got %d", c.packets)
+	}
+}
+
+func TestPcapCounterBoundary(t *testing.T) {
+	// Boundaries at 24 (after the header), 100 and 216
+	stream := pcapStream(binary.LittleEndian, 60, 100)
+
+	var c pcapCounter
+	if last := c.feed(stream[:10]); last != -1 {
+		t.Errorf("Expected no boundary in a partial header, got %d", last)
+	}
+	if last := c.feed(stream[10:150]); last != 90 {
+		t.Errorf("Expected boundary at 90, got %d", last)
+	}
+	if c.atBoundary() {
+		t.Errorf("Unexpected boundary in the middle of a packet")
+	}
+	if last := c.feed(stream[150:]); last != 66 {
+		t.Errorf("Expected boundary at 66, got %d", last)
+	}
+	if !c.atBoundary() {
+		t.Errorf("Expected boundary at the end of the stream")
+	}
+}
@@ -0,0 +1,361 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRecordSize is the maximal size of a packet in a PCAP stream. Bigger
+// records mean that the stream is corrupted.
+const maxRecordSize = 256 * 1024
+
+// pcapParser splits a PCAP stream into packets. The stream can be split in
+// chunks at any position.
+type pcapParser struct {
+	header []byte
+	order  binary.ByteOrder
+	nano   bool
+	buf    []byte
+	broken bool
+}
+
+// linkType returns the link type of the stream. Valid after the header is parsed.
+func (pp *pcapParser) linkType() uint32 {
+	return pp.order.Uint32(pp.header[20:24])
+}
+
+// feed processes the next chunk of the stream and calls fn for each whole
+// packet with its timestamp, captured data and original length
+func (pp *pcapParser) feed(p []byte, fn func(ts time.Time, data []byte, origLen int)) {
+	if pp.broken {
+		return
+	}
+
+	if len(pp.header) < pcapHeaderSize {
+		n := minInt(pcapHeaderSize-len(pp.header), len(p))
+		pp.header = append(pp.header, p[:n]...)
+		p = p[n:]
+		if len(pp.header) < pcapHeaderSize {
+			return
+		}
+
+		if pp.order = pcapByteOrder(pp.header); pp.order == nil {
+			pp.broken = true
+			return
+		}
+		pp.nano = pp.order.Uint32(pp.header[0:4]) == pcapMagicNano
+	}
+
+	pp.buf = append(pp.buf, p...)
+	for len(pp.buf) >= pcapRecordHeaderSize {
+		inclLen := int(pp.order.Uint32(pp.buf[8:12]))
+		if inclLen > maxRecordSize {
+			pp.broken = true
+			pp.buf = nil
+			return
+		}
+		if len(pp.buf) < pcapRecordHeaderSize+inclLen {
+			break
+		}
+
+		sec := int64(pp.order.Uint32(pp.buf[0:4]))
+		frac := int64(pp.order.Uint32(pp.buf[4:8]))
+		if !pp.nano {
+			frac *= 1000
+		}
+		origLen := int(pp.order.Uint32(pp.buf[12:16]))
+
+		fn(time.Unix(sec, frac), pp.buf[pcapRecordHeaderSize:pcapRecordHeaderSize+inclLen], origLen)
+		pp.buf = pp.buf[pcapRecordHeaderSize+inclLen:]
+	}
+
+	// Don't keep the processed data in the underlying array
+	pp.buf = append([]byte{}, pp.buf...)
+}
+
+// packetSummary contains the decoded addresses and protocol of a packet. Src
+// and Dst contain the port for TCP and UDP.
+type packetSummary struct {
+	Src   string
+	Dst   string
+	Proto string
+	Info  string
+}
+
+// decodeLink returns the network protocol (an EtherType) and its payload
+func decodeLink(linkType uint32, order binary.ByteOrder, data []byte) (uint16, []byte, error) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return 0, nil, fmt.Errorf("truncated Ethernet header")
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		// Skip VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return etherType, data, nil
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return 0, nil, fmt.Errorf("truncated Linux cooked header")
+		}
+		return binary.BigEndian.Uint16(data[14:16]), data[16:], nil
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return 0, nil, fmt.Errorf("truncated Linux cooked v2 header")
+		}
+		return binary.BigEndian.Uint16(data[0:2]), data[20:], nil
+	case linkTypeRaw:
+		if len(data) == 0 {
+			return 0, nil, fmt.Errorf("empty packet")
+		}
+		if data[0]>>4 == 6 {
+			return 0x86dd, data, nil
+		}
+		return 0x0800, data, nil
+	case linkTypeIPv4:
+		return 0x0800, data, nil
+	case linkTypeIPv6:
+		return 0x86dd, data, nil
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return 0, nil, fmt.Errorf("truncated loopback header")
+		}
+		family := order.Uint32(data[0:4])
+		if linkType == linkTypeLoop {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		switch family {
+		case 2:
+			return 0x0800, data[4:], nil
+		case 10, 24, 28, 30:
+			// AF_INET6 on Linux, BSD and macOS
+			return 0x86dd, data[4:], nil
+		}
+		return 0, nil, fmt.Errorf("address family %d", family)
+	}
+
+	return 0, nil, fmt.Errorf("link type %d", linkType)
+}
+
+// decodePacket decodes the headers of a packet
+func decodePacket(linkType uint32, order binary.ByteOrder, data []byte) packetSummary {
+	etherType, payload, err := decodeLink(linkType, order, data)
+	if err != nil {
+		return packetSummary{"?", "?", "unknown", err.Error()}
+	}
+
+	switch etherType {
+	case 0x0800:
+		return decodeIPv4(payload)
+	case 0x86dd:
+		return decodeIPv6(payload)
+	case 0x0806:
+		return packetSummary{"?", "?", "ARP", ""}
+	}
+
+	return packetSummary{"?", "?", fmt.Sprintf("ethertype 0x%04x", etherType), ""}
+}
+
+func decodeIPv4(data []byte) packetSummary {
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return packetSummary{"?", "?", "IPv4", "truncated header"}
+	}
+
+	hdrLen := int(data[0]&0x0f) * 4
+	if hdrLen < 20 || len(data) < hdrLen {
+		return packetSummary{"?", "?", "IPv4", "bad header length"}
+	}
+
+	src := net.IP(data[12:16]).String()
+	dst := net.IP(data[16:20]).String()
+
+	// Only the first fragment contains the transport header
+	if binary.BigEndian.Uint16(data[6:8])&0x1fff != 0 {
+		return packetSummary{src, dst, "IPv4", "fragment"}
+	}
+
+	return decodeTransport(data[9], src, dst, data[hdrLen:])
+}
+
+func decodeIPv6(data []byte) packetSummary {
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return packetSummary{"?", "?", "IPv6", "truncated header"}
+	}
+
+	src := net.IP(data[8:24]).String()
+	dst := net.IP(data[24:40]).String()
+	next := data[6]
+	data = data[40:]
+
+	// Skip the extension headers
+	for {
+		switch next {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			if len(data) < 8 {
+				return packetSummary{src, dst, "IPv6", "truncated extension header"}
+			}
+			l := (int(data[1]) + 1) * 8
+			if len(data) < l {
+				return packetSummary{src, dst, "IPv6", "truncated extension header"}
+			}
+			next, data = data[0], data[l:]
+			continue
+		case 44: // fragment
+			if len(data) < 8 {
+				return packetSummary{src, dst, "IPv6", "truncated fragment header"}
+			}
+			if binary.BigEndian.Uint16(data[2:4])&0xfff8 != 0 {
+				return packetSummary{src, dst, "IPv6", "fragment"}
+			}
+			next, data = data[0], data[8:]
+			continue
+		}
+		break
+	}
+
+	return decodeTransport(next, src, dst, data)
+}
+
+// tcpFlags returns the TCP flags in tcpdump notation, e.g. [S.] for SYN-ACK
+func tcpFlags(flags byte) string {
+	var ret strings.Builder
+	ret.WriteString("[")
+	for _, f := range []struct {
+		mask byte
+		name string
+	}{{0x02, "S"}, {0x01, "F"}, {0x04, "R"}, {0x08, "P"}, {0x20, "U"}, {0x10, "."}} {
+		if flags&f.mask != 0 {
+			ret.WriteString(f.name)
+		}
+	}
+	ret.WriteString("]")
+
+	return ret.String()
+}
+
+// icmpTypes are the names of the common ICMP and ICMPv6 types
+var icmpTypes = map[byte]string{
+	0:   "echo reply",
+	3:   "destination unreachable",
+	5:   "redirect",
+	8:   "echo request",
+	11:  "time exceeded",
+	128: "echo request",
+	129: "echo reply",
+	133: "router solicitation",
+	134: "router advertisement",
+	135: "neighbor solicitation",
+	136: "neighbor advertisement",
+}
+
+func decodeTransport(proto byte, src string, dst string, data []byte) packetSummary {
+	switch proto {
+	case 6, 17:
+		name := "TCP"
+		minLen := 20
+		if proto == 17 {
+			name, minLen = "UDP", 8
+		}
+		if len(data) < minLen {
+			return packetSummary{src, dst, name, "truncated header"}
+		}
+
+		srcPort := strconv.Itoa(int(binary.BigEndian.Uint16(data[0:2])))
+		dstPort := strconv.Itoa(int(binary.BigEndian.Uint16(data[2:4])))
+		info := ""
+		if proto == 6 {
+			info = tcpFlags(data[13])
+		} else if payload := string(data[8:]); strings.HasPrefix(payload, MarkerPrefix) {
+			// Inserted with mark command
+			return packetSummary{src, dst, "MARK", strings.TrimPrefix(payload, MarkerPrefix)}
+		}
+		return packetSummary{net.JoinHostPort(src, srcPort), net.JoinHostPort(dst, dstPort), name, info}
+	case 1, 58:
+		name := "ICMP"
+		if proto == 58 {
+			name = "ICMPv6"
+		}
+		if len(data) < 2 {
+			return packetSummary{src, dst, name, "truncated header"}
+		}
+		info, ok := icmpTypes[data[0]]
+		if !ok || (proto == 1) != (data[0] < 128) {
+			info = fmt.Sprintf("type %d code %d", data[0], data[1])
+		}
+		return packetSummary{src, dst, name, info}
+	}
+
+	return packetSummary{src, dst, fmt.Sprintf("IP proto %d", proto), ""}
+}
+
+// SummaryOutput is an Outputer, which decodes the packets and sends a one-line
+// summary of each packet to a channel. It doesn't block the capture - if the
+// channel is full, the summary is dropped.
+type SummaryOutput struct {
+	target  string
+	lines   chan<- string
+	events  MOEventChan
+	parser  pcapParser
+	dropped uint64 // accessed atomically
+	once    sync.Once
+	done    chan struct{}
+}
+
+// NewSummaryOutput creates SummaryOutput for the capture of target
+func NewSummaryOutput(target string, lines chan<- string, eventCh MOEventChan) *SummaryOutput {
+	return &SummaryOutput{target, lines, eventCh, pcapParser{}, 0, sync.Once{}, make(chan struct{})}
+}
+
+// Write decodes the packets in p and sends their summaries
+func (so *SummaryOutput) Write(p []byte) (n int, err error) {
+	so.parser.feed(p, func(ts time.Time, data []byte, origLen int) {
+		s := decodePacket(so.parser.linkType(), so.parser.order, data)
+
+		line := fmt.Sprintf("%s <%s> %s → %s %s", ts.Format("15:04:05.000000"), so.target, s.Src, s.Dst, s.Proto)
+		if len(s.Info) > 0 {
+			line += " " + s.Info
+		}
+		line += fmt.Sprintf(" len %d", origLen)
+
+		select {
+		case so.lines <- line:
+		default:
+			atomic.AddUint64(&so.dropped, 1)
+		}
+	})
+
+	return len(p), nil
+}
+
+// Close detaches the Outputer from its MultiOutput. It can be called more
+// than once.
+func (so *SummaryOutput) Close() {
+	so.once.Do(func() {
+		close(so.done)
+		so.events <- MultiOutputEvent{so, OutputerClosed}
+	})
+}
+
+// Done returns a channel, which is closed when the Outputer is closed, e.g.
+// because the capture has stopped
+func (so *SummaryOutput) Done() <-chan struct{} {
+	return so.done
+}
+
+// Dropped returns the number of summaries, dropped because the channel was full
+func (so *SummaryOutput) Dropped() uint64 {
+	return atomic.LoadUint64(&so.dropped)
+}
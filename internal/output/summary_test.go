@@ -0,0 +1,181 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ipv4Packet(proto byte, transport []byte) []byte {
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(transport)))
+	ip[9] = proto
+	copy(ip[12:16], []byte{10, 0, 0, 1})
+	copy(ip[16:20], []byte{10, 0, 0, 2})
+	return append(ip, transport...)
+}
+
+func ipv6Packet(next byte, transport []byte) []byte {
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	ip[6] = next
+	ip[23] = 1 // ::1
+	copy(ip[24:26], []byte{0xfe, 0x80})
+	ip[39] = 2 // fe80::2
+	return append(ip, transport...)
+}
+
+func tcpHeader(src uint16, dst uint16, flags byte) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], src)
+	binary.BigEndian.PutUint16(tcp[2:4], dst)
+	tcp[13] = flags
+	return tcp
+}
+
+func udpHeader(src uint16, dst uint16) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], src)
+	binary.BigEndian.PutUint16(udp[2:4], dst)
+	return udp
+}
+
+func TestDecodePacket(t *testing.T) {
+	ethernet := make([]byte, 14)
+	binary.BigEndian.PutUint16(ethernet[12:14], 0x0800)
+
+	vlan := make([]byte, 18)
+	binary.BigEndian.PutUint16(vlan[12:14], 0x8100)
+	binary.BigEndian.PutUint16(vlan[16:18], 0x86dd)
+
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[14:16], 0x86dd)
+
+	arp := make([]byte, 14)
+	binary.BigEndian.PutUint16(arp[12:14], 0x0806)
+
+	tests := []struct {
+		linkType uint32
+		data     []byte
+		expected packetSummary
+	}{
+		{linkTypeEthernet, append(ethernet, ipv4Packet(6, tcpHeader(443, 51234, 0x12))...),
+			packetSummary{"10.0.0.1:443", "10.0.0.2:51234", "TCP", "[S.]"}},
+		{linkTypeEthernet, append(vlan, ipv6Packet(17, udpHeader(53, 5353))...),
+			packetSummary{"[::1]:53", "[fe80::2]:5353", "UDP", ""}},
+		{linkTypeLinuxSLL, append(sll, ipv6Packet(58, []byte{128, 0, 0, 0})...),
+			packetSummary{"::1", "fe80::2", "ICMPv6", "echo request"}},
+		{linkTypeRaw, ipv4Packet(1, []byte{8, 0, 0, 0}),
+			packetSummary{"10.0.0.1", "10.0.0.2", "ICMP", "echo request"}},
+		{linkTypeRaw, ipv4Packet(1, []byte{42, 1, 0, 0}),
+			packetSummary{"10.0.0.1", "10.0.0.2", "ICMP", "type 42 code 1"}},
+		{linkTypeRaw, ipv4Packet(47, nil),
+			packetSummary{"10.0.0.1", "10.0.0.2", "IP proto 47", ""}},
+		{linkTypeRaw, ipv4Packet(6, []byte{1, 2}),
+			packetSummary{"10.0.0.1", "10.0.0.2", "TCP", "truncated header"}},
+		{linkTypeEthernet, arp, packetSummary{"?", "?", "ARP", ""}},
+		{147, []byte{1}, packetSummary{"?", "?", "unknown", "link type 147"}},
+	}
+
+	for i, test := range tests {
+		s := decodePacket(test.linkType, binary.LittleEndian, test.data)
+		if s != test.expected {
+			t.Errorf("Test %d: expected %+v, got %+v", i, test.expected, s)
+		}
+	}
+}
+
+func TestSummaryOutput(t *testing.T) {
+	header := pcapStream(binary.LittleEndian)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeRaw)
+
+	stream := append([]byte{}, header...)
+	for _, p := range [][]byte{ipv4Packet(6, tcpHeader(22, 40000, 0x18)), ipv4Packet(17, udpHeader(5000, 53))} {
+		record := make([]byte, pcapRecordHeaderSize)
+		binary.LittleEndian.PutUint32(record[0:4], 1500000000)
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(p)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(p)+100))
+		stream = append(stream, record...)
+		stream = append(stream, p...)
+	}
+	marker, err := markerPacket(header, time.Unix(1500000001, 0), "clicked submit")
+	if err != nil {
+		t.Fatalf("Error creating marker: %s", err)
+	}
+	stream = append(stream, marker...)
+
+	lines := make(chan string, 2)
+	events := make(MOEventChan, 1)
+	so := NewSummaryOutput("t1", lines, events)
+
+	// Feed byte by byte
+	for i := range stream {
+		so.Write(stream[i : i+1])
+	}
+
+	ts := time.Unix(1500000000, 0).Format("15:04:05.000000")
+	expected := []string{
+		ts + " <t1> 10.0.0.1:22 → 10.0.0.2:40000 TCP [P.] len 140",
+		ts + " <t1> 10.0.0.1:5000 → 10.0.0.2:53 UDP len 128",
+	}
+	for _, e := range expected {
+		if l := <-lines; l != e {
+			t.Errorf("Expected %q, got %q", e, l)
+		}
+	}
+
+	// The channel was full when the marker was decoded
+	if so.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped summary, got %d", so.Dropped())
+	}
+
+	so.Write(marker)
+	if l := <-lines; !strings.HasSuffix(l, "<t1> 127.0.0.1 → 127.0.0.1 MARK clicked submit len 56") {
+		t.Errorf("Bad marker summary %q", l)
+	}
+
+	so.Close()
+	so.Close()
+	select {
+	case <-so.Done():
+	default:
+		t.Errorf("Expected Done to be closed")
+	}
+	if e := <-events; e.from != so || e.event != OutputerClosed {
+		t.Errorf("Expected closed event, got %v", e)
+	}
+}
+
+func TestMultiOutputJoinMidPacket(t *testing.T) {
+	mo := NewMultiOutput()
+	stream := pcapStream(binary.LittleEndian, 60, 100)
+
+	mo.Write(stream[:70])
+
+	lines := make(chan string, 10)
+	var so *SummaryOutput
+	mo.AddExtMember(func(events MOEventChan) Outputer {
+		so = NewSummaryOutput("t1", lines, events)
+		return so
+	})
+
+	mo.Write(stream[70:150])
+	mo.Write(stream[150:])
+
+	// Only the second packet is received
+	if len(lines) != 1 || !strings.HasSuffix(<-lines, "len 100") {
+		t.Errorf("Expected only the second packet")
+	}
+
+	so.Close()
+	mo.Close()
+	if stats := mo.Stats(); len(stats.Members) != 0 || stats.Dropped != 0 {
+		t.Errorf("Expected the summary output to be detached, got %+v", stats)
+	}
+}
@@ -0,0 +1,303 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tdimitrov/tranqap/internal/tqlog"
+)
+
+// FifoPlaceholder is replaced in the arguments of a viewer with the path of a
+// named pipe, from which the viewer reads the PCAP stream
+const FifoPlaceholder = "{fifo}"
+
+// minRespawnUptime is the minimal time a viewer should run before a crash, in
+// order to be restarted. Prevents restart loops of misconfigured viewers.
+var minRespawnUptime = 5 * time.Second
+
+// maxFifoPending is the maximal amount of data, buffered until the viewer opens
+// the named pipe
+const maxFifoPending = 16 * 1024 * 1024
+
+// ViewerConfig describes an external program, which reads the PCAP stream.
+// The stream is written to the stdin of the program, unless an argument
+// contains FifoPlaceholder - then it is written to a named pipe. stdout and
+// stderr of the program are appended to Log. Empty Log means they are discarded.
+// If Respawn is set, the program is restarted when it crashes.
+type ViewerConfig struct {
+	Name    string
+	Command string
+	Args    []string
+	Log     string
+	Respawn bool
+}
+
+// extOutput is an Outputer, which writes the PCAP stream to an external
+// process, e.g. Wireshark. When the process exits, one of the following events
+// is sent:
+// OutputerClosed - the outputer was closed by tranqap
+// OutputerCrashed - the process exited with an error or was killed by a signal
+// and Respawn is set
+// OutputerDead - in all other cases, e.g. the user closed the window
+type extOutput struct {
+	kind   string
+	name   string
+	w      io.WriteCloser
+	event  MOEventChan
+	closed int32
+}
+
+// NewViewerOutput starts the viewer and returns an Outputer for it. Returns
+// nil if the viewer can't be started.
+func NewViewerOutput(cfg ViewerConfig, eventCh MOEventChan) Outputer {
+	o, err := newExtOutput(KindViewer, cfg, eventCh)
+	if err != nil {
+		tqlog.Error("Can't start viewer %s: %s", cfg.Name, err)
+		return nil
+	}
+
+	return o
+}
+
+func usesFifo(args []string) bool {
+	for _, a := range args {
+		if strings.Contains(a, FifoPlaceholder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newExtOutput(kind string, cfg ViewerConfig, eventCh MOEventChan) (*extOutput, error) {
+	args := append([]string{}, cfg.Args...)
+	cmd := exec.Command(cfg.Command)
+
+	var w io.WriteCloser
+	var fw *fifoWriter
+	var cleanup func()
+	if usesFifo(args) {
+		dir, err := ioutil.TempDir("", "tranqap-viewer")
+		if err != nil {
+			return nil, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+
+		fifo := filepath.Join(dir, "capture.pcap")
+		if err := mkfifo(fifo); err != nil {
+			cleanup()
+			return nil, err
+		}
+		for i := range args {
+			args[i] = strings.Replace(args[i], FifoPlaceholder, fifo, -1)
+		}
+		fw = newFifoWriter(fifo)
+		w = fw
+	} else {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		w = stdin
+	}
+	cmd.Args = append([]string{cfg.Command}, args...)
+
+	var logFile *os.File
+	if len(cfg.Log) > 0 {
+		f, err := os.OpenFile(cfg.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			if fw != nil {
+				fw.abort()
+			}
+			if cleanup != nil {
+				cleanup()
+			}
+			return nil, fmt.Errorf("Can't open log %s: %s", cfg.Log, err)
+		}
+		logFile = f
+		cmd.Stdout = f
+		cmd.Stderr = f
+	}
+
+	if err := cmd.Start(); err != nil {
+		if fw != nil {
+			fw.abort()
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+		return nil, err
+	}
+
+	ret := &extOutput{kind, cfg.Name, w, eventCh, 0}
+	started := time.Now()
+
+	go func() {
+		err := cmd.Wait()
+		tqlog.Info("%s (pid %d) exited: %v", cfg.Name, cmd.Process.Pid, err)
+
+		event := OutputerDead
+		if atomic.LoadInt32(&ret.closed) == 1 {
+			event = OutputerClosed
+		} else if err != nil && cfg.Respawn == true {
+			if uptime := time.Since(started); uptime < minRespawnUptime {
+				tqlog.Error("%s crashed %s after start. It won't be restarted", cfg.Name, uptime.Round(time.Millisecond))
+			} else {
+				event = OutputerCrashed
+			}
+		}
+
+		if fw != nil {
+			fw.abort()
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+		eventCh <- MultiOutputEvent{ret, event}
+	}()
+
+	return ret, nil
+}
+
+func (eo *extOutput) Write(p []byte) (n int, err error) {
+	n, err = eo.w.Write(p)
+	if err != nil {
+		msg := fmt.Sprintf("Error writing to %s: %v", eo.name, err)
+		tqlog.Info(msg)
+		return n, errors.New(msg)
+	}
+	return n, nil
+}
+
+func (eo *extOutput) describe() MemberInfo {
+	return MemberInfo{Kind: eo.kind, Name: eo.name}
+}
+
+func (eo *extOutput) Close() {
+	atomic.StoreInt32(&eo.closed, 1)
+	eo.w.Close()
+}
+
+// fifoWriter writes to a named pipe. Opening a pipe for writing blocks until
+// the reader opens it, so the pipe is opened in the background and the data is
+// buffered meanwhile.
+type fifoWriter struct {
+	mut     sync.Mutex
+	path    string
+	f       *os.File
+	pending []byte
+	closed  bool
+	aborted bool
+}
+
+func newFifoWriter(path string) *fifoWriter {
+	fw := &fifoWriter{path: path}
+	go fw.open()
+
+	return fw
+}
+
+func (fw *fifoWriter) open() {
+	f, err := os.OpenFile(fw.path, os.O_WRONLY, 0)
+
+	fw.mut.Lock()
+	defer fw.mut.Unlock()
+
+	if err != nil {
+		tqlog.Error("Can't open named pipe %s: %s", fw.path, err)
+		fw.closed = true
+		return
+	}
+	if fw.aborted == true {
+		f.Close()
+		return
+	}
+
+	if _, err := f.Write(fw.pending); err != nil {
+		tqlog.Info("Error writing to named pipe %s: %s", fw.path, err)
+	}
+	fw.pending = nil
+
+	if fw.closed == true {
+		f.Close()
+		return
+	}
+	fw.f = f
+}
+
+func (fw *fifoWriter) Write(p []byte) (int, error) {
+	fw.mut.Lock()
+	defer fw.mut.Unlock()
+
+	if fw.closed == true {
+		return 0, os.ErrClosed
+	}
+
+	if fw.f == nil {
+		if len(fw.pending)+len(p) > maxFifoPending {
+			return 0, fmt.Errorf("The viewer hasn't opened %s yet", fw.path)
+		}
+		fw.pending = append(fw.pending, p...)
+		return len(p), nil
+	}
+
+	return fw.f.Write(p)
+}
+
+// Close closes the pipe. If it is not opened yet, the pending data is written
+// when the viewer opens it.
+func (fw *fifoWriter) Close() error {
+	fw.mut.Lock()
+	defer fw.mut.Unlock()
+
+	if fw.closed == true {
+		return nil
+	}
+	fw.closed = true
+
+	if fw.f != nil {
+		return fw.f.Close()
+	}
+
+	return nil
+}
+
+// abort is called when the viewer exits. If the pipe was never opened, the
+// pending open is completed by opening the pipe for reading.
+func (fw *fifoWriter) abort() {
+	fw.mut.Lock()
+	defer fw.mut.Unlock()
+
+	fw.closed = true
+	fw.aborted = true
+	fw.pending = nil
+
+	if fw.f != nil {
+		fw.f.Close()
+		fw.f = nil
+		return
+	}
+
+	if r, err := os.OpenFile(fw.path, os.O_RDONLY|nonBlockFlag, 0); err == nil {
+		r.Close()
+	}
+}
@@ -0,0 +1,124 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestViewerOutput(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "tranqap-test")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"stdin", []string{"-c", "cat"}},
+		{"fifo", []string{"-c", "cat \"$0\"", FifoPlaceholder}},
+	}
+
+	for _, tc := range tests {
+		if tc.name == "fifo" && runtime.GOOS == "windows" {
+			continue
+		}
+
+		log := filepath.Join(dir, tc.name+".log")
+		events := make(MOEventChan, 1)
+
+		o := NewViewerOutput(ViewerConfig{tc.name, "sh", tc.args, log, false}, events)
+		if o == nil {
+			t.Fatalf("%s: viewer was not started", tc.name)
+		}
+
+		if info := o.(describer).describe(); info.Kind != KindViewer || info.Name != tc.name {
+			t.Errorf("%s: unexpected description %+v", tc.name, info)
+		}
+
+		if _, err := o.Write([]byte("packets")); err != nil {
+			t.Fatalf("%s: error writing: %s", tc.name, err)
+		}
+		o.Close()
+
+		select {
+		case e := <-events:
+			if e.from != o || e.event != OutputerClosed {
+				t.Errorf("%s: unexpected event %+v", tc.name, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: viewer didn't exit", tc.name)
+		}
+
+		data, err := ioutil.ReadFile(log)
+		if err != nil {
+			t.Fatalf("%s: error reading log: %s", tc.name, err)
+		}
+		if string(data) != "packets" {
+			t.Errorf("%s: expected log 'packets', got '%s'", tc.name, data)
+		}
+	}
+}
+
+func TestViewerOutputNotFound(t *testing.T) {
+	events := make(MOEventChan, 1)
+
+	o := NewViewerOutput(ViewerConfig{"missing", "tranqap-no-such-viewer", nil, "", false}, events)
+	if o != nil {
+		t.Errorf("Expected nil outputer for missing command")
+	}
+}
+
+func TestViewerOutputExit(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	defer func(d time.Duration) { minRespawnUptime = d }(minRespawnUptime)
+
+	tests := []struct {
+		script    string
+		respawn   bool
+		minUptime time.Duration
+		event     int
+	}{
+		{"exit 0", true, 0, OutputerDead},
+		{"exit 1", false, 0, OutputerDead},
+		{"exit 1", true, 0, OutputerCrashed},
+		{"kill -9 $$", true, 0, OutputerCrashed},
+		{"exit 1", true, time.Hour, OutputerDead},
+	}
+
+	for _, tc := range tests {
+		minRespawnUptime = tc.minUptime
+		events := make(MOEventChan, 1)
+
+		o := NewViewerOutput(ViewerConfig{"test", "sh", []string{"-c", tc.script}, "", tc.respawn}, events)
+		if o == nil {
+			t.Fatalf("%s: viewer was not started", tc.script)
+		}
+
+		select {
+		case e := <-events:
+			if e.event != tc.event {
+				t.Errorf("%s (respawn %v): expected event %d, got %d", tc.script, tc.respawn, tc.event, e.event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: viewer didn't exit", tc.script)
+		}
+	}
+}
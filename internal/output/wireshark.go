@@ -4,56 +4,51 @@
 
 package output
 
-import (
-	"errors"
-	"fmt"
-	"io"
-	"os/exec"
-
-	"github.com/tdimitrov/tranqap/internal/tqlog"
-)
-
-type wsharkOutput struct {
-	pid   int
-	stdin io.WriteCloser
-	event MOEventChan
+// WsharkConfig contains the options of a Wireshark window. Title is shown in
+// the title bar, Profile is a Wireshark configuration profile, DisplayFilter is
+// applied on start and Prefs are preferences in key:value format (-o option).
+// Empty values are not passed to Wireshark. If Respawn is set, Wireshark is
+// restarted when it crashes.
+type WsharkConfig struct {
+	Title         string
+	Profile       string
+	DisplayFilter string
+	Prefs         []string
+	Respawn       bool
 }
 
-// NewWsharkOutput constructs wsharkOutput object
-func NewWsharkOutput(eventCh MOEventChan) Outputer {
-	// Fork wireshark
-	cmd := exec.Command("wireshark", "-k", "-i", "-")
+// wsharkTitlePref is the Wireshark preference, which adds a text to the title bar
+const wsharkTitlePref = "gui.window_title"
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil
-	}
+// args returns the command line arguments of Wireshark, reading the PCAP stream
+// from stdin
+func (c WsharkConfig) args() []string {
+	ret := []string{"-k", "-i", "-"}
 
-	err = cmd.Start()
-	if err != nil {
-		return nil
+	if len(c.Title) > 0 {
+		ret = append(ret, "-o", wsharkTitlePref+":"+c.Title)
+	}
+	if len(c.Profile) > 0 {
+		ret = append(ret, "-C", c.Profile)
+	}
+	if len(c.DisplayFilter) > 0 {
+		ret = append(ret, "-Y", c.DisplayFilter)
+	}
+	for _, p := range c.Prefs {
+		ret = append(ret, "-o", p)
 	}
-
-	ret := &wsharkOutput{cmd.Process.Pid, stdin, eventCh}
-
-	go func() {
-		cmd.Wait()
-		eventCh <- MultiOutputEvent{ret, OutputerDead}
-	}()
 
 	return ret
 }
 
-func (pw wsharkOutput) Write(p []byte) (n int, err error) {
-	n, err = pw.stdin.Write(p)
+// NewWsharkOutput starts Wireshark, reading the PCAP stream from stdin
+func NewWsharkOutput(cfg WsharkConfig, eventCh MOEventChan) Outputer {
+	vcfg := ViewerConfig{cfg.Title, "wireshark", cfg.args(), "", cfg.Respawn}
+
+	o, err := newExtOutput(KindWireshark, vcfg, eventCh)
 	if err != nil {
-		msg := fmt.Sprintf("Error writing: %v", err)
-		tqlog.Info(msg)
-		return n, errors.New(msg)
+		return nil
 	}
-	return n, nil
-}
 
-func (pw *wsharkOutput) Close() {
-	pw.stdin.Close()
+	return o
 }
@@ -0,0 +1,29 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package output
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWsharkArgs(t *testing.T) {
+	if args := (WsharkConfig{}).args(); !reflect.DeepEqual(args, []string{"-k", "-i", "-"}) {
+		t.Errorf("Bad default args: %v", args)
+	}
+
+	cfg := WsharkConfig{"router", "VoIP", "sip || rtp", []string{"gui.column.format:\"No.\",%m", "rtp.heuristic_rtp:TRUE"}, false}
+	expected := []string{
+		"-k", "-i", "-",
+		"-o", "gui.window_title:router",
+		"-C", "VoIP",
+		"-Y", "sip || rtp",
+		"-o", "gui.column.format:\"No.\",%m",
+		"-o", "rtp.heuristic_rtp:TRUE",
+	}
+	if args := cfg.args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Bad args: %v", args)
+	}
+}